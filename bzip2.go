@@ -0,0 +1,440 @@
+package warc
+
+import (
+	"bufio"
+	"io"
+	"sort"
+)
+
+// The standard library only decompresses bzip2. bzip2Writer is a minimal
+// encoder, favouring simplicity over compression ratio: each block is
+// coded with a single huffman table.
+
+const (
+	bzip2BlockMagic  = 0x314159265359
+	bzip2StreamMagic = 0x177245385090
+	// bzip2MaxBlock is the most run-length encoded bytes written to a block
+	// at the 900k block size
+	bzip2MaxBlock   = 900000 - 19
+	bzip2MaxCodeLen = 17
+	bzip2GroupSize  = 50
+)
+
+// bzip2Writer compresses data written to it in bzip2 format
+type bzip2Writer struct {
+	bw *bitWriter
+
+	block    []byte
+	blockCRC uint32
+	crc      uint32
+	// run-length state, runs of 4-255 bytes are encoded as 4 bytes & a count
+	runByte byte
+	runLen  int
+
+	wroteHeader bool
+	closed      bool
+}
+
+// newBzip2Writer creates a bzip2 compressor writing to w. The stream
+// is completed on Close, which doesn't close w
+func newBzip2Writer(w io.Writer) *bzip2Writer {
+	return &bzip2Writer{
+		bw:       &bitWriter{w: bufio.NewWriter(w)},
+		block:    make([]byte, 0, bzip2MaxBlock),
+		blockCRC: 0xffffffff,
+	}
+}
+
+// implements io.Writer
+func (z *bzip2Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if !z.wroteHeader {
+		z.bw.writeBits(8, 'B')
+		z.bw.writeBits(8, 'Z')
+		z.bw.writeBits(8, 'h')
+		z.bw.writeBits(8, '9')
+		z.wroteHeader = true
+	}
+	for _, b := range p {
+		if z.runLen > 0 && b == z.runByte && z.runLen < 255 {
+			z.runLen++
+		} else {
+			z.flushRun()
+			if len(z.block) >= bzip2MaxBlock-5 {
+				z.writeBlock()
+			}
+			z.runByte, z.runLen = b, 1
+		}
+		z.blockCRC = z.blockCRC<<8 ^ bzip2CRCTable[byte(z.blockCRC>>24)^b]
+	}
+	return len(p), z.bw.err
+}
+
+// Close writes any buffered data & the end of stream marker
+func (z *bzip2Writer) Close() error {
+	if z.closed {
+		return z.bw.err
+	}
+	if !z.wroteHeader {
+		z.Write(nil)
+	}
+	z.closed = true
+	z.flushRun()
+	z.writeBlock()
+	z.bw.writeBits(48, bzip2StreamMagic)
+	z.bw.writeBits(32, uint64(z.crc))
+	return z.bw.flush()
+}
+
+func (z *bzip2Writer) flushRun() {
+	if z.runLen == 0 {
+		return
+	}
+	if z.runLen < 4 {
+		for i := 0; i < z.runLen; i++ {
+			z.block = append(z.block, z.runByte)
+		}
+	} else {
+		z.block = append(z.block, z.runByte, z.runByte, z.runByte, z.runByte, byte(z.runLen-4))
+	}
+	z.runLen = 0
+}
+
+// writeBlock compresses the current block, which must not be part way
+// through a run
+func (z *bzip2Writer) writeBlock() {
+	if len(z.block) == 0 {
+		return
+	}
+	crc := ^z.blockCRC
+	z.crc = (z.crc<<1 | z.crc>>31) ^ crc
+
+	bwt, origPtr := bwtransform(z.block)
+
+	// map used byte values to a contiguous range
+	var inUse [256]bool
+	for _, b := range z.block {
+		inUse[b] = true
+	}
+	var unseqToSeq [256]uint16
+	nInUse := 0
+	for i, used := range inUse {
+		if used {
+			unseqToSeq[i] = uint16(nInUse)
+			nInUse++
+		}
+	}
+	alphaSize := nInUse + 2
+	eob := uint16(nInUse + 1)
+
+	// move-to-front & zero run-length encoding
+	mtf := make([]byte, nInUse)
+	for i := range mtf {
+		mtf[i] = byte(i)
+	}
+	syms := make([]uint16, 0, len(bwt)+1)
+	freqs := make([]int, alphaSize)
+	emit := func(s uint16) {
+		syms = append(syms, s)
+		freqs[s]++
+	}
+	zeros := 0
+	flushZeros := func() {
+		for zeros > 0 {
+			// bijective base-2, RUNA = 1 & RUNB = 2
+			if zeros&1 == 1 {
+				emit(0)
+				zeros = (zeros - 1) / 2
+			} else {
+				emit(1)
+				zeros = (zeros - 2) / 2
+			}
+		}
+	}
+	for _, b := range bwt {
+		s := byte(unseqToSeq[b])
+		i := 0
+		for mtf[i] != s {
+			i++
+		}
+		copy(mtf[1:i+1], mtf[:i])
+		mtf[0] = s
+		if i == 0 {
+			zeros++
+			continue
+		}
+		flushZeros()
+		emit(uint16(i + 1))
+	}
+	flushZeros()
+	emit(eob)
+
+	lengths := huffmanLengths(freqs, bzip2MaxCodeLen)
+	codes := canonicalCodes(lengths)
+
+	bw := z.bw
+	bw.writeBits(48, bzip2BlockMagic)
+	bw.writeBits(32, uint64(crc))
+	bw.writeBits(1, 0) // not randomised
+	bw.writeBits(24, uint64(origPtr))
+
+	var ranges uint64
+	for i := 0; i < 16; i++ {
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				ranges |= 1 << uint(15-i)
+				break
+			}
+		}
+	}
+	bw.writeBits(16, ranges)
+	for i := 0; i < 16; i++ {
+		if ranges&(1<<uint(15-i)) == 0 {
+			continue
+		}
+		var used uint64
+		for j := 0; j < 16; j++ {
+			if inUse[i*16+j] {
+				used |= 1 << uint(15-j)
+			}
+		}
+		bw.writeBits(16, used)
+	}
+
+	// the format requires at least two tables, both are identical and
+	// every group selects the first
+	const nGroups = 2
+	nSelectors := (len(syms) + bzip2GroupSize - 1) / bzip2GroupSize
+	bw.writeBits(3, nGroups)
+	bw.writeBits(15, uint64(nSelectors))
+	for i := 0; i < nSelectors; i++ {
+		bw.writeBits(1, 0)
+	}
+	for t := 0; t < nGroups; t++ {
+		cur := lengths[0]
+		bw.writeBits(5, uint64(cur))
+		for _, l := range lengths {
+			for cur < l {
+				bw.writeBits(2, 2)
+				cur++
+			}
+			for cur > l {
+				bw.writeBits(2, 3)
+				cur--
+			}
+			bw.writeBits(1, 0)
+		}
+	}
+	for _, s := range syms {
+		bw.writeBits(uint(lengths[s]), uint64(codes[s]))
+	}
+
+	z.block = z.block[:0]
+	z.blockCRC = 0xffffffff
+}
+
+// bwtransform computes the Burrows-Wheeler transform of data, sorting all
+// cyclic rotations by prefix doubling. It returns the last column of the
+// sorted rotations & the row holding the original data
+func bwtransform(data []byte) ([]byte, int) {
+	n := len(data)
+	p := make([]int, n)  // rotation start positions in sorted order
+	c := make([]int, n)  // equivalence class of each rotation
+	pn := make([]int, n) // scratch
+	cn := make([]int, n)
+
+	count := make([]int, 256)
+	for _, b := range data {
+		count[b]++
+	}
+	for i := 1; i < 256; i++ {
+		count[i] += count[i-1]
+	}
+	for i := n - 1; i >= 0; i-- {
+		count[data[i]]--
+		p[count[data[i]]] = i
+	}
+	classes := 1
+	c[p[0]] = 0
+	for i := 1; i < n; i++ {
+		if data[p[i]] != data[p[i-1]] {
+			classes++
+		}
+		c[p[i]] = classes - 1
+	}
+
+	count = make([]int, n)
+	for k := 1; k < n && classes < n; k <<= 1 {
+		// rotations are already sorted by their second half
+		for i := range p {
+			pn[i] = p[i] - k
+			if pn[i] < 0 {
+				pn[i] += n
+			}
+		}
+		for i := 0; i < classes; i++ {
+			count[i] = 0
+		}
+		for _, i := range pn {
+			count[c[i]]++
+		}
+		for i := 1; i < classes; i++ {
+			count[i] += count[i-1]
+		}
+		for i := n - 1; i >= 0; i-- {
+			count[c[pn[i]]]--
+			p[count[c[pn[i]]]] = pn[i]
+		}
+		cn[p[0]] = 0
+		classes = 1
+		for i := 1; i < n; i++ {
+			cur, prev := p[i], p[i-1]
+			if c[cur] != c[prev] || c[(cur+k)%n] != c[(prev+k)%n] {
+				classes++
+			}
+			cn[cur] = classes - 1
+		}
+		c, cn = cn, c
+	}
+
+	out := make([]byte, n)
+	origPtr := 0
+	for i, start := range p {
+		if start == 0 {
+			origPtr = i
+			out[i] = data[n-1]
+		} else {
+			out[i] = data[start-1]
+		}
+	}
+	return out, origPtr
+}
+
+// huffmanLengths computes huffman code lengths no longer than maxLen for
+// the given symbol frequencies. Every symbol is given a code
+func huffmanLengths(freqs []int, maxLen int) []uint8 {
+	n := len(freqs)
+	weights := make([]int, n)
+	for i, f := range freqs {
+		weights[i] = f
+		if weights[i] == 0 {
+			weights[i] = 1
+		}
+	}
+
+	lengths := make([]uint8, n)
+	for {
+		type node struct {
+			weight      int
+			left, right int // child indexes, -1 for leaves
+		}
+		nodes := make([]node, 0, 2*n)
+		active := make([]int, 0, n)
+		for _, w := range weights {
+			nodes = append(nodes, node{w, -1, -1})
+			active = append(active, len(nodes)-1)
+		}
+		for len(active) > 1 {
+			sort.SliceStable(active, func(i, j int) bool { return nodes[active[i]].weight < nodes[active[j]].weight })
+			a, b := active[0], active[1]
+			nodes = append(nodes, node{nodes[a].weight + nodes[b].weight, a, b})
+			active = append(active[2:], len(nodes)-1)
+		}
+
+		tooLong := false
+		var walk func(i int, depth uint8)
+		walk = func(i int, depth uint8) {
+			if nodes[i].left < 0 {
+				if depth == 0 {
+					depth = 1
+				}
+				lengths[i] = depth
+				if int(depth) > maxLen {
+					tooLong = true
+				}
+				return
+			}
+			walk(nodes[i].left, depth+1)
+			walk(nodes[i].right, depth+1)
+		}
+		walk(active[0], 0)
+		if !tooLong {
+			return lengths
+		}
+		// flatten the distribution & try again
+		for i := range weights {
+			weights[i] = 1 + weights[i]/2
+		}
+	}
+}
+
+// canonicalCodes assigns huffman codes to symbols in order of code length,
+// then symbol value
+func canonicalCodes(lengths []uint8) []uint32 {
+	codes := make([]uint32, len(lengths))
+	code := uint32(0)
+	for l := uint8(1); l <= 32; l++ {
+		for i, sl := range lengths {
+			if sl == l {
+				codes[i] = code
+				code++
+			}
+		}
+		code <<= 1
+	}
+	return codes
+}
+
+// bitWriter writes big-endian bit strings
+type bitWriter struct {
+	w     *bufio.Writer
+	bits  uint64
+	nbits uint
+	err   error
+}
+
+func (b *bitWriter) writeBits(n uint, v uint64) {
+	for n > 0 {
+		take := n
+		if take > 32 {
+			take = 32
+		}
+		n -= take
+		b.bits = b.bits<<take | (v>>n)&(1<<take-1)
+		b.nbits += take
+		for b.nbits >= 8 {
+			b.nbits -= 8
+			if err := b.w.WriteByte(byte(b.bits >> b.nbits)); err != nil && b.err == nil {
+				b.err = err
+			}
+		}
+	}
+}
+
+func (b *bitWriter) flush() error {
+	if b.nbits > 0 {
+		b.writeBits(8-b.nbits, 0)
+	}
+	if err := b.w.Flush(); err != nil && b.err == nil {
+		b.err = err
+	}
+	return b.err
+}
+
+// bzip2CRCTable is the MSB-first CRC-32 table for polynomial 0x04c11db7
+var bzip2CRCTable = func() (t [256]uint32) {
+	for i := range t {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
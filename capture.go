@@ -4,14 +4,18 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -20,6 +24,13 @@ import (
 // TimeFormat is time.RFC3339, but with no timezone (just a Z).
 const TimeFormat = "2006-01-02T15:04:05Z"
 
+// httpProtocols maps HTTP versions that are recorded in HTTP/1.1 form to
+// their WARC-Protocol ids
+var httpProtocols = map[string]string{
+	"HTTP/2.0": "h2",
+	"HTTP/3.0": "h3",
+}
+
 // CaptureHelper is used for the NewRequestResponseRecords() method. Additional
 // fields may be added in the future.
 type CaptureHelper struct {
@@ -27,12 +38,63 @@ type CaptureHelper struct {
 	RemoteAddr string
 
 	// The request body will need to be read multiple times, so please provide
-	// one of the following.  (note: bytes.Reader and strings.Reader are
-	// ReadSeekers.)
+	// one of the following, or call BufferRequestBody before sending the
+	// request.  (note: bytes.Reader and strings.Reader are ReadSeekers.)
 	ReqBodyReadSeeker  io.ReadSeeker
 	ReqBodyBytesBuffer *bytes.Buffer
+
+	// ReqBodyMemLimit is the size in bytes up to which BufferRequestBody holds
+	// a request body in memory, larger bodies are spilled to a temporary
+	// file. DefaultReqBodyMemLimit if 0
+	ReqBodyMemLimit int64
+	// TempDir is the directory spilled request bodies are written to,
+	// os.TempDir() if empty
+	TempDir string
+
+	// TLSState holds the details of the TLS session a response was received
+	// over, for NewTLSMetadataRecord. Copy it from http.Response.TLS, as
+	// RecordingTransport does. DialTLSContext saves the state of the last
+	// connection it made, which is only the response's with a Transport
+	// that makes one connection at a time
+	TLSState *tls.ConnectionState
+
+	// MaxBodySize limits the bytes of the response body recorded, no limit if
+	// 0. Reading stops at the limit & the response record is marked with a
+	// WARC-Truncated field of "length"
+	MaxBodySize int64
+	// MaxDuration limits the time spent reading the response body, no limit
+	// if 0. Reading stops once it's passed, closing the body to interrupt a
+	// blocked read, & the response record is marked with a WARC-Truncated
+	// field of "time"
+	MaxDuration time.Duration
+
+	reqBodyFile *os.File
+}
+
+// DefaultReqBodyMemLimit is the default size in bytes up to which
+// CaptureHelper.BufferRequestBody holds request bodies in memory
+const DefaultReqBodyMemLimit = 1 << 20
+
+// ErrRequestBodyNotRewindable is the cause of a RequestBodyError when a
+// request has a body but no way to read it again was provided
+var ErrRequestBodyNotRewindable = errors.New("request body can't be rewound, use BufferRequestBody or provide a copy in the CaptureHelper")
+
+// RequestBodyError is returned when a request body can't be buffered or
+// read again to record it
+type RequestBodyError struct {
+	Err error
 }
 
+func (e *RequestBodyError) Error() string {
+	return "capturehelper: request body: " + e.Err.Error()
+}
+
+// Cause gives the underlying error, for errors.Cause
+func (e *RequestBodyError) Cause() error { return e.Err }
+
+// Unwrap gives the underlying error, for errors.Is & errors.As
+func (e *RequestBodyError) Unwrap() error { return e.Err }
+
 // DialContext returns a wrapper around net.DialContext that saves the
 // connected-to IP in the CaptureHelper.
 func (c *CaptureHelper) DialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
@@ -48,25 +110,130 @@ func (c *CaptureHelper) DialContext(dialer *net.Dialer) func(ctx context.Context
 	}
 }
 
-func (c *CaptureHelper) resetRequestBody(req *http.Request) io.Reader {
+// DialTLSContext returns a function for http.Transport.DialTLSContext that
+// makes TLS connections using config, saving the connected-to IP and the
+// TLS session details in the CaptureHelper. HTTP/1.1 is offered to servers
+// if config doesn't list any NextProtos.
+//
+// The saved details are of the last connection made, which a Transport
+// pooling connections may not have used for a given response. Prefer
+// copying http.Response.TLS to TLSState.
+func (c *CaptureHelper) DialTLSContext(dialer *net.Dialer, config *tls.Config) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dial := c.DialContext(dialer)
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg := &tls.Config{}
+		if config != nil {
+			cfg = config.Clone()
+		}
+		if len(cfg.NextProtos) == 0 {
+			// negotiate a protocol, so it's recorded in the TLS state
+			cfg.NextProtos = []string{"http/1.1"}
+		}
+		if cfg.ServerName == "" {
+			if cfg.ServerName, _, err = net.SplitHostPort(addr); err != nil {
+				cfg.ServerName = addr
+			}
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		state := tlsConn.ConnectionState()
+		c.TLSState = &state
+		return tlsConn, nil
+	}
+}
+
+// BufferRequestBody reads the body of req so it can be both sent & recorded,
+// replacing it with a rewindable copy. Bodies up to ReqBodyMemLimit bytes are
+// held in memory, larger ones are written to a temporary file that's removed
+// by Close. Call it before sending the request
+func (c *CaptureHelper) BufferRequestBody(req *http.Request) error {
 	if req.Body == nil || req.Body == http.NoBody {
-		return http.NoBody
+		return nil
+	}
+	defer req.Body.Close()
+	limit := c.ReqBodyMemLimit
+	if limit == 0 {
+		limit = DefaultReqBodyMemLimit
+	}
+
+	buf := &bytes.Buffer{}
+	size, err := io.CopyN(buf, req.Body, limit+1)
+	if err != nil && err != io.EOF {
+		return &RequestBodyError{err}
+	}
+	body := io.ReadSeeker(bytes.NewReader(buf.Bytes()))
+	if size > limit {
+		f, err := ioutil.TempFile(c.TempDir, "warc-request-body-")
+		if err != nil {
+			return &RequestBodyError{err}
+		}
+		c.reqBodyFile = f
+		if _, err := buf.WriteTo(f); err != nil {
+			return &RequestBodyError{err}
+		}
+		n, err := io.Copy(f, req.Body)
+		if err != nil {
+			return &RequestBodyError{err}
+		}
+		size += n
+		body = f
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return &RequestBodyError{err}
+	}
+
+	c.ReqBodyReadSeeker = body
+	req.ContentLength = size
+	req.Body = ioutil.NopCloser(body)
+	req.GetBody = func() (io.ReadCloser, error) {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(body), nil
+	}
+	return nil
+}
+
+// Close removes any temporary file written by BufferRequestBody
+func (c *CaptureHelper) Close() error {
+	if c.reqBodyFile == nil {
+		return nil
+	}
+	f := c.reqBodyFile
+	c.reqBodyFile = nil
+	c.ReqBodyReadSeeker = nil
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func (c *CaptureHelper) resetRequestBody(req *http.Request) (io.Reader, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return http.NoBody, nil
 	}
 	if req.GetBody != nil {
 		body, err := req.GetBody()
 		if err != nil {
-			panic(err)
+			return nil, &RequestBodyError{err}
 		}
-		return body
+		return body, nil
 	}
 	if c.ReqBodyReadSeeker != nil {
-		c.ReqBodyReadSeeker.Seek(0, io.SeekStart)
-		return c.ReqBodyReadSeeker
+		if _, err := c.ReqBodyReadSeeker.Seek(0, io.SeekStart); err != nil {
+			return nil, &RequestBodyError{err}
+		}
+		return c.ReqBodyReadSeeker, nil
 	}
 	if c.ReqBodyBytesBuffer != nil {
-		return c.ReqBodyBytesBuffer
+		return c.ReqBodyBytesBuffer, nil
 	}
-	panic("capturehelper: unable to rewind request body")
+	return nil, &RequestBodyError{ErrRequestBodyNotRewindable}
 }
 
 // NewRequestResponseRecords creates a new request/response record pair for the
@@ -76,6 +243,10 @@ func (c *CaptureHelper) resetRequestBody(req *http.Request) io.Reader {
 // from again.  The response Body should not yet have been used; if the caller
 // needs the body, replace it with an ioutil.NopCloser(io.TeeReader) (the
 // caller is then responsible for calling body.Close()).
+//
+// Exchanges made over HTTP/2 or HTTP/3 are written as HTTP/1.1 messages, so
+// replay tools can read them, with the original protocol recorded in a
+// WARC-Protocol field of "h2" or "h3".
 func NewRequestResponseRecords(info CaptureHelper, req *http.Request, resp *http.Response) (Record, Record, error) {
 	reqRec := Record{Format: RecordFormatWarc, Type: RecordTypeRequest, Headers: make(Header)}
 	respRec := Record{Format: RecordFormatWarc, Type: RecordTypeResponse, Headers: make(Header)}
@@ -116,12 +287,13 @@ func NewRequestResponseRecords(info CaptureHelper, req *http.Request, resp *http
 	// Write request using stdlib
 	reqDigester := sha1.New()
 	reqRec.Content = new(bytes.Buffer)
-	clonedBody := info.resetRequestBody(req)
-	if clonedBody != nil {
-		teedBody := io.TeeReader(clonedBody, reqDigester)
-		req.Body = ioutil.NopCloser(teedBody)
+	clonedBody, err := info.resetRequestBody(req)
+	if err != nil {
+		return reqRec, respRec, err
 	}
-	err := req.Write(reqRec.Content)
+	teedBody := io.TeeReader(clonedBody, reqDigester)
+	req.Body = ioutil.NopCloser(teedBody)
+	err = req.Write(reqRec.Content)
 	reqRec.Headers.Set(FieldNameWARCPayloadDigest, formatDigest(reqDigester.Sum(nil)))
 	if err != nil {
 		return reqRec, respRec, errors.Wrap(err, "writing request body")
@@ -132,19 +304,124 @@ func NewRequestResponseRecords(info CaptureHelper, req *http.Request, resp *http
 	// Can't use stdlib, as it does extra processing of Content-Length, transfer encodings, etc
 	respDigester := sha1.New()
 	respRec.Content = new(bytes.Buffer)
-	teedBody := io.TeeReader(resp.Body, respDigester)
 
 	text := resp.Status
 	text = strings.TrimPrefix(text, strconv.Itoa(resp.StatusCode)+" ")
-	fmt.Fprintf(respRec.Content, "HTTP/%d.%d %03d %s\r\n", resp.ProtoMajor, resp.ProtoMinor, resp.StatusCode, text)
+	proto := fmt.Sprintf("HTTP/%d.%d", resp.ProtoMajor, resp.ProtoMinor)
+	if id, ok := httpProtocols[proto]; ok {
+		// replay tools only read HTTP/1.x messages
+		proto = "HTTP/1.1"
+		reqRec.Headers.Set(FieldNameWARCProtocol, id)
+		respRec.Headers.Set(FieldNameWARCProtocol, id)
+	}
+	fmt.Fprintf(respRec.Content, "%s %03d %s\r\n", proto, resp.StatusCode, text)
 	resp.Header.Write(respRec.Content)
 	io.WriteString(respRec.Content, "\r\n")
-	_, err = io.Copy(respRec.Content, teedBody)
+	truncated, err := info.copyResponseBody(io.MultiWriter(respRec.Content, respDigester), resp.Body)
+	// the payload digest is of the stored body, truncated or not
 	respRec.Headers.Set(FieldNameWARCPayloadDigest, formatDigest(respDigester.Sum(nil)))
 	if err != nil {
 		return reqRec, respRec, errors.Wrap(err, "writing response body")
 	}
+	if truncated != "" {
+		respRec.Headers.Set(FieldNameWARCTruncated, truncated)
+	}
 	// block digest will be set in Record.Write()
 
 	return reqRec, respRec, nil
 }
+
+// copyResponseBody copies body to w within the MaxBodySize & MaxDuration
+// limits, returning the WARC-Truncated reason if a limit stopped the copy
+func (c *CaptureHelper) copyResponseBody(w io.Writer, body io.ReadCloser) (truncated string, err error) {
+	r := io.Reader(body)
+	var dr *deadlineReader
+	if c.MaxDuration > 0 {
+		dr = &deadlineReader{r: body, deadline: time.Now().Add(c.MaxDuration)}
+		timer := time.AfterFunc(c.MaxDuration, func() {
+			dr.expired.Store(true)
+			body.Close()
+		})
+		defer timer.Stop()
+		r = dr
+	}
+	if c.MaxBodySize > 0 {
+		r = io.LimitReader(r, c.MaxBodySize)
+	}
+
+	n, err := io.Copy(w, r)
+	if err != nil && dr != nil && dr.expired.Load() {
+		return "time", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if c.MaxBodySize > 0 && n == c.MaxBodySize {
+		// the body was cut short if there's anything left to read
+		if m, _ := io.ReadFull(body, make([]byte, 1)); m > 0 {
+			return "length", nil
+		}
+	}
+	return "", nil
+}
+
+// errCaptureTimeout is returned by a deadlineReader past its deadline
+var errCaptureTimeout = errors.New("capture time limit exceeded")
+
+// deadlineReader stops reading from r once a deadline passes
+type deadlineReader struct {
+	r        io.Reader
+	deadline time.Time
+	expired  atomic.Bool
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	if d.expired.Load() || time.Now().After(d.deadline) {
+		d.expired.Store(true)
+		return 0, errCaptureTimeout
+	}
+	return d.r.Read(p)
+}
+
+// NewTLSMetadataRecord creates a metadata record of the TLS session details
+// in info.TLSState, linked to resp with WARC-Concurrent-To. The record block
+// is application/warc-fields, listing the TLS version, cipher suite,
+// negotiated ALPN protocol, server name, whether the session was resumed,
+// and the peer certificate chain as base64 encoded DER, leaf first:
+//
+//	tlsVersion: TLS 1.3
+//	cipherSuite: TLS_AES_128_GCM_SHA256
+//	alpnProtocol: http/1.1
+//	serverName: example.com
+//	didResume: false
+//	peerCertificate: MIIC...
+//	peerCertificate: MIID...
+func NewTLSMetadataRecord(info CaptureHelper, resp Record) (Record, error) {
+	rec := Record{Format: RecordFormatWarc, Type: RecordTypeMetadata, Headers: make(Header), Content: new(bytes.Buffer)}
+	state := info.TLSState
+	if state == nil {
+		return rec, errors.New("CaptureHelper has no TLS session")
+	}
+	rec.Headers.Set(FieldNameWARCRecordID, NewUUID())
+	rec.Headers.Set(FieldNameWARCConcurrentTo, resp.Headers.Get(FieldNameWARCRecordID))
+	rec.Headers.Set(FieldNameWARCTargetURI, resp.TargetURI())
+	rec.Headers.Set(FieldNameWARCDate, resp.Headers.Get(FieldNameWARCDate))
+	if info.WarcinfoID != "" {
+		rec.Headers.Set(FieldNameWARCWarcinfoID, info.WarcinfoID)
+	}
+	rec.Headers.Set(FieldNameContentType, "application/warc-fields")
+
+	fmt.Fprintf(rec.Content, "tlsVersion: %s\r\n", tls.VersionName(state.Version))
+	fmt.Fprintf(rec.Content, "cipherSuite: %s\r\n", tls.CipherSuiteName(state.CipherSuite))
+	if state.NegotiatedProtocol != "" {
+		fmt.Fprintf(rec.Content, "alpnProtocol: %s\r\n", state.NegotiatedProtocol)
+	}
+	if state.ServerName != "" {
+		fmt.Fprintf(rec.Content, "serverName: %s\r\n", state.ServerName)
+	}
+	fmt.Fprintf(rec.Content, "didResume: %t\r\n", state.DidResume)
+	for _, cert := range state.PeerCertificates {
+		fmt.Fprintf(rec.Content, "peerCertificate: %s\r\n", base64.StdEncoding.EncodeToString(cert.Raw))
+	}
+	return rec, nil
+}
@@ -2,11 +2,20 @@ package warc
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
 func TestRequestResponseRecords(t *testing.T) {
@@ -95,3 +104,284 @@ func TestRequestResponseRecords(t *testing.T) {
 
 	// t.Logf("%#v", respRecord.Content)
 }
+
+func TestRequestResponseRecordsTruncated(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if r.URL.Path == "/short" {
+			fmt.Fprint(w, "0123456789")
+			return
+		}
+		// an endless stream
+		for {
+			if _, err := fmt.Fprint(w, "0123456789"); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		path        string
+		maxSize     int64
+		maxDuration time.Duration
+		truncated   string
+		size        int
+	}{
+		{"/short", 10, 0, "", 10},
+		{"/short", 0, time.Second, "", 10},
+		{"/short", 5, 0, "length", 5},
+		{"/endless", 25, 0, "length", 25},
+		{"/endless", 0, 50 * time.Millisecond, "time", -1},
+		{"/endless", 1 << 20, 50 * time.Millisecond, "time", -1},
+	}
+
+	for i, c := range cases {
+		req, err := http.NewRequest("GET", srv.URL+c.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info := CaptureHelper{MaxBodySize: c.maxSize, MaxDuration: c.maxDuration}
+		_, rec, err := NewRequestResponseRecords(info, req, res)
+		res.Body.Close()
+		if err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+
+		if got := rec.Headers.Get(FieldNameWARCTruncated); got != c.truncated {
+			t.Errorf("case %d truncated mismatch. expected: %q, got: %q", i, c.truncated, got)
+		}
+		body := rec.Content.Bytes()
+		body = body[bytes.Index(body, []byte("\r\n\r\n"))+4:]
+		if c.size >= 0 && len(body) != c.size {
+			t.Errorf("case %d body size mismatch. expected: %d, got: %d", i, c.size, len(body))
+		}
+		if c.size < 0 && len(body) == 0 {
+			t.Errorf("case %d expected body to be recorded before the time limit", i)
+		}
+		if got := rec.Headers.Get(FieldNameWARCPayloadDigest); got != Sha1Digest(body) {
+			t.Errorf("case %d payload digest mismatch. expected: %s, got: %s", i, Sha1Digest(body), got)
+		}
+	}
+}
+
+func TestRequestResponseRecordsHTTP2(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, r.Proto)
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	h1 := httptest.NewServer(srv.Config.Handler)
+	defer h1.Close()
+
+	cases := []struct {
+		client *http.Client
+		url    string
+		proto  string
+		body   string
+	}{
+		{srv.Client(), srv.URL, "h2", "HTTP/2.0"},
+		{h1.Client(), h1.URL, "", "HTTP/1.1"},
+	}
+	for i, c := range cases {
+		req, err := http.NewRequest("GET", c.url+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := c.client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqRec, resRec, err := NewRequestResponseRecords(CaptureHelper{}, req, res)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, rec := range []Record{reqRec, resRec} {
+			if got := rec.Headers.Get(FieldNameWARCProtocol); got != c.proto {
+				t.Errorf("case %d %s protocol mismatch. expected: %q, got: %q", i, rec.Type, c.proto, got)
+			}
+		}
+		if !strings.HasPrefix(resRec.Content.String(), "HTTP/1.1 200 OK\r\n") {
+			t.Errorf("case %d expected HTTP/1.1 status line, got: %q", i, resRec.Content.String())
+		}
+		hr, err := resRec.HTTPResponse()
+		if err != nil {
+			t.Fatalf("case %d error reading recorded response: %s", i, err)
+		}
+		if body, _ := ioutil.ReadAll(hr.Body); string(body) != c.body {
+			t.Errorf("case %d body mismatch. expected: %q, got: %q", i, c.body, body)
+		}
+	}
+}
+
+func TestBufferRequestBody(t *testing.T) {
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received = append(received, string(body))
+	}))
+	defer srv.Close()
+
+	// replay the POST requests of post-test.warc.gz with bodies that can only
+	// be read once, held in memory & spilled to a file
+	var bodies []string
+	for _, rec := range readTestRecords(t, "testdata/warcio/post-test.warc.gz") {
+		if rec.Type != RecordTypeRequest {
+			continue
+		}
+		content := rec.Content.String()
+		bodies = append(bodies, strings.TrimSpace(content[strings.Index(content, "\r\n\r\n")+4:]))
+	}
+	if len(bodies) == 0 {
+		t.Fatal("expected request records in post-test.warc.gz")
+	}
+
+	for i, memLimit := range []int64{0, 4} {
+		for j, body := range bodies {
+			req, err := http.NewRequest("POST", srv.URL+"/post", ioutil.NopCloser(strings.NewReader(body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			info := CaptureHelper{ReqBodyMemLimit: memLimit, TempDir: t.TempDir()}
+			if err := info.BufferRequestBody(req); err != nil {
+				t.Fatal(err)
+			}
+			spilled := info.reqBodyFile
+			if memLimit > 0 && spilled == nil {
+				t.Errorf("case %d.%d expected body to be spilled to a file", i, j)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			reqRec, _, err := NewRequestResponseRecords(info, req, res)
+			res.Body.Close()
+			if err != nil {
+				t.Fatalf("case %d.%d unexpected error: %s", i, j, err)
+			}
+			if got := received[len(received)-1]; got != body {
+				t.Errorf("case %d.%d sent body mismatch. expected: %q, got: %q", i, j, body, got)
+			}
+			if !strings.HasSuffix(reqRec.Content.String(), "\r\n\r\n"+body) {
+				t.Errorf("case %d.%d recorded body mismatch. expected suffix: %q, got: %q", i, j, body, reqRec.Content.String())
+			}
+			if got := reqRec.Headers.Get(FieldNameWARCPayloadDigest); got != Sha1Digest([]byte(body)) {
+				t.Errorf("case %d.%d payload digest mismatch. expected: %s, got: %s", i, j, Sha1Digest([]byte(body)), got)
+			}
+
+			if err := info.Close(); err != nil {
+				t.Error(err)
+			}
+			if spilled != nil {
+				if _, err := os.Stat(spilled.Name()); !os.IsNotExist(err) {
+					t.Errorf("case %d.%d expected temporary file to be removed", i, j)
+				}
+			}
+		}
+	}
+}
+
+func TestRequestBodyError(t *testing.T) {
+	getBodyErr := fmt.Errorf("no body for you")
+	cases := []struct {
+		getBody func() (io.ReadCloser, error)
+		cause   error
+	}{
+		{nil, ErrRequestBodyNotRewindable},
+		{func() (io.ReadCloser, error) { return nil, getBodyErr }, getBodyErr},
+	}
+
+	for i, c := range cases {
+		req := httptest.NewRequest("POST", "http://example.com/post", ioutil.NopCloser(strings.NewReader("foo=bar")))
+		req.GetBody = c.getBody
+		res := &http.Response{StatusCode: http.StatusOK, ProtoMajor: 1, ProtoMinor: 1, Header: http.Header{}, Body: http.NoBody}
+
+		_, _, err := NewRequestResponseRecords(CaptureHelper{}, req, res)
+		bodyErr, ok := err.(*RequestBodyError)
+		if !ok {
+			t.Errorf("case %d expected a *RequestBodyError, got: %#v", i, err)
+			continue
+		}
+		if errors.Cause(bodyErr) != c.cause {
+			t.Errorf("case %d cause mismatch. expected: %s, got: %s", i, c.cause, errors.Cause(bodyErr))
+		}
+	}
+}
+
+func TestTLSMetadataRecord(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secure")
+	}))
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	helper := CaptureHelper{}
+	client := &http.Client{
+		Transport: &http.Transport{
+			// no NextProtos, http/1.1 should be negotiated by default
+			DialTLSContext: helper.DialTLSContext(nil, &tls.Config{RootCAs: roots}),
+		},
+	}
+	req, err := http.NewRequest("GET", srv.URL+"/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if helper.TLSState == nil {
+		t.Fatal("expected DialTLSContext to save TLS state")
+	}
+	_, respRecord, err := NewRequestResponseRecords(helper, req, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if respRecord.Headers.Get(FieldNameWARCIPAddress) != "127.0.0.1" {
+		t.Errorf("ip address mismatch. got: %s", respRecord.Headers.Get(FieldNameWARCIPAddress))
+	}
+
+	md, err := NewTLSMetadataRecord(helper, respRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md.Type != RecordTypeMetadata || md.Headers.Get(FieldNameContentType) != "application/warc-fields" {
+		t.Errorf("expected warc-fields metadata record, got: %s %s", md.Type, md.Headers.Get(FieldNameContentType))
+	}
+	if md.Headers.Get(FieldNameWARCConcurrentTo) != respRecord.Headers.Get(FieldNameWARCRecordID) {
+		t.Error("expected metadata to be concurrent to response")
+	}
+	if md.TargetURI() != srv.URL+"/a" {
+		t.Errorf("target uri mismatch. got: %s", md.TargetURI())
+	}
+
+	fields := md.Content.String()
+	for _, line := range []string{
+		"tlsVersion: TLS 1.3\r\n",
+		"cipherSuite: " + tls.CipherSuiteName(helper.TLSState.CipherSuite) + "\r\n",
+		"alpnProtocol: http/1.1\r\n",
+		"peerCertificate: " + base64.StdEncoding.EncodeToString(srv.Certificate().Raw) + "\r\n",
+	} {
+		if !strings.Contains(fields, line) {
+			t.Errorf("expected fields to contain %q, got: %q", line, fields)
+		}
+	}
+
+	if _, err := NewTLSMetadataRecord(CaptureHelper{}, respRecord); err == nil {
+		t.Error("expected error without TLS state")
+	}
+}
@@ -0,0 +1,264 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TimestampFormat is the 14-digit UTC timestamp used by CDX indexes and
+// wayback-style URLs
+const TimestampFormat = "20060102150405"
+
+// CDXJRecord is a single line of a CDXJ index, locating one capture
+// within a collection of WARC files. Lines take the form:
+//
+//	com,example)/ 20170306040206 {"url": "http://example.com/", ...}
+type CDXJRecord struct {
+	// SURT-formatted key for the captured URL
+	SURT string `json:"-"`
+	// 14 digit capture timestamp
+	Timestamp string `json:"-"`
+
+	URL      string `json:"url"`
+	Mime     string `json:"mime,omitempty"`
+	Status   string `json:"status,omitempty"`
+	Digest   string `json:"digest,omitempty"`
+	Length   int64  `json:"length,string"`
+	Offset   int64  `json:"offset,string"`
+	Filename string `json:"filename"`
+}
+
+// NewCDXJRecord creates an index entry for a record written to filename at
+// offset, occupying length bytes
+func NewCDXJRecord(rec *Record, filename string, offset, length int64) (*CDXJRecord, error) {
+	surt, err := SURT(rec.TargetURI())
+	if err != nil {
+		return nil, err
+	}
+	c := &CDXJRecord{
+		SURT:      surt,
+		Timestamp: rec.Date().UTC().Format(TimestampFormat),
+		URL:       rec.TargetURI(),
+		Filename:  filename,
+		Offset:    offset,
+		Length:    length,
+	}
+
+	switch rec.Type {
+	case RecordTypeResponse, RecordTypeRevisit:
+		if res, err := rec.HTTPResponse(); err == nil {
+			res.Body.Close()
+			c.Status = strconv.Itoa(res.StatusCode)
+			c.Mime = mediaType(res.Header.Get("Content-Type"))
+		}
+		if rec.Type == RecordTypeRevisit {
+			c.Mime = "warc/revisit"
+		}
+	default:
+		c.Mime = mediaType(rec.Headers.Get(FieldNameContentType))
+	}
+
+	c.Digest = rec.Headers.Get(FieldNameWARCPayloadDigest)
+	if c.Digest == "" && rec.Type != RecordTypeRevisit {
+		c.Digest = payloadDigest(rec)
+	}
+	c.Digest = strings.TrimPrefix(c.Digest, "sha1:")
+	return c, nil
+}
+
+// Time gives the capture time of the indexed record, returning the zero time
+// if the timestamp is invalid
+func (c *CDXJRecord) Time() time.Time {
+	t, err := time.Parse(TimestampFormat, c.Timestamp)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// String formats the record as a CDXJ line, without a trailing newline
+func (c *CDXJRecord) String() string {
+	data, _ := json.Marshal(c)
+	return fmt.Sprintf("%s %s %s", c.SURT, c.Timestamp, data)
+}
+
+// ParseCDXJRecord parses a single line of a CDXJ index
+func ParseCDXJRecord(line string) (*CDXJRecord, error) {
+	parts := strings.SplitN(strings.TrimSpace(line), " ", 3)
+	if len(parts) != 3 {
+		return nil, errors.Errorf("warc: invalid cdxj line: '%s'", line)
+	}
+	c := &CDXJRecord{}
+	if err := json.Unmarshal([]byte(parts[2]), c); err != nil {
+		return nil, errors.Wrap(err, "warc: invalid cdxj line")
+	}
+	c.SURT = parts[0]
+	c.Timestamp = parts[1]
+	return c, nil
+}
+
+// CDXJIndex is a sorted list of CDXJ index records
+type CDXJIndex []*CDXJRecord
+
+// ReadCDXJ reads a CDXJ index from r. Blank lines and lines starting with
+// "!" (metadata) are skipped.
+func ReadCDXJ(r io.Reader) (CDXJIndex, error) {
+	idx := CDXJIndex{}
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "!") {
+			continue
+		}
+		c, err := ParseCDXJRecord(line)
+		if err != nil {
+			return nil, err
+		}
+		idx = append(idx, c)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	idx.Sort()
+	return idx, nil
+}
+
+// IndexWARC builds an index of the response, revisit, and resource records in
+// a WARC file, recording locations with the given filename. Compressed WARCs
+// must be gzipped per-record.
+func IndexWARC(r io.Reader, filename string) (CDXJIndex, error) {
+	rdr, err := NewOffsetReader(r)
+	if err != nil {
+		return nil, err
+	}
+	idx := CDXJIndex{}
+	for {
+		rec, start, end, err := rdr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch rec.Type {
+		case RecordTypeResponse, RecordTypeRevisit, RecordTypeResource:
+		default:
+			continue
+		}
+		if end < 0 {
+			return nil, errors.Errorf("warc: record %s at offset %d isn't in its own gzip member, cannot be indexed", rec.ID(), start)
+		}
+		c, err := NewCDXJRecord(rec, filename, start, end-start)
+		if err != nil {
+			return nil, err
+		}
+		idx = append(idx, c)
+	}
+	idx.Sort()
+	return idx, nil
+}
+
+// Write the index to w in CDXJ format
+func (idx CDXJIndex) Write(w io.Writer) error {
+	for _, c := range idx {
+		if _, err := io.WriteString(w, c.String()+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sort orders the index by SURT key, then timestamp
+func (idx CDXJIndex) Sort() {
+	sort.SliceStable(idx, func(i, j int) bool {
+		if idx[i].SURT == idx[j].SURT {
+			return idx[i].Timestamp < idx[j].Timestamp
+		}
+		return idx[i].SURT < idx[j].SURT
+	})
+}
+
+// Merge combines indexes, returning a new sorted index
+func (idx CDXJIndex) Merge(others ...CDXJIndex) CDXJIndex {
+	res := append(CDXJIndex{}, idx...)
+	for _, o := range others {
+		res = append(res, o...)
+	}
+	res.Sort()
+	return res
+}
+
+// Captures returns all captures of rawurl, ordered by timestamp
+func (idx CDXJIndex) Captures(rawurl string) CDXJIndex {
+	surt, err := SURT(rawurl)
+	if err != nil {
+		return nil
+	}
+	i := sort.Search(len(idx), func(i int) bool { return idx[i].SURT >= surt })
+	j := i
+	for j < len(idx) && idx[j].SURT == surt {
+		j++
+	}
+	return idx[i:j]
+}
+
+// Closest returns the capture of rawurl nearest in time to t, or nil
+// if no captures exist
+func (idx CDXJIndex) Closest(rawurl string, t time.Time) *CDXJRecord {
+	var (
+		closest *CDXJRecord
+		min     time.Duration
+	)
+	for _, c := range idx.Captures(rawurl) {
+		d := c.Time().Sub(t)
+		if d < 0 {
+			d = -d
+		}
+		if closest == nil || d < min {
+			closest, min = c, d
+		}
+	}
+	return closest
+}
+
+// Original finds the capture that a revisit record refers to, matching by URL
+// and payload digest. returns nil if no capture is found.
+func (idx CDXJIndex) Original(revisit *CDXJRecord) *CDXJRecord {
+	var orig *CDXJRecord
+	for _, c := range idx.Captures(revisit.URL) {
+		if c.Digest == revisit.Digest && c.Mime != "warc/revisit" && c.Timestamp <= revisit.Timestamp {
+			orig = c
+		}
+	}
+	return orig
+}
+
+// mediaType strips any parameters from a content-type value
+func mediaType(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+// payloadDigest calculates the digest of a record's payload
+func payloadDigest(rec *Record) string {
+	body := rec.Content.Bytes()
+	switch rec.Type {
+	case RecordTypeResponse, RecordTypeRequest:
+		if i := bytes.Index(body, doubleCrlf); i >= 0 {
+			body = body[i+len(doubleCrlf):]
+		}
+	}
+	return Sha1Digest(body)
+}
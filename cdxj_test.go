@@ -0,0 +1,94 @@
+package warc
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIndexWARC(t *testing.T) {
+	idx := testIndex(t)
+	if len(idx) != 2 {
+		t.Fatalf("wrong number of index records. expected: %d, got: %d", 2, len(idx))
+	}
+
+	first := idx[0]
+	if first.SURT != "com,example)/" {
+		t.Errorf("surt mismatch. expected: %s, got: %s", "com,example)/", first.SURT)
+	}
+	if first.Timestamp != "20170306040206" {
+		t.Errorf("timestamp mismatch. expected: %s, got: %s", "20170306040206", first.Timestamp)
+	}
+	if first.Mime != "text/html" || first.Status != "200" {
+		t.Errorf("expected text/html 200 response, got: %s %s", first.Mime, first.Status)
+	}
+	if first.Digest != "G7HRM7BGOKSKMSXZAHMUQTTV53QOFSMK" {
+		t.Errorf("digest mismatch. got: %s", first.Digest)
+	}
+	if idx[1].Mime != "warc/revisit" {
+		t.Errorf("expected second record to be a revisit, got: %s", idx[1].Mime)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := idx.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadCDXJ(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(idx) {
+		t.Fatalf("round trip length mismatch. expected: %d, got: %d", len(idx), len(read))
+	}
+	for i, c := range read {
+		if c.String() != idx[i].String() {
+			t.Errorf("case %d round trip mismatch. expected: %s, got: %s", i, idx[i], c)
+		}
+	}
+}
+
+func TestCDXJIndexClosest(t *testing.T) {
+	idx := testIndex(t)
+	cases := []struct {
+		url    string
+		t      string
+		expect string
+	}{
+		{"http://example.com/", "20170306040206", "20170306040206"},
+		{"http://www.example.com", "2016", "20170306040206"},
+		{"example.com/", "2018", "20170306040348"},
+		{"http://example.com/missing", "2017", ""},
+	}
+
+	for i, c := range cases {
+		ts, _ := time.Parse(TimestampFormat, padTimestamp(c.t))
+		got := idx.Closest(c.url, ts)
+		if got == nil {
+			if c.expect != "" {
+				t.Errorf("case %d expected a capture, got nil", i)
+			}
+			continue
+		}
+		if got.Timestamp != c.expect {
+			t.Errorf("case %d mismatch. expected: %s, got: %s", i, c.expect, got.Timestamp)
+		}
+	}
+
+	if orig := idx.Original(idx[1]); orig != idx[0] {
+		t.Errorf("expected revisit to resolve to original capture")
+	}
+}
+
+func testIndex(t *testing.T) CDXJIndex {
+	f, err := os.Open("testdata/warcio/example.warc.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	idx, err := IndexWARC(f, "example.warc.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
@@ -0,0 +1,423 @@
+package warc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CDXFields lists the field names of an index record, in the order they're
+// written by text & cdxj output
+var CDXFields = []string{"urlkey", "timestamp", "url", "mime", "status", "digest", "length", "offset", "filename"}
+
+// cdxFieldAliases maps alternate (OpenWayback) field names to CDXFields
+var cdxFieldAliases = map[string]string{
+	"original":   "url",
+	"mimetype":   "mime",
+	"statuscode": "status",
+}
+
+// Field gets the value of a named field, returning false if the name is not
+// a valid field
+func (c *CDXJRecord) Field(name string) (string, bool) {
+	if alias, ok := cdxFieldAliases[name]; ok {
+		name = alias
+	}
+	switch name {
+	case "urlkey":
+		return c.SURT, true
+	case "timestamp":
+		return c.Timestamp, true
+	case "url":
+		return c.URL, true
+	case "mime":
+		return c.Mime, true
+	case "status":
+		return c.Status, true
+	case "digest":
+		return c.Digest, true
+	case "length":
+		return strconv.FormatInt(c.Length, 10), true
+	case "offset":
+		return strconv.FormatInt(c.Offset, 10), true
+	case "filename":
+		return c.Filename, true
+	default:
+		return "", false
+	}
+}
+
+// MatchType determines how a CDXQuery URL is compared to index keys
+type MatchType string
+
+const (
+	// MatchExact only matches captures of the exact url
+	MatchExact MatchType = "exact"
+	// MatchPrefix matches all urls that start with the query url
+	MatchPrefix MatchType = "prefix"
+	// MatchHost matches all urls on the same host
+	MatchHost MatchType = "host"
+	// MatchDomain matches all urls on the same host and any subdomains
+	MatchDomain MatchType = "domain"
+)
+
+// CDXQuery is a query against a CDXJ index, see ParseCDXQuery
+type CDXQuery struct {
+	URL       string
+	MatchType MatchType
+	// From & To are inclusive timestamp bounds, which may be partial
+	From, To string
+	Filters  []CDXFilter
+	// Collapse drops results where the collapse field matches the
+	// previous result
+	Collapse *CDXCollapse
+	// Limit is the maximum number of results, 0 is unlimited
+	Limit int
+}
+
+// CDXFilter matches a single field of an index record, parsed from strings
+// of the form [!][~|=]field:expression. With no prefix expression is a
+// regular expression, "~" matches if the field contains expression, "=" only
+// matches exact values, and "!" inverts the match.
+type CDXFilter struct {
+	Field  string
+	Invert bool
+	Match  func(value string) bool
+}
+
+// ParseCDXFilter parses a filter string
+func ParseCDXFilter(s string) (CDXFilter, error) {
+	f := CDXFilter{}
+	if strings.HasPrefix(s, "!") {
+		f.Invert = true
+		s = s[1:]
+	}
+	mode := ""
+	if strings.HasPrefix(s, "~") || strings.HasPrefix(s, "=") {
+		mode = s[:1]
+		s = s[1:]
+	}
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return f, errors.Errorf("invalid filter: '%s'", s)
+	}
+	if _, ok := (&CDXJRecord{}).Field(parts[0]); !ok {
+		return f, errors.Errorf("invalid filter field: '%s'", parts[0])
+	}
+	f.Field = parts[0]
+
+	expr := parts[1]
+	switch mode {
+	case "~":
+		f.Match = func(v string) bool { return strings.Contains(v, expr) }
+	case "=":
+		f.Match = func(v string) bool { return v == expr }
+	default:
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return f, errors.Wrap(err, "invalid filter expression")
+		}
+		f.Match = re.MatchString
+	}
+	return f, nil
+}
+
+// Matches reports whether the filter accepts c
+func (f CDXFilter) Matches(c *CDXJRecord) bool {
+	v, _ := c.Field(f.Field)
+	return f.Match(v) != f.Invert
+}
+
+// CDXCollapse compares the first Length characters of a field. a Length of
+// 0 compares the entire value
+type CDXCollapse struct {
+	Field  string
+	Length int
+}
+
+func (c *CDXCollapse) key(rec *CDXJRecord) string {
+	v, _ := rec.Field(c.Field)
+	if c.Length > 0 && len(v) > c.Length {
+		v = v[:c.Length]
+	}
+	return v
+}
+
+// ParseCDXQuery reads a query from CDX server API parameters
+func ParseCDXQuery(params url.Values) (*CDXQuery, error) {
+	q := &CDXQuery{
+		URL:       params.Get("url"),
+		MatchType: MatchType(params.Get("matchType")),
+		From:      params.Get("from"),
+		To:        params.Get("to"),
+	}
+	if q.URL == "" {
+		return nil, errors.New("url param is required")
+	}
+
+	// wildcard urls: example.com/* and *.example.com
+	if q.MatchType == "" {
+		switch {
+		case strings.HasSuffix(q.URL, "*"):
+			q.MatchType = MatchPrefix
+			q.URL = strings.TrimSuffix(q.URL, "*")
+		case strings.HasPrefix(q.URL, "*."):
+			q.MatchType = MatchDomain
+			q.URL = strings.TrimPrefix(q.URL, "*.")
+		default:
+			q.MatchType = MatchExact
+		}
+	}
+	switch q.MatchType {
+	case MatchExact, MatchPrefix, MatchHost, MatchDomain:
+	default:
+		return nil, errors.Errorf("invalid matchType: '%s'", q.MatchType)
+	}
+
+	for _, ts := range []string{q.From, q.To} {
+		if _, err := strconv.ParseUint(ts, 10, 64); ts != "" && (err != nil || len(ts) > 14) {
+			return nil, errors.Errorf("invalid timestamp: '%s'", ts)
+		}
+	}
+
+	for _, fs := range params["filter"] {
+		f, err := ParseCDXFilter(fs)
+		if err != nil {
+			return nil, err
+		}
+		q.Filters = append(q.Filters, f)
+	}
+
+	if c := params.Get("collapse"); c != "" {
+		parts := strings.SplitN(c, ":", 2)
+		q.Collapse = &CDXCollapse{Field: parts[0]}
+		if _, ok := (&CDXJRecord{}).Field(parts[0]); !ok {
+			return nil, errors.Errorf("invalid collapse field: '%s'", parts[0])
+		}
+		if len(parts) == 2 {
+			n, err := strconv.Atoi(parts[1])
+			if err != nil || n < 0 {
+				return nil, errors.Errorf("invalid collapse length: '%s'", parts[1])
+			}
+			q.Collapse.Length = n
+		}
+	}
+
+	if l := params.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid limit: '%s'", l)
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+// Query returns the index records matching q
+func (idx CDXJIndex) Query(q *CDXQuery) (CDXJIndex, error) {
+	surt, err := SURT(q.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid url")
+	}
+
+	var match func(key string) bool
+	switch q.MatchType {
+	case MatchPrefix:
+		if !strings.HasSuffix(q.URL, "/") {
+			// SURT adds a trailing slash to urls without a path
+			surt = strings.TrimSuffix(surt, "/")
+		}
+		match = func(key string) bool { return strings.HasPrefix(key, surt) }
+	case MatchHost:
+		surt = surtHost(surt)
+		match = func(key string) bool { return surtHost(key) == surt }
+	case MatchDomain:
+		surt = surtHost(surt)
+		match = func(key string) bool {
+			host := surtHost(key)
+			return host == surt || strings.HasPrefix(host, surt+",")
+		}
+	default:
+		match = func(key string) bool { return key == surt }
+	}
+
+	from := padTimestamp(q.From)
+	to := ""
+	if q.To != "" {
+		const pad = "99991231235959"
+		to = q.To + pad[len(q.To):]
+	}
+
+	res := CDXJIndex{}
+	prevCollapse := ""
+	i := sort.Search(len(idx), func(i int) bool { return idx[i].SURT >= surt })
+	for ; i < len(idx); i++ {
+		c := idx[i]
+		if !strings.HasPrefix(c.SURT, surt) {
+			break
+		}
+		if !match(c.SURT) {
+			continue
+		}
+		if (q.From != "" && c.Timestamp < from) || (to != "" && c.Timestamp > to) {
+			continue
+		}
+		if !matchesFilters(c, q.Filters) {
+			continue
+		}
+		if q.Collapse != nil {
+			key := q.Collapse.key(c)
+			if len(res) > 0 && key == prevCollapse {
+				continue
+			}
+			prevCollapse = key
+		}
+		res = append(res, c)
+		if q.Limit > 0 && len(res) >= q.Limit {
+			break
+		}
+	}
+	return res, nil
+}
+
+func matchesFilters(c *CDXJRecord, filters []CDXFilter) bool {
+	for _, f := range filters {
+		if !f.Matches(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// surtHost gives the host portion of a SURT key, without port
+func surtHost(surt string) string {
+	if i := strings.IndexByte(surt, ')'); i >= 0 {
+		surt = surt[:i]
+	}
+	if i := strings.IndexByte(surt, ':'); i >= 0 {
+		surt = surt[:i]
+	}
+	return surt
+}
+
+// CDXServer is an http.Handler implementing the CDX server API used by pywb
+// and OpenWayback. Supported query params are:
+//
+//	url        url to query, wildcards "example.com/*" and "*.example.com" imply a matchType
+//	matchType  one of exact (default), prefix, host, domain
+//	from, to   (partial) timestamp bounds
+//	filter     [!][~|=]field:expression, may be repeated
+//	collapse   field[:N], skips results where the first N characters of field repeat
+//	limit      max number of results
+//	output     one of text (default), json (one object per line), cdxj
+//	fl         comma-separated list of fields to output
+type CDXServer struct {
+	Index CDXJIndex
+}
+
+// NewCDXServer creates a CDX server for an index
+func NewCDXServer(idx CDXJIndex) *CDXServer {
+	return &CDXServer{Index: idx}
+}
+
+// ServeHTTP implements http.Handler
+func (s *CDXServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q, err := ParseCDXQuery(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	fields := CDXFields
+	if fl := params.Get("fl"); fl != "" {
+		fields = strings.Split(fl, ",")
+		for _, f := range fields {
+			if _, ok := (&CDXJRecord{}).Field(f); !ok {
+				http.Error(w, fmt.Sprintf("invalid field: '%s'", f), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	var write func(w io.Writer, c *CDXJRecord, fields []string) error
+	switch params.Get("output") {
+	case "", "text":
+		w.Header().Set("Content-Type", "text/plain")
+		write = writeCDXText
+	case "json":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		write = writeCDXJSON
+	case "cdxj":
+		w.Header().Set("Content-Type", "text/x-cdxj")
+		write = writeCDXJ
+	default:
+		http.Error(w, fmt.Sprintf("invalid output: '%s'", params.Get("output")), http.StatusBadRequest)
+		return
+	}
+
+	res, err := s.Index.Query(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, c := range res {
+		if err := write(w, c, fields); err != nil {
+			return
+		}
+	}
+}
+
+// writeCDXText writes space-separated field values, using "-" for empty values
+func writeCDXText(w io.Writer, c *CDXJRecord, fields []string) error {
+	vals := make([]string, len(fields))
+	for i, f := range fields {
+		if vals[i], _ = c.Field(f); vals[i] == "" {
+			vals[i] = "-"
+		}
+	}
+	_, err := io.WriteString(w, strings.Join(vals, " ")+"\n")
+	return err
+}
+
+// writeCDXJSON writes a json object of field values
+func writeCDXJSON(w io.Writer, c *CDXJRecord, fields []string) error {
+	obj := map[string]string{}
+	for _, f := range fields {
+		obj[f], _ = c.Field(f)
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// writeCDXJ writes a cdxj line, including only the requested fields in the
+// json block
+func writeCDXJ(w io.Writer, c *CDXJRecord, fields []string) error {
+	obj := map[string]string{}
+	for _, f := range fields {
+		if f == "urlkey" || f == "timestamp" {
+			continue
+		}
+		if v, _ := c.Field(f); v != "" {
+			obj[f] = v
+		}
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s %s %s\n", c.SURT, c.Timestamp, data)
+	return err
+}
@@ -0,0 +1,92 @@
+package warc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testCDXJ = `com,example)/ 20170101000000 {"url": "http://example.com/", "mime": "text/html", "status": "200", "digest": "AAA", "length": "100", "offset": "0", "filename": "a.warc.gz"}
+com,example)/ 20170601000000 {"url": "http://example.com/", "mime": "text/html", "status": "200", "digest": "AAA", "length": "100", "offset": "100", "filename": "a.warc.gz"}
+com,example)/ 20180101000000 {"url": "http://example.com/", "mime": "text/html", "status": "301", "digest": "BBB", "length": "100", "offset": "200", "filename": "b.warc.gz"}
+com,example)/about 20170101000000 {"url": "http://example.com/about", "mime": "text/html", "status": "200", "digest": "CCC", "length": "100", "offset": "300", "filename": "a.warc.gz"}
+com,example)/style.css 20170101000000 {"url": "http://example.com/style.css", "mime": "text/css", "status": "200", "digest": "DDD", "length": "100", "offset": "400", "filename": "a.warc.gz"}
+com,example,blog)/ 20170101000000 {"url": "http://blog.example.com/", "mime": "text/html", "status": "404", "digest": "EEE", "length": "100", "offset": "500", "filename": "a.warc.gz"}
+com,examples)/ 20170101000000 {"url": "http://examples.com/", "mime": "text/html", "status": "200", "digest": "FFF", "length": "100", "offset": "600", "filename": "a.warc.gz"}
+`
+
+func TestCDXServer(t *testing.T) {
+	idx, err := ReadCDXJ(strings.NewReader(testCDXJ))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewCDXServer(idx)
+
+	cases := []struct {
+		query  string
+		status int
+		expect string
+	}{
+		{"url=example.com&fl=timestamp", 200, "20170101000000\n20170601000000\n20180101000000\n"},
+		{"url=http://www.example.com/&from=2017&to=2017&fl=timestamp", 200, "20170101000000\n20170601000000\n"},
+		{"url=example.com&from=201706&fl=offset", 200, "100\n200\n"},
+		{"url=example.com/*&fl=url", 200, "http://example.com/\nhttp://example.com/\nhttp://example.com/\nhttp://example.com/about\nhttp://example.com/style.css\n"},
+		{"url=example.com/ab&matchType=prefix&fl=url", 200, "http://example.com/about\n"},
+		{"url=example.com&matchType=host&collapse=urlkey&fl=url", 200, "http://example.com/\nhttp://example.com/about\nhttp://example.com/style.css\n"},
+		{"url=*.example.com&collapse=urlkey&fl=url", 200, "http://example.com/\nhttp://example.com/about\nhttp://example.com/style.css\nhttp://blog.example.com/\n"},
+		{"url=example.com&matchType=domain&filter=mime:css&fl=url,mime", 200, "http://example.com/style.css text/css\n"},
+		{"url=example.com&matchType=domain&filter=!status:200&fl=url,statuscode", 200, "http://example.com/ 301\nhttp://blog.example.com/ 404\n"},
+		{"url=example.com/*&filter==mime:text/html&filter=~url:about&fl=url", 200, "http://example.com/about\n"},
+		{"url=example.com&collapse=digest:2&fl=digest", 200, "AAA\nBBB\n"},
+		{"url=example.com&limit=1", 200, "com,example)/ 20170101000000 http://example.com/ text/html 200 AAA 100 0 a.warc.gz\n"},
+		{"url=example.com&limit=1&output=cdxj&fl=url,status", 200, `com,example)/ 20170101000000 {"status":"200","url":"http://example.com/"}` + "\n"},
+		{"url=example.com/missing", 200, ""},
+		{"", 400, ""},
+		{"url=example.com&matchType=nope", 400, ""},
+		{"url=example.com&filter=nope:x", 400, ""},
+		{"url=example.com&fl=nope", 400, ""},
+		{"url=example.com&output=xml", 400, ""},
+		{"url=example.com&limit=x", 400, ""},
+		{"url=example.com&from=2017-01", 400, ""},
+	}
+
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/cdx?"+c.query, nil))
+		if w.Code != c.status {
+			t.Errorf("case %d status mismatch. expected: %d, got: %d", i, c.status, w.Code)
+			continue
+		}
+		if c.status == http.StatusOK && w.Body.String() != c.expect {
+			t.Errorf("case %d mismatch.\nexpected:\n%s\ngot:\n%s", i, c.expect, w.Body.String())
+		}
+	}
+}
+
+func TestCDXServerJSON(t *testing.T) {
+	idx, err := ReadCDXJ(strings.NewReader(testCDXJ))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	NewCDXServer(idx).ServeHTTP(w, httptest.NewRequest("GET", "/cdx?url=example.com/about&output=json", nil))
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line of output, got: %d", len(lines))
+	}
+	obj := map[string]string{}
+	if err := json.Unmarshal([]byte(lines[0]), &obj); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range CDXFields {
+		if _, ok := obj[f]; !ok {
+			t.Errorf("expected json output to include field: %s", f)
+		}
+	}
+	if obj["urlkey"] != "com,example)/about" || obj["length"] != "100" {
+		t.Errorf("unexpected json output: %s", lines[0])
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/datatogether/warc"
+)
+
+var catCmd = &command{
+	Name:  "cat",
+	Usage: "[-offset N | -id ID] [-block] FILE",
+	Short: "dump a single record, selected by offset or record id",
+	Flags: func(fs *flag.FlagSet) {
+		fs.Int64Var(&catFlags.offset, "offset", -1, "offset of the record, as reported by ls")
+		fs.StringVar(&catFlags.id, "id", "", "WARC-Record-ID of the record")
+		fs.BoolVar(&catFlags.block, "block", false, "only print the record content block, omitting WARC headers")
+	},
+	Run: runCat,
+}
+
+var catFlags struct {
+	offset int64
+	id     string
+	block  bool
+}
+
+func runCat(fs *flag.FlagSet, out io.Writer) error {
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	rec, err := selectRecord(fs.Arg(0), catFlags.offset, catFlags.id)
+	if err != nil {
+		return err
+	}
+	if catFlags.block {
+		_, err = out.Write(rec.Content.Bytes())
+		return err
+	}
+	return rec.Write(out)
+}
+
+// selectRecord finds the record in the file at path starting at offset, or
+// with the given record id. exactly one of offset (>= 0) or id is required
+func selectRecord(path string, offset int64, id string) (*warc.Record, error) {
+	id = normalizeID(id)
+	if (offset < 0) == (id == "") {
+		return nil, fmt.Errorf("exactly one of -offset or -id is required")
+	}
+
+	var found *warc.Record
+	err := eachRecord(path, func(rec *warc.Record, start, end int64) error {
+		if (id != "" && rec.ID() == id) || (offset >= 0 && start == offset) {
+			found = rec
+			return errStop
+		}
+		if offset >= 0 && start > offset {
+			return errStop
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		if id != "" {
+			return nil, fmt.Errorf("no record with id %s", id)
+		}
+		return nil, fmt.Errorf("no record at offset %d", offset)
+	}
+	return found, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/datatogether/warc"
+)
+
+var diffCmd = &command{
+	Name:  "diff",
+	Usage: "[-text] [-json] OLD NEW",
+	Short: "compare the captures in two WARC files, listing added, removed and modified urls",
+	Flags: func(fs *flag.FlagSet) {
+		fs.BoolVar(&diffFlags.text, "text", false, "include changes to the visible text of HTML pages")
+		fs.BoolVar(&diffFlags.json, "json", false, "write differences as JSON")
+	},
+	Run: runDiff,
+}
+
+var diffFlags struct {
+	text, json bool
+}
+
+func runDiff(fs *flag.FlagSet, out io.Writer) error {
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("diff: expected 2 arguments")
+	}
+	older, err := openInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer older.Close()
+	newer, err := openInput(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer newer.Close()
+
+	d := &warc.Differ{TextDiff: diffFlags.text}
+	diffs, err := d.Diff(older, newer)
+	if err != nil {
+		return err
+	}
+	if diffFlags.json {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diffs)
+	}
+	return warc.WriteDiff(out, diffs)
+}
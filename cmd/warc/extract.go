@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/datatogether/warc"
+)
+
+var extractCmd = &command{
+	Name:  "extract",
+	Usage: "[-o DIR] [-mime TYPES] [-status CODES] [-url REGEX] [-manifest FILE] FILE...",
+	Short: "write response and resource payloads to a directory tree mirroring host/path",
+	Flags: func(fs *flag.FlagSet) {
+		fs.StringVar(&extractFlags.dir, "o", ".", "directory to extract to")
+		fs.StringVar(&extractFlags.mime, "mime", "", "comma-separated media types to extract, eg: application/pdf,image/")
+		fs.StringVar(&extractFlags.status, "status", "", "comma-separated HTTP status codes to extract, eg: 200,203")
+		fs.StringVar(&extractFlags.url, "url", "", "only extract target uris matching this regular expression")
+		fs.StringVar(&extractFlags.manifest, "manifest", "", "path to write the CSV manifest to (default DIR/manifest.csv), \"-\" for stdout")
+	},
+	Run: runExtract,
+}
+
+var extractFlags struct {
+	dir, mime, status, url, manifest string
+}
+
+func runExtract(fs *flag.FlagSet, out io.Writer) error {
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+
+	e := warc.NewExtractor(extractFlags.dir)
+	e.MimeTypes = splitList(extractFlags.mime)
+	for _, s := range splitList(extractFlags.status) {
+		code, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid status code: '%s'", s)
+		}
+		e.Statuses = append(e.Statuses, code)
+	}
+	if extractFlags.url != "" {
+		re, err := regexp.Compile(extractFlags.url)
+		if err != nil {
+			return fmt.Errorf("invalid url expression: %s", err)
+		}
+		e.URLPattern = re
+	}
+
+	files := []warc.ExtractedFile{}
+	for _, path := range fs.Args() {
+		f, err := openInput(path)
+		if err != nil {
+			return err
+		}
+		extracted, err := e.Extract(f)
+		f.Close()
+		files = append(files, extracted...)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	switch extractFlags.manifest {
+	case "-":
+		return warc.WriteExtractManifest(out, files)
+	case "":
+		extractFlags.manifest = filepath.Join(extractFlags.dir, "manifest.csv")
+	}
+	mf, err := os.Create(extractFlags.manifest)
+	if err != nil {
+		return err
+	}
+	defer mf.Close()
+	if err := warc.WriteExtractManifest(mf, files); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "extracted %d files to %s\n", len(files), extractFlags.dir)
+	return err
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(s string) (list []string) {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
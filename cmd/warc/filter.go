@@ -0,0 +1,225 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/datatogether/warc"
+)
+
+var filterCmd = &command{
+	Name:  "filter",
+	Usage: "[-o FILE] [-gzip] [filters] [-not] FILE...",
+	Short: "write the records matching a filter expression to a new WARC file",
+	Flags: func(fs *flag.FlagSet) {
+		fs.StringVar(&filterFlags.out, "o", "-", "file to write to, \"-\" for stdout")
+		fs.BoolVar(&filterFlags.gzip, "gzip", false, "gzip each written record (default true if -o ends in .gz)")
+		fs.StringVar(&filterFlags.types, "type", "", "comma-separated record types, eg: response,revisit")
+		fs.StringVar(&filterFlags.url, "url", "", "comma-separated target uri prefixes")
+		fs.StringVar(&filterFlags.surt, "surt", "", "comma-separated SURT prefixes, eg: com,example)/")
+		fs.StringVar(&filterFlags.mime, "mime", "", "comma-separated payload media types, eg: text/html,image/")
+		fs.StringVar(&filterFlags.status, "status", "", "comma-separated HTTP status codes")
+		fs.StringVar(&filterFlags.from, "from", "", "only records dated at or after this date, as YYYY-MM-DD, RFC 3339 or a 1-14 digit timestamp")
+		fs.StringVar(&filterFlags.to, "to", "", "only records dated before this date")
+		fs.StringVar(&filterFlags.header, "header", "", "WARC header match, as NAME:REGEX")
+		fs.StringVar(&filterFlags.httpHeader, "http-header", "", "HTTP header match, as NAME:REGEX")
+		fs.BoolVar(&filterFlags.not, "not", false, "invert the filter, writing records that don't match")
+		fs.BoolVar(&filterFlags.groups, "groups", true, "keep records linked by WARC-Concurrent-To together, filtering on the response, resource or revisit record of each group. -type implies -groups=false unless it's set")
+		fs.BoolVar(&filterFlags.warcinfo, "warcinfo", true, "always write warcinfo records")
+	},
+	Run: runFilter,
+}
+
+var filterFlags struct {
+	out, types, url, surt, mime, status, from, to, header, httpHeader string
+	gzip, not, groups, warcinfo                                       bool
+}
+
+func runFilter(fs *flag.FlagSet, out io.Writer) error {
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	filter, err := buildFilter()
+	if err != nil {
+		return err
+	}
+	p := &warc.Pipeline{
+		Filter:       filter,
+		KeepGroups:   filterFlags.groups,
+		KeepWarcinfo: filterFlags.warcinfo,
+	}
+	if filterFlags.types != "" {
+		// record types are matched per record, unless groups are asked for
+		p.KeepGroups = false
+		fs.Visit(func(f *flag.Flag) {
+			if f.Name == "groups" {
+				p.KeepGroups = filterFlags.groups
+			}
+		})
+	}
+
+	dest := out
+	if filterFlags.out != "-" {
+		f, err := os.Create(filterFlags.out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dest = f
+	}
+	compress := filterFlags.gzip || strings.HasSuffix(filterFlags.out, ".gz")
+	w, err := newWARCWriter(dest, compress)
+	if err != nil {
+		return err
+	}
+
+	total := warc.PipelineStats{}
+	for _, path := range fs.Args() {
+		f, err := openInput(path)
+		if err != nil {
+			return err
+		}
+		stats, err := p.Run(w, f)
+		f.Close()
+		total.Read += stats.Read
+		total.Written += stats.Written
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	if filterFlags.out != "-" {
+		_, err = fmt.Fprintf(out, "wrote %d of %d records to %s\n", total.Written, total.Read, filterFlags.out)
+	}
+	return err
+}
+
+// newWARCWriter creates a writer to w, gzipping each record if compress is true
+func newWARCWriter(w io.Writer, compress bool) (*warc.Writer, error) {
+	ws, ok := w.(io.WriteSeeker)
+	if !ok || w == os.Stdout {
+		ws = warc.CountWriter(w)
+	}
+	if compress {
+		return warc.NewWriterCompressed(ws, gzip.NewWriter(ws))
+	}
+	return warc.NewWriterRaw(ws)
+}
+
+// buildFilter combines filter flags into a single filter. Values within a
+// flag are alternatives, all flags must match
+func buildFilter() (warc.Filter, error) {
+	var filters []warc.Filter
+
+	if types := splitList(filterFlags.types); len(types) > 0 {
+		rts := make([]warc.RecordType, len(types))
+		for i, t := range types {
+			if rts[i] = warc.ParseRecordType(t); rts[i] == warc.RecordTypeUnknown {
+				return nil, fmt.Errorf("invalid record type: '%s'", t)
+			}
+		}
+		filters = append(filters, warc.FilterTypes(rts...))
+	}
+	if prefixes := splitList(filterFlags.url); len(prefixes) > 0 {
+		alts := make([]warc.Filter, len(prefixes))
+		for i, p := range prefixes {
+			alts[i] = warc.FilterURLPrefix(p)
+		}
+		filters = append(filters, warc.FilterAny(alts...))
+	}
+	// SURT prefixes contain commas, so only split on whitespace
+	if prefixes := strings.Fields(filterFlags.surt); len(prefixes) > 0 {
+		alts := make([]warc.Filter, len(prefixes))
+		for i, p := range prefixes {
+			f, err := warc.FilterSURTPrefix(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid surt prefix: '%s'", p)
+			}
+			alts[i] = f
+		}
+		filters = append(filters, warc.FilterAny(alts...))
+	}
+	if mimes := splitList(filterFlags.mime); len(mimes) > 0 {
+		filters = append(filters, warc.FilterMimeTypes(mimes...))
+	}
+	if statuses := splitList(filterFlags.status); len(statuses) > 0 {
+		codes := make([]int, len(statuses))
+		for i, s := range statuses {
+			code, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid status code: '%s'", s)
+			}
+			codes[i] = code
+		}
+		filters = append(filters, warc.FilterStatuses(codes...))
+	}
+	if filterFlags.from != "" || filterFlags.to != "" {
+		from, err := parseDate(filterFlags.from)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseDate(filterFlags.to)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, warc.FilterDateRange(from, to))
+	}
+	if filterFlags.header != "" {
+		name, re, err := parseHeaderMatch(filterFlags.header)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, warc.FilterHeader(name, re))
+	}
+	if filterFlags.httpHeader != "" {
+		name, re, err := parseHeaderMatch(filterFlags.httpHeader)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, warc.FilterHTTPHeader(name, re))
+	}
+
+	filter := warc.FilterAll(filters...)
+	if filterFlags.not {
+		filter = warc.FilterNot(filter)
+	}
+	return filter, nil
+}
+
+// parseDate parses a date flag, returning the zero time for an empty string
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	if regexp.MustCompile(`^\d{1,14}$`).MatchString(s) {
+		// pad partial timestamps to the start of the period they name
+		return time.Parse(warc.TimestampFormat, s+"00000101000000"[len(s):])
+	}
+	return time.Time{}, fmt.Errorf("invalid date: '%s'", s)
+}
+
+// parseHeaderMatch parses a NAME:REGEX header match flag
+func parseHeaderMatch(s string) (string, *regexp.Regexp, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", nil, fmt.Errorf("invalid header match: '%s', expected NAME:REGEX", s)
+	}
+	re, err := regexp.Compile(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid header match: %s", err)
+	}
+	return strings.TrimSpace(parts[0]), re, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/datatogether/warc"
+)
+
+var importHARCmd = &command{
+	Name:  "import-har",
+	Usage: "[-o FILE] [-gzip] FILE.har...",
+	Short: "convert HTTP Archive (HAR) files to request and response records",
+	Flags: func(fs *flag.FlagSet) {
+		fs.StringVar(&importHARFlags.out, "o", "-", "file to write to, \"-\" for stdout")
+		fs.BoolVar(&importHARFlags.gzip, "gzip", false, "gzip each written record (default true if -o ends in .gz)")
+	},
+	Run: runImportHAR,
+}
+
+var importHARFlags struct {
+	out  string
+	gzip bool
+}
+
+func runImportHAR(fs *flag.FlagSet, out io.Writer) error {
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+
+	dest := out
+	if importHARFlags.out != "-" {
+		f, err := os.Create(importHARFlags.out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dest = f
+	}
+	w, err := newWARCWriter(dest, importHARFlags.gzip || strings.HasSuffix(importHARFlags.out, ".gz"))
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, path := range fs.Args() {
+		f, err := openInput(path)
+		if err != nil {
+			return err
+		}
+		n, err := warc.ImportHAR(w, f)
+		f.Close()
+		total += n
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	if importHARFlags.out != "-" {
+		_, err = fmt.Fprintf(out, "wrote %d entries to %s\n", total, importHARFlags.out)
+	}
+	return err
+}
+
+var exportHARCmd = &command{
+	Name:  "export-har",
+	Usage: "[-o FILE] FILE",
+	Short: "convert request and response records to an HTTP Archive (HAR) file",
+	Flags: func(fs *flag.FlagSet) {
+		fs.StringVar(&exportHARFlags.out, "o", "-", "file to write to, \"-\" for stdout")
+	},
+	Run: runExportHAR,
+}
+
+var exportHARFlags struct {
+	out string
+}
+
+func runExportHAR(fs *flag.FlagSet, out io.Writer) error {
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	f, err := openInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dest := out
+	if exportHARFlags.out != "-" {
+		o, err := os.Create(exportHARFlags.out)
+		if err != nil {
+			return err
+		}
+		defer o.Close()
+		dest = o
+	}
+	return warc.ExportHAR(dest, f)
+}
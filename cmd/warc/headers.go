@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/datatogether/warc"
+)
+
+var headersCmd = &command{
+	Name:  "headers",
+	Usage: "[-offset N | -id ID] FILE",
+	Short: "print WARC and HTTP headers of records",
+	Flags: func(fs *flag.FlagSet) {
+		fs.Int64Var(&headersFlags.offset, "offset", -1, "only print headers for the record at offset")
+		fs.StringVar(&headersFlags.id, "id", "", "only print headers for the record with this WARC-Record-ID")
+	},
+	Run: runHeaders,
+}
+
+var headersFlags struct {
+	offset int64
+	id     string
+}
+
+func runHeaders(fs *flag.FlagSet, out io.Writer) error {
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	if headersFlags.offset >= 0 || headersFlags.id != "" {
+		rec, err := selectRecord(fs.Arg(0), headersFlags.offset, headersFlags.id)
+		if err != nil {
+			return err
+		}
+		return writeHeaders(out, rec)
+	}
+	return eachRecord(fs.Arg(0), func(rec *warc.Record, start, end int64) error {
+		return writeHeaders(out, rec)
+	})
+}
+
+// writeHeaders prints the WARC headers of rec, followed by HTTP headers for
+// records that contain HTTP messages
+func writeHeaders(w io.Writer, rec *warc.Record) error {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s\n", rec.Format)
+	keys := make([]string, 0, len(rec.Headers))
+	for key := range rec.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(buf, "%s: %s\n", key, rec.Headers[key])
+	}
+	buf.WriteString("\n")
+
+	if strings.HasPrefix(rec.Headers.Get(warc.FieldNameContentType), "application/http") {
+		content := rec.Content.Bytes()
+		if i := bytes.Index(content, []byte("\r\n\r\n")); i >= 0 {
+			buf.Write(bytes.Replace(content[:i+2], []byte("\r\n"), []byte("\n"), -1))
+			buf.WriteString("\n")
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/datatogether/warc"
+)
+
+var lsCmd = &command{
+	Name:  "ls",
+	Usage: "FILE...",
+	Short: "list records, one per line: offset, type, date, target uri, content length",
+	Run:   runLs,
+}
+
+func runLs(fs *flag.FlagSet, out io.Writer) error {
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, path := range fs.Args() {
+		if fs.NArg() > 1 {
+			fmt.Fprintf(tw, "%s:\n", path)
+		}
+		if err := eachRecord(path, func(rec *warc.Record, start, end int64) error {
+			_, err := fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\n", start, rec.Type, rec.Headers.Get(warc.FieldNameWARCDate), rec.TargetURI(), rec.ContentLength())
+			return err
+		}); err != nil {
+			tw.Flush()
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// eachRecord calls fn for each record in the file at path, stopping if fn
+// returns an error. fn can return errStop to stop early without error
+func eachRecord(path string, fn func(rec *warc.Record, start, end int64) error) error {
+	f, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rdr, err := warc.NewOffsetReader(f)
+	if err != nil {
+		return err
+	}
+	for {
+		rec, start, end, err := rdr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		if err := fn(rec, start, end); err == errStop {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// errStop is returned from eachRecord callbacks to stop iteration
+var errStop = fmt.Errorf("stop")
@@ -0,0 +1,140 @@
+// Command warc inspects and manipulates WARC files.
+//
+// Usage:
+//
+//	warc <command> [flags] [args]
+//
+// Run "warc help <command>" for details on a command. Input files may be
+// uncompressed, gzipped or bzip2-compressed, "-" reads from stdin.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// command is a warc subcommand
+type command struct {
+	Name  string
+	Usage string
+	Short string
+	// Flags registers the command's flags, if any
+	Flags func(fs *flag.FlagSet)
+	Run   func(fs *flag.FlagSet, out io.Writer) error
+}
+
+// commands lists all subcommands, in the order they're listed in help
+var commands = []*command{
+	lsCmd,
+	catCmd,
+	headersCmd,
+	extractCmd,
+	validateCmd,
+	filterCmd,
+	mergeCmd,
+	splitCmd,
+	recompressCmd,
+	statsCmd,
+	diffCmd,
+	importHARCmd,
+	exportHARCmd,
+	watCmd,
+	wetCmd,
+	proxyCmd,
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintf(os.Stderr, "warc: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command named by args[0]
+func run(args []string, out, errOut io.Writer) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		if len(args) > 1 {
+			if cmd := findCommand(args[1]); cmd != nil {
+				cmd.flagSet(errOut).Usage()
+				return nil
+			}
+		}
+		usage(errOut)
+		return nil
+	}
+
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		usage(errOut)
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+	fs := cmd.flagSet(errOut)
+	if err := fs.Parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	return cmd.Run(fs, out)
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func (c *command) flagSet(errOut io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(c.Name, flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.Usage = func() {
+		fmt.Fprintf(errOut, "usage: warc %s %s\n\n%s\n", c.Name, c.Usage, c.Short)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(errOut, "\nflags:")
+			fs.PrintDefaults()
+		}
+	}
+	if c.Flags != nil {
+		c.Flags(fs)
+	}
+	return fs
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: warc <command> [flags] [args]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.Name, cmd.Short)
+	}
+	fmt.Fprintln(w, "\nrun \"warc help <command>\" for more information on a command")
+}
+
+// openInput opens a named file for reading, "-" reads from stdin
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(path)
+}
+
+// requireArgs checks a minimum number of positional args were given
+func requireArgs(fs *flag.FlagSet, n int) error {
+	if fs.NArg() < n {
+		fs.Usage()
+		return fmt.Errorf("%s: expected at least %d argument(s)", fs.Name(), n)
+	}
+	return nil
+}
+
+// normalizeID strips any <urn:uuid:...> wrapping from a record id
+func normalizeID(id string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(id, "<urn:uuid:"), ">")
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testFile = "../../testdata/warcio/example.warc.gz"
+
+func TestLs(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := run([]string{"ls", testFile}, out, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("expected 6 lines, got: %d", len(lines))
+	}
+	expect := []string{"784", "response", "2017-03-06T04:02:06Z", "http://example.com/", "975"}
+	if fields := strings.Fields(lines[2]); strings.Join(fields, " ") != strings.Join(expect, " ") {
+		t.Errorf("line mismatch. expected: %v, got: %v", expect, fields)
+	}
+}
+
+func TestCatAndHeaders(t *testing.T) {
+	cases := []struct {
+		args     []string
+		contains []string
+		err      string
+	}{
+		{[]string{"cat", "-offset", "784", testFile}, []string{"WARC-Type: response", "HTTP/1.1 200 OK"}, ""},
+		{[]string{"cat", "-id", "<urn:uuid:e9a0cecc-0221-11e7-adb1-0242ac120008>", testFile}, []string{"WARC-Type: warcinfo", "software: Webrecorder Platform v3.7"}, ""},
+		{[]string{"cat", "-block", "-offset", "0", testFile}, []string{"software: Webrecorder"}, ""},
+		{[]string{"cat", "-offset", "1", testFile}, nil, "no record at offset 1"},
+		{[]string{"cat", testFile}, nil, "exactly one of -offset or -id is required"},
+		{[]string{"headers", "-offset", "784", testFile}, []string{"WARC-Payload-Digest: sha1:G7HRM7BGOKSKMSXZAHMUQTTV53QOFSMK", "\n\nHTTP/1.1 200 OK\nContent-Encoding: gzip\n"}, ""},
+		{[]string{"headers", testFile}, []string{"WARC-Type: warcinfo", "GET / HTTP/1.0"}, ""},
+		{[]string{"nope"}, nil, "unknown command: nope"},
+	}
+
+	for i, c := range cases {
+		out := &bytes.Buffer{}
+		err := run(c.args, out, ioutil.Discard)
+		if !(err == nil && c.err == "" || err != nil && err.Error() == c.err) {
+			t.Errorf("case %d error mismatch. expected: '%s', got: '%v'", i, c.err, err)
+			continue
+		}
+		for _, s := range c.contains {
+			if !strings.Contains(out.String(), s) {
+				t.Errorf("case %d expected output to contain: %q", i, s)
+			}
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc_cmd_extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := &bytes.Buffer{}
+	if err := run([]string{"extract", "-o", dir, "-mime", "text/html", "-status", "200", testFile}, out, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "extracted 1 files to "+dir+"\n" {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "example.com", "index.html")); err != nil {
+		t.Error(err)
+	}
+	manifest, err := ioutil.ReadFile(filepath.Join(dir, "manifest.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(manifest), "example.com/index.html,<urn:uuid:a9c51e3e-0221-11e7-bf66-0242ac120005>,http://example.com/") {
+		t.Errorf("unexpected manifest: %s", manifest)
+	}
+
+	if err := run([]string{"extract", "-status", "abc", testFile}, out, ioutil.Discard); err == nil {
+		t.Error("expected error for invalid status")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		args     []string
+		contains string
+		err      string
+	}{
+		{[]string{"validate", "../../testdata/warcio/example-iana.org-chunked.warc"}, "3 records, 0 errors, 0 warnings", ""},
+		{[]string{"validate", "-q", "../../testdata/warcio/example-trunc.warc"}, "example-trunc.warc:1197: error: WARC-Block-Digest", "1 of 1 files invalid"},
+		{[]string{"validate", "-json", "../../testdata/warcio/example-resource.warc.gz"}, `"records": 3`, ""},
+	}
+
+	for i, c := range cases {
+		out := &bytes.Buffer{}
+		err := run(c.args, out, ioutil.Discard)
+		if (err == nil && c.err != "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("case %d error mismatch. expected: '%s', got: '%v'", i, c.err, err)
+		}
+		if !strings.Contains(out.String(), c.contains) {
+			t.Errorf("case %d expected output to contain '%s', got: %s", i, c.contains, out.String())
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc_cmd_filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		args   []string
+		output string
+		types  []string
+	}{
+		{[]string{"-type", "response,revisit", "-groups=false", "-warcinfo=false"}, "wrote 2 of 6 records", []string{"response", "revisit"}},
+		{[]string{"-type", "request", "-warcinfo=false"}, "wrote 2 of 6 records", []string{"request", "request"}},
+		{[]string{"-type", "response"}, "wrote 3 of 6 records", []string{"warcinfo", "warcinfo", "response"}},
+		{[]string{"-type", "response", "-groups"}, "wrote 4 of 6 records", []string{"warcinfo", "warcinfo", "response", "request"}},
+		{[]string{"-mime", "text/html", "-from", "20170306040300"}, "wrote 4 of 6 records", []string{"warcinfo", "warcinfo", "revisit", "request"}},
+		{[]string{"-not", "-url", "http://example.com/", "-warcinfo=false"}, "wrote 2 of 6 records", []string{"warcinfo", "warcinfo"}},
+		{[]string{"-surt", "com,example)/", "-http-header", "content-encoding:gzip", "-warcinfo=false"}, "wrote 4 of 6 records", []string{"response", "request", "revisit", "request"}},
+	}
+
+	for i, c := range cases {
+		path := filepath.Join(dir, fmt.Sprintf("out%d.warc.gz", i))
+		args := append(append([]string{"filter", "-o", path}, c.args...), testFile)
+		out := &bytes.Buffer{}
+		if err := run(args, out, ioutil.Discard); err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		if !strings.HasPrefix(out.String(), c.output) {
+			t.Errorf("case %d output mismatch. expected: %s, got: %s", i, c.output, out.String())
+		}
+
+		out.Reset()
+		if err := run([]string{"ls", path}, out, ioutil.Discard); err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		var types []string
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			if fields := strings.Fields(line); len(fields) > 1 {
+				types = append(types, fields[1])
+			}
+		}
+		if strings.Join(types, ",") != strings.Join(c.types, ",") {
+			t.Errorf("case %d types mismatch. expected: %v, got: %v", i, c.types, types)
+		}
+	}
+
+	if err := run([]string{"filter", "-status", "abc", testFile}, ioutil.Discard, ioutil.Discard); err == nil {
+		t.Error("expected error for invalid status")
+	}
+}
+
+func TestRepack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc_cmd_repack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		args  []string
+		files []string
+	}{
+		{[]string{"merge", "-o", dir, "-size", "1K", testFile, "../../testdata/warcio/post-test.warc.gz"}, []string{"merged-00000.warc.gz", "merged-00001.warc.gz", "merged-00002.warc.gz", "merged-00003.warc.gz"}},
+		{[]string{"split", "-o", dir, "-n", "3", "-c", "none", "../../testdata/warcio/post-test.warc.gz"}, []string{"post-test-00000.warc", "post-test-00001.warc", "post-test-00002.warc"}},
+		{[]string{"recompress", "-c", "bzip2", "-o", filepath.Join(dir, "example.warc.bz2"), testFile}, []string{"example.warc.bz2"}},
+	}
+
+	for i, c := range cases {
+		out := &bytes.Buffer{}
+		if err := run(c.args, out, ioutil.Discard); err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		for _, name := range c.files {
+			if !strings.Contains(out.String(), filepath.Join(dir, name)) {
+				t.Errorf("case %d expected output to list %s, got: %s", i, name, out.String())
+			}
+			if err := run([]string{"validate", "-q", filepath.Join(dir, name)}, ioutil.Discard, ioutil.Discard); err != nil {
+				t.Errorf("case %d %s: %s", i, name, err)
+			}
+		}
+	}
+
+	out := &bytes.Buffer{}
+	if err := run([]string{"recompress", "-c", "none", "-remap", "-", "-o", filepath.Join(dir, "example.warc"), testFile}, out, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "<urn:uuid:a9c51e3e-0221-11e7-bf66-0242ac120005>,example.warc.gz,784,") {
+		t.Errorf("unexpected remap output: %s", out.String())
+	}
+}
+
+func TestStats(t *testing.T) {
+	cases := []struct {
+		args     []string
+		contains []string
+	}{
+		{[]string{"stats", testFile}, []string{"records             6", "STATUS  records  bytes\n200     2"}},
+		{[]string{"stats", "-json", testFile}, []string{`"duplicate_ratio": 0.5`, `"example.com": {`}},
+	}
+
+	for i, c := range cases {
+		out := &bytes.Buffer{}
+		if err := run(c.args, out, ioutil.Discard); err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		for _, s := range c.contains {
+			if !strings.Contains(out.String(), s) {
+				t.Errorf("case %d expected output to contain '%s', got:\n%s", i, s, out.String())
+			}
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		args   []string
+		output string
+		err    bool
+	}{
+		{[]string{"diff", testFile, testFile}, "", false},
+		{[]string{"diff", testFile, "../../testdata/warcio/post-test.warc.gz"}, "- http://example.com/\n+ http://httpbin.org/post\n+ http://httpbin.org/post?foo=bar\n", false},
+		{[]string{"diff", "-json", "../../testdata/warcio/example-resource.warc.gz", testFile}, `"change": "modified"`, false},
+		{[]string{"diff", testFile}, "", true},
+	}
+
+	for i, c := range cases {
+		out := &bytes.Buffer{}
+		err := run(c.args, out, ioutil.Discard)
+		if (err != nil) != c.err {
+			t.Errorf("case %d error mismatch. expected: %t, got: %v", i, c.err, err)
+			continue
+		}
+		if (c.output == "" && out.Len() != 0) || !strings.Contains(out.String(), c.output) {
+			t.Errorf("case %d output mismatch. expected: %q, got: %q", i, c.output, out.String())
+		}
+	}
+}
+
+func TestImportHAR(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc_cmd_har")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "example.warc.gz")
+	out := &bytes.Buffer{}
+	if err := run([]string{"import-har", "-o", path, "../../testdata/example.har"}, out, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "wrote 2 entries to "+path+"\n" {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+	if err := run([]string{"validate", "-q", path}, ioutil.Discard, ioutil.Discard); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExportHAR(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := run([]string{"export-har", testFile}, out, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`"version": "1.2"`, `"url": "http://example.com/"`, `<title>Example Domain</title>`} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("expected output to contain %q", s)
+		}
+	}
+}
+
+func TestWAT(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := run([]string{"wat", testFile}, out, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"WARC-Type: metadata", `"Filename":"example.warc.gz"`, `"path":"A@/href"`} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("expected output to contain %q", s)
+		}
+	}
+}
+
+func TestWET(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := run([]string{"wet", testFile}, out, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"WARC-Type: conversion", "Content-Type: text/plain", "More information..."} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("expected output to contain %q", s)
+		}
+	}
+}
+
+func TestLoadOrCreateCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc_cmd_proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, key := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	out := &bytes.Buffer{}
+	created, err := loadOrCreateCA(out, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "generated CA certificate") {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+	loaded, err := loadOrCreateCA(ioutil.Discard, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Cert.Equal(created.Cert) {
+		t.Error("expected saved CA to be loaded")
+	}
+
+	os.Remove(key)
+	if _, err := loadOrCreateCA(ioutil.Discard, cert, key); err == nil {
+		t.Error("expected error with missing key")
+	}
+}
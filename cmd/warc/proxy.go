@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/datatogether/warc"
+)
+
+var proxyCmd = &command{
+	Name:  "proxy",
+	Usage: "[-addr ADDR] [-ca-cert FILE] [-ca-key FILE] [-no-mitm] [-tls] [-max-body N] -o FILE",
+	Short: "run an HTTP(S) proxy that records all traffic passing through it",
+	Flags: func(fs *flag.FlagSet) {
+		fs.StringVar(&proxyFlags.addr, "addr", "localhost:8080", "address to listen on")
+		fs.StringVar(&proxyFlags.out, "o", "", "file to write to")
+		fs.BoolVar(&proxyFlags.gzip, "gzip", false, "gzip each written record (default true if -o ends in .gz)")
+		fs.StringVar(&proxyFlags.caCert, "ca-cert", "warc-proxy-ca.pem", "CA certificate, generated if it doesn't exist. clients must trust it")
+		fs.StringVar(&proxyFlags.caKey, "ca-key", "warc-proxy-ca-key.pem", "CA private key, generated if it doesn't exist")
+		fs.BoolVar(&proxyFlags.noMITM, "no-mitm", false, "tunnel HTTPS traffic without recording it")
+		fs.BoolVar(&proxyFlags.recordTLS, "tls", false, "write metadata records of upstream TLS session details")
+		fs.Int64Var(&proxyFlags.maxBody, "max-body", 0, "bytes of each response body to record, longer bodies are truncated (default 10MB)")
+	},
+	Run: runProxy,
+}
+
+var proxyFlags struct {
+	addr, out, caCert, caKey string
+	gzip, noMITM, recordTLS  bool
+	maxBody                  int64
+}
+
+func runProxy(fs *flag.FlagSet, out io.Writer) error {
+	if proxyFlags.out == "" {
+		fs.Usage()
+		return fmt.Errorf("proxy: -o is required")
+	}
+
+	var ca *warc.CA
+	if !proxyFlags.noMITM {
+		var err error
+		if ca, err = loadOrCreateCA(out, proxyFlags.caCert, proxyFlags.caKey); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(proxyFlags.out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := newWARCWriter(f, proxyFlags.gzip || strings.HasSuffix(proxyFlags.out, ".gz"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "recording traffic through http://%s to %s\n", proxyFlags.addr, proxyFlags.out)
+	proxy := warc.NewProxy(w, ca)
+	proxy.RecordTLS = proxyFlags.recordTLS
+	proxy.MaxBodySize = proxyFlags.maxBody
+	return http.ListenAndServe(proxyFlags.addr, proxy)
+}
+
+// loadOrCreateCA reads a CA from certPath & keyPath, generating & saving a
+// new CA if neither file exists
+func loadOrCreateCA(out io.Writer, certPath, keyPath string) (*warc.CA, error) {
+	certPEM, certErr := ioutil.ReadFile(certPath)
+	keyPEM, keyErr := ioutil.ReadFile(keyPath)
+	if certErr == nil && keyErr == nil {
+		return warc.LoadCA(certPEM, keyPEM)
+	}
+	if !os.IsNotExist(certErr) || !os.IsNotExist(keyErr) {
+		return nil, fmt.Errorf("reading CA: both %s and %s must exist", certPath, keyPath)
+	}
+
+	ca, err := warc.NewCA("warc proxy CA")
+	if err != nil {
+		return nil, err
+	}
+	if keyPEM, err = ca.KeyPEM(); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(certPath, ca.CertPEM(), 0644); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "generated CA certificate %s, install it in your browser to record HTTPS traffic\n", certPath)
+	return ca, nil
+}
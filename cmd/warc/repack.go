@@ -0,0 +1,210 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/datatogether/warc"
+)
+
+var mergeCmd = &command{
+	Name:  "merge",
+	Usage: "[-o DIR] [-prefix NAME] [-c COMPRESSION] [-size SIZE] [-remap FILE] FILE...",
+	Short: "concatenate files into one or more size-bounded WARC files",
+	Flags: func(fs *flag.FlagSet) {
+		repackFlagVars(fs, &mergeFlags.repackFlags, "merged")
+		fs.StringVar(&mergeFlags.size, "size", "", "start a new file once a file reaches this size, eg: 1G, 500M")
+	},
+	Run: runMerge,
+}
+
+var mergeFlags struct {
+	repackFlags
+	size string
+}
+
+var splitCmd = &command{
+	Name:  "split",
+	Usage: "-n N [-o DIR] [-prefix NAME] [-c COMPRESSION] [-remap FILE] FILE",
+	Short: "split a WARC file into N files of roughly equal size",
+	Flags: func(fs *flag.FlagSet) {
+		repackFlagVars(fs, &splitFlags.repackFlags, "")
+		fs.IntVar(&splitFlags.n, "n", 2, "number of files to split into")
+	},
+	Run: runSplit,
+}
+
+var splitFlags struct {
+	repackFlags
+	n int
+}
+
+var recompressCmd = &command{
+	Name:  "recompress",
+	Usage: "-c COMPRESSION [-o FILE] [-remap FILE] FILE",
+	Short: "convert a WARC file to another compression format",
+	Flags: func(fs *flag.FlagSet) {
+		fs.StringVar(&recompressFlags.compression, "c", "gzip", "output compression: none, gzip (per-record), gzip-file (whole-file) or bzip2")
+		fs.StringVar(&recompressFlags.out, "o", "", "file to write to (default the input name with a new extension, in the current directory)")
+		fs.StringVar(&recompressFlags.remap, "remap", "", "write a CSV of old & new record offsets to this file, \"-\" for stdout")
+	},
+	Run: runRecompress,
+}
+
+var recompressFlags struct {
+	compression, out, remap string
+}
+
+// repackFlags are flags shared by commands that write a set of files
+type repackFlags struct {
+	dir, prefix, compression, remap string
+}
+
+func repackFlagVars(fs *flag.FlagSet, f *repackFlags, prefix string) {
+	fs.StringVar(&f.dir, "o", ".", "directory to write to")
+	fs.StringVar(&f.prefix, "prefix", prefix, "output files are named PREFIX-00000.warc.gz, PREFIX-00001.warc.gz, ...")
+	fs.StringVar(&f.compression, "c", "gzip", "output compression: none, gzip (per-record), gzip-file (whole-file) or bzip2")
+	fs.StringVar(&f.remap, "remap", "", "write a CSV of old & new record offsets to this file, \"-\" for stdout")
+}
+
+// repacker creates a Repacker from flags
+func (f *repackFlags) repacker() (*warc.Repacker, error) {
+	c, err := warc.ParseCompression(f.compression)
+	if err != nil {
+		return nil, err
+	}
+	prefix := f.prefix
+	return &warc.Repacker{
+		Dir:         f.dir,
+		Compression: c,
+		Name: func(i int) string {
+			return fmt.Sprintf("%s-%05d%s", prefix, i, c.Ext())
+		},
+	}, nil
+}
+
+func runMerge(fs *flag.FlagSet, out io.Writer) error {
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	p, err := mergeFlags.repacker()
+	if err != nil {
+		return err
+	}
+	if mergeFlags.size != "" {
+		if p.MaxSize, err = parseSize(mergeFlags.size); err != nil {
+			return err
+		}
+	}
+	res, err := p.Repack(fs.Args()...)
+	if err != nil {
+		return err
+	}
+	return writeRepackResult(out, res, mergeFlags.remap)
+}
+
+func runSplit(fs *flag.FlagSet, out io.Writer) error {
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	if splitFlags.prefix == "" {
+		splitFlags.prefix = trimWARCExt(filepath.Base(fs.Arg(0)))
+	}
+	p, err := splitFlags.repacker()
+	if err != nil {
+		return err
+	}
+	res, err := p.Split(fs.Arg(0), splitFlags.n)
+	if err != nil {
+		return err
+	}
+	return writeRepackResult(out, res, splitFlags.remap)
+}
+
+func runRecompress(fs *flag.FlagSet, out io.Writer) error {
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	c, err := warc.ParseCompression(recompressFlags.compression)
+	if err != nil {
+		return err
+	}
+	dest := recompressFlags.out
+	if dest == "" {
+		dest = trimWARCExt(filepath.Base(fs.Arg(0))) + c.Ext()
+	}
+	if abs, _ := filepath.Abs(dest); abs != "" {
+		if src, _ := filepath.Abs(fs.Arg(0)); src == abs {
+			return fmt.Errorf("output file is the same as the input: %s", dest)
+		}
+	}
+
+	p := &warc.Repacker{
+		Dir:         filepath.Dir(dest),
+		Compression: c,
+		Name:        func(int) string { return filepath.Base(dest) },
+	}
+	res, err := p.Repack(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return writeRepackResult(out, res, recompressFlags.remap)
+}
+
+// writeRepackResult lists written files & writes any remap file
+func writeRepackResult(out io.Writer, res *warc.RepackResult, remap string) error {
+	switch remap {
+	case "":
+	case "-":
+		return warc.WriteOffsetRemaps(out, res.Remaps)
+	default:
+		f, err := os.Create(remap)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := warc.WriteOffsetRemaps(f, res.Remaps); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(out, "wrote %d records to %d files:\n", len(res.Remaps), len(res.Files))
+	for _, path := range res.Files {
+		fmt.Fprintf(out, "  %s\n", path)
+	}
+	return nil
+}
+
+// parseSize parses a byte size with an optional K, M or G suffix
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	switch {
+	case strings.HasSuffix(num, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(num, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(num, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		num = num[:len(num)-1]
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: '%s'", s)
+	}
+	return n * mult, nil
+}
+
+// trimWARCExt removes .warc, .warc.gz & .warc.bz2 extensions from a file name
+func trimWARCExt(name string) string {
+	for _, ext := range []string{".gz", ".bz2", ".warc"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/datatogether/warc"
+)
+
+var statsCmd = &command{
+	Name:  "stats",
+	Usage: "[-json] FILE...",
+	Short: "summarize records by type, MIME type, status, host and day",
+	Flags: func(fs *flag.FlagSet) {
+		fs.BoolVar(&statsFlags.json, "json", false, "write stats as JSON")
+	},
+	Run: runStats,
+}
+
+var statsFlags struct {
+	json bool
+}
+
+func runStats(fs *flag.FlagSet, out io.Writer) error {
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+	s := warc.NewStats()
+	for _, path := range fs.Args() {
+		f, err := openInput(path)
+		if err != nil {
+			return err
+		}
+		err = s.AddFile(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	if statsFlags.json {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	}
+	return s.WriteTables(out)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/datatogether/warc"
+)
+
+var validateCmd = &command{
+	Name:  "validate",
+	Usage: "[-json] [-q] FILE...",
+	Short: "check files against the WARC spec, exiting non-zero if errors are found",
+	Flags: func(fs *flag.FlagSet) {
+		fs.BoolVar(&validateFlags.json, "json", false, "write a JSON report for each file")
+		fs.BoolVar(&validateFlags.quiet, "q", false, "only print errors, omitting warnings")
+	},
+	Run: runValidate,
+}
+
+var validateFlags struct {
+	json, quiet bool
+}
+
+func runValidate(fs *flag.FlagSet, out io.Writer) error {
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+
+	reports := []*warc.ValidationReport{}
+	invalid := 0
+	for _, path := range fs.Args() {
+		f, err := openInput(path)
+		if err != nil {
+			return err
+		}
+		report, err := warc.Validate(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		report.Filename = path
+		reports = append(reports, report)
+		if !report.Valid() {
+			invalid++
+		}
+
+		if validateFlags.json {
+			continue
+		}
+		for _, issue := range report.Issues {
+			if validateFlags.quiet && issue.Severity != warc.SeverityError {
+				continue
+			}
+			fmt.Fprintf(out, "%s:%s\n", path, issue)
+		}
+		if !validateFlags.quiet {
+			fmt.Fprintf(out, "%s: %d records, %d errors, %d warnings\n", path, report.Records, report.Errors, report.Warnings)
+		}
+	}
+
+	if validateFlags.json {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			return err
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d files invalid", invalid, len(reports))
+	}
+	return nil
+}
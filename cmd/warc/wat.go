@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/datatogether/warc"
+)
+
+var watCmd = &command{
+	Name:  "wat",
+	Usage: "[-o FILE] [-gzip] FILE...",
+	Short: "write WAT metadata records describing response records",
+	Flags: func(fs *flag.FlagSet) {
+		fs.StringVar(&watFlags.out, "o", "-", "file to write to, \"-\" for stdout")
+		fs.BoolVar(&watFlags.gzip, "gzip", false, "gzip each written record (default true if -o ends in .gz)")
+	},
+	Run: runWAT,
+}
+
+var watFlags struct {
+	out  string
+	gzip bool
+}
+
+func runWAT(fs *flag.FlagSet, out io.Writer) error {
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+
+	dest := out
+	if watFlags.out != "-" {
+		f, err := os.Create(watFlags.out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dest = f
+	}
+	w, err := newWARCWriter(dest, watFlags.gzip || strings.HasSuffix(watFlags.out, ".gz"))
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, path := range fs.Args() {
+		f, err := openInput(path)
+		if err != nil {
+			return err
+		}
+		n, err := warc.WriteWAT(w, f, filepath.Base(path))
+		f.Close()
+		total += n
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	if watFlags.out != "-" {
+		_, err = fmt.Fprintf(out, "wrote %d records to %s\n", total, watFlags.out)
+	}
+	return err
+}
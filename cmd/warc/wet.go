@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/datatogether/warc"
+)
+
+var wetCmd = &command{
+	Name:  "wet",
+	Usage: "[-o FILE] [-gzip] FILE...",
+	Short: "write WET conversion records holding the text of HTML pages",
+	Flags: func(fs *flag.FlagSet) {
+		fs.StringVar(&wetFlags.out, "o", "-", "file to write to, \"-\" for stdout")
+		fs.BoolVar(&wetFlags.gzip, "gzip", false, "gzip each written record (default true if -o ends in .gz)")
+	},
+	Run: runWET,
+}
+
+var wetFlags struct {
+	out  string
+	gzip bool
+}
+
+func runWET(fs *flag.FlagSet, out io.Writer) error {
+	if err := requireArgs(fs, 1); err != nil {
+		return err
+	}
+
+	dest := out
+	if wetFlags.out != "-" {
+		f, err := os.Create(wetFlags.out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dest = f
+	}
+	w, err := newWARCWriter(dest, wetFlags.gzip || strings.HasSuffix(wetFlags.out, ".gz"))
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, path := range fs.Args() {
+		f, err := openInput(path)
+		if err != nil {
+			return err
+		}
+		n, err := warc.WriteWET(w, f)
+		f.Close()
+		total += n
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	if wetFlags.out != "-" {
+		_, err = fmt.Fprintf(out, "wrote %d records to %s\n", total, wetFlags.out)
+	}
+	return err
+}
@@ -0,0 +1,241 @@
+package warc
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	dmp "github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// DiffChange describes how a url differs between two sets of captures
+type DiffChange string
+
+const (
+	// DiffAdded urls are only captured in the new WARC
+	DiffAdded DiffChange = "added"
+	// DiffRemoved urls are only captured in the old WARC
+	DiffRemoved DiffChange = "removed"
+	// DiffModified urls have a different payload digest or HTTP status
+	DiffModified DiffChange = "modified"
+)
+
+// URLDiff is a difference between the captures of a url in two WARC files
+type URLDiff struct {
+	URL       string     `json:"url"`
+	Change    DiffChange `json:"change"`
+	OldDigest string     `json:"old_digest,omitempty"`
+	NewDigest string     `json:"new_digest,omitempty"`
+	OldStatus int        `json:"old_status,omitempty"`
+	NewStatus int        `json:"new_status,omitempty"`
+	// TextDiff lists lines of visible text removed ("- ") & added ("+ ")
+	// between HTML pages, if requested
+	TextDiff string `json:"text_diff,omitempty"`
+}
+
+// DigestChanged is true if both captures exist with different payloads
+func (d *URLDiff) DigestChanged() bool {
+	return d.Change == DiffModified && d.OldDigest != d.NewDigest
+}
+
+// StatusChanged is true if both captures exist with different HTTP statuses
+func (d *URLDiff) StatusChanged() bool {
+	return d.Change == DiffModified && d.OldStatus != d.NewStatus
+}
+
+// Differ compares the captures in two WARC files. Captures are response,
+// resource & revisit records, aligned by the SURT form of their target uri.
+// If a url is captured more than once in a file the latest capture is used.
+type Differ struct {
+	// TextDiff compares the visible text of HTML pages with changed digests
+	TextDiff bool
+}
+
+// diffCapture summarizes a capture for comparison
+type diffCapture struct {
+	url    string
+	date   time.Time
+	digest string
+	status int
+}
+
+// diffSide holds the captures read from one WARC file
+type diffSide struct {
+	captures map[string]*diffCapture
+	// text of HTML payloads by digest, so revisits can be resolved
+	text map[string]string
+}
+
+// Diff compares the captures of an old & new WARC file, returning
+// differences in SURT order
+func (d *Differ) Diff(older, newer io.Reader) ([]*URLDiff, error) {
+	a, err := d.read(older)
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.read(newer)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(a.captures)+len(b.captures))
+	for k := range a.captures {
+		keys = append(keys, k)
+	}
+	for k := range b.captures {
+		if _, ok := a.captures[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	diffs := []*URLDiff{}
+	for _, k := range keys {
+		ca, cb := a.captures[k], b.captures[k]
+		switch {
+		case ca == nil:
+			diffs = append(diffs, &URLDiff{URL: cb.url, Change: DiffAdded, NewDigest: cb.digest, NewStatus: cb.status})
+		case cb == nil:
+			diffs = append(diffs, &URLDiff{URL: ca.url, Change: DiffRemoved, OldDigest: ca.digest, OldStatus: ca.status})
+		case ca.digest != cb.digest || ca.status != cb.status:
+			diff := &URLDiff{
+				URL:       cb.url,
+				Change:    DiffModified,
+				OldDigest: ca.digest,
+				NewDigest: cb.digest,
+				OldStatus: ca.status,
+				NewStatus: cb.status,
+			}
+			ta, oka := a.text[ca.digest]
+			tb, okb := b.text[cb.digest]
+			if d.TextDiff && oka && okb {
+				diff.TextDiff = textDiff(ta, tb)
+			}
+			diffs = append(diffs, diff)
+		}
+	}
+	return diffs, nil
+}
+
+func (d *Differ) read(r io.Reader) (*diffSide, error) {
+	side := &diffSide{captures: map[string]*diffCapture{}, text: map[string]string{}}
+	rdr, err := NewOffsetReader(r)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		rec, _, _, err := rdr.Read()
+		if err == io.EOF {
+			return side, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch rec.Type {
+		case RecordTypeResponse, RecordTypeResource, RecordTypeRevisit:
+		default:
+			continue
+		}
+		key, err := SURT(rec.TargetURI())
+		if err != nil || rec.TargetURI() == "" {
+			continue
+		}
+
+		c := &diffCapture{url: rec.TargetURI(), date: rec.Date()}
+		c.digest = rec.Headers.Get(FieldNameWARCPayloadDigest)
+		if c.digest == "" && rec.Type != RecordTypeRevisit {
+			c.digest = payloadDigest(rec)
+		}
+		var mime string
+		mime, c.status = recordMimeStatus(rec)
+		if prev, ok := side.captures[key]; !ok || !c.date.Before(prev.date) {
+			side.captures[key] = c
+		}
+
+		if d.TextDiff && mime == "text/html" && rec.Type != RecordTypeRevisit {
+			if _, ok := side.text[c.digest]; !ok {
+				if text, err := htmlText(rec); err == nil {
+					side.text[c.digest] = text
+				}
+			}
+		}
+	}
+}
+
+// htmlText extracts the visible text of an HTML payload, one line per
+// block of text
+func htmlText(rec *Record) (string, error) {
+	body, res, err := htmlPayload(rec)
+	if err != nil {
+		return "", err
+	}
+	if res != nil {
+		defer res.Body.Close()
+	}
+	text, _, err := htmlVisibleText(body)
+	return text, err
+}
+
+// textDiff lists the lines removed from a & added in b
+func textDiff(a, b string) string {
+	m := dmp.New()
+	ca, cb, lines := m.DiffLinesToChars(a, b)
+	diffs := m.DiffCharsToLines(m.DiffMain(ca, cb, false), lines)
+
+	buf := &strings.Builder{}
+	for _, d := range diffs {
+		prefix := ""
+		switch d.Type {
+		case dmp.DiffDelete:
+			prefix = "- "
+		case dmp.DiffInsert:
+			prefix = "+ "
+		default:
+			continue
+		}
+		for _, line := range strings.SplitAfter(d.Text, "\n") {
+			if line != "" {
+				buf.WriteString(prefix + line)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// WriteDiff writes diffs in a line-oriented text format, marking modified,
+// added & removed urls:
+//
+//	~ http://example.com/modified status 200 -> 404
+//	+ http://example.com/added
+//	- http://example.com/removed
+func WriteDiff(w io.Writer, diffs []*URLDiff) error {
+	for _, d := range diffs {
+		var err error
+		switch d.Change {
+		case DiffAdded:
+			_, err = fmt.Fprintf(w, "+ %s\n", d.URL)
+		case DiffRemoved:
+			_, err = fmt.Fprintf(w, "- %s\n", d.URL)
+		case DiffModified:
+			line := "~ " + d.URL
+			if d.StatusChanged() {
+				line += fmt.Sprintf(" status %d -> %d", d.OldStatus, d.NewStatus)
+			}
+			if d.DigestChanged() {
+				line += fmt.Sprintf(" digest %s -> %s", d.OldDigest, d.NewDigest)
+			}
+			_, err = fmt.Fprintln(w, line)
+			for _, l := range strings.SplitAfter(d.TextDiff, "\n") {
+				if l != "" && err == nil {
+					_, err = fmt.Fprint(w, "    "+l)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package warc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// testResponseWARC writes a WARC of HTTP responses to url: status, html body
+// pairs
+func testResponseWARC(t *testing.T, date string, responses ...string) io.Reader {
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(responses); i += 3 {
+		rec := &Record{
+			Format: RecordFormatWarc,
+			Type:   RecordTypeResponse,
+			Headers: Header{
+				FieldNameWARCRecordID:  NewUUID(),
+				FieldNameWARCDate:      date,
+				FieldNameWARCTargetURI: responses[i],
+				FieldNameContentType:   "application/http; msgtype=response",
+			},
+			Content: bytes.NewBufferString(fmt.Sprintf("HTTP/1.1 %s\r\nContent-Type: text/html\r\n\r\n%s", responses[i+1], responses[i+2])),
+		}
+		if _, _, err := w.WriteRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf
+}
+
+func TestDiff(t *testing.T) {
+	older := testResponseWARC(t, "2017-03-06T04:02:06Z",
+		"http://example.com/", "200 OK", "<html><body><h1>Home</h1><p>Welcome</p><script>var a = 1</script></body></html>",
+		"http://example.com/removed", "200 OK", "<p>gone</p>",
+		"http://example.com/same", "200 OK", "<p>same</p>",
+		"http://example.com/status", "200 OK", "<p>status</p>",
+	)
+	newer := testResponseWARC(t, "2017-04-06T04:02:06Z",
+		"http://www.example.com/same", "200 OK", "<p>same</p>",
+		"http://example.com/status", "404 Not Found", "<p>status</p>",
+		"http://example.com/added", "200 OK", "<p>new</p>",
+		"http://example.com", "200 OK", "<html><body><h1>Home</h1><p>Welcome back</p><script>var a = 2</script></body></html>",
+	)
+
+	diffs, err := (&Differ{TextDiff: true}).Diff(older, newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []struct {
+		url           string
+		change        DiffChange
+		digestChanged bool
+		statusChanged bool
+		text          string
+	}{
+		{"http://example.com", DiffModified, true, false, "- Welcome\n+ Welcome back\n"},
+		{"http://example.com/added", DiffAdded, false, false, ""},
+		{"http://example.com/removed", DiffRemoved, false, false, ""},
+		{"http://example.com/status", DiffModified, false, true, ""},
+	}
+	if len(diffs) != len(expect) {
+		t.Fatalf("diff count mismatch. expected: %d, got: %d", len(expect), len(diffs))
+	}
+	for i, e := range expect {
+		d := diffs[i]
+		if d.URL != e.url || d.Change != e.change {
+			t.Errorf("case %d mismatch. expected: %s %s, got: %s %s", i, e.change, e.url, d.Change, d.URL)
+		}
+		if d.DigestChanged() != e.digestChanged || d.StatusChanged() != e.statusChanged {
+			t.Errorf("case %d changes mismatch. expected: %t %t, got: %t %t", i, e.digestChanged, e.statusChanged, d.DigestChanged(), d.StatusChanged())
+		}
+		if d.TextDiff != e.text {
+			t.Errorf("case %d text diff mismatch. expected: %q, got: %q", i, e.text, d.TextDiff)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteDiff(buf, diffs); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("~ http://example.com/status status 200 -> 404\n")) {
+		t.Errorf("unexpected diff output:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,409 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNSAnswer is a resource record in the answer to a DNS query
+type DNSAnswer struct {
+	Name  string
+	TTL   uint32
+	Class string
+	Type  string
+	// Data is the record data in zone file form, eg: "93.184.216.34" for an
+	// A record or "10 mail.example.com." for an MX record
+	Data string
+}
+
+// String formats the answer as a line of a text/dns record
+func (a DNSAnswer) String() string {
+	return fmt.Sprintf("%s\t%d\t%s\t%s\t%s", a.Name, a.TTL, a.Class, a.Type, a.Data)
+}
+
+// dnsTypes maps supported query types to their names
+var dnsTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"CNAME": dnsmessage.TypeCNAME,
+	"MX":    dnsmessage.TypeMX,
+	"NS":    dnsmessage.TypeNS,
+	"PTR":   dnsmessage.TypePTR,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+// DNSRecorder resolves hostnames by querying a DNS server directly, writing
+// the answers to each lookup as a response record with a "dns:" target uri
+// and a text/dns block in the form written by Heritrix: a 14-digit fetch
+// timestamp followed by one resource record per line:
+//
+//	20170509000739
+//	example.com.	185	IN	A	93.184.216.34
+//
+// Answers are cached for their TTL, so each host is recorded once per
+// expiry. A DNSRecorder is safe for concurrent use
+type DNSRecorder struct {
+	// Server is the host:port of the DNS server to query
+	Server string
+	// Types lists the record types to look up, "A" if empty. Supported types
+	// are A, AAAA, CNAME, MX, NS, PTR & TXT
+	Types []string
+	// Timeout limits the time taken by each query, 5 seconds if 0
+	Timeout time.Duration
+	// WarcinfoID is set as the WARC-Warcinfo-ID of written records if not
+	// empty
+	WarcinfoID string
+
+	w     *Writer
+	mu    sync.Mutex
+	cache map[string]dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	answers []DNSAnswer
+	expires time.Time
+}
+
+// NewDNSRecorder creates a DNSRecorder querying server & writing to w
+func NewDNSRecorder(w *Writer, server string) *DNSRecorder {
+	return &DNSRecorder{Server: server, w: w}
+}
+
+// Lookup resolves host, writing a record of the answers unless they're
+// cached. Hosts that don't exist return a *net.DNSError
+func (d *DNSRecorder) Lookup(ctx context.Context, host string) ([]DNSAnswer, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	d.mu.Lock()
+	entry, ok := d.cache[host]
+	d.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.answers, nil
+	}
+
+	types := d.Types
+	if len(types) == 0 {
+		types = []string{"A"}
+	}
+	fetched := time.Now()
+	var answers []DNSAnswer
+	for _, t := range types {
+		qtype, ok := dnsTypes[strings.ToUpper(t)]
+		if !ok {
+			return nil, errors.Errorf("warc: unsupported dns record type: %s", t)
+		}
+		ans, err := d.query(ctx, host, qtype)
+		if err != nil {
+			return nil, err
+		}
+		answers = append(answers, ans...)
+	}
+
+	rec := NewDNSRecord(host, fetched, answers)
+	if ip, _, err := net.SplitHostPort(d.Server); err == nil {
+		rec.Headers.Set(FieldNameWARCIPAddress, ip)
+	}
+	if d.WarcinfoID != "" {
+		rec.Headers.Set(FieldNameWARCWarcinfoID, d.WarcinfoID)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, _, err := d.w.WriteRecord(rec); err != nil {
+		return nil, err
+	}
+	if ttl := minTTL(answers); ttl > 0 {
+		if d.cache == nil {
+			d.cache = map[string]dnsCacheEntry{}
+		}
+		d.cache[host] = dnsCacheEntry{answers, fetched.Add(time.Duration(ttl) * time.Second)}
+	}
+	return answers, nil
+}
+
+// LookupIP resolves host to IP addresses using Lookup
+func (d *DNSRecorder) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	answers, err := d.Lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var ips []net.IP
+	for _, a := range answers {
+		if a.Type == "A" || a.Type == "AAAA" {
+			if ip := net.ParseIP(a.Data); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no addresses", Name: host, Server: d.Server, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+// DialContext returns a wrapper around net.DialContext that resolves host
+// names with LookupIP, for http.Transport.DialContext
+func (d *DNSRecorder) DialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		ips, err := d.LookupIP(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			var conn net.Conn
+			if conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// query sends a single query to the server over UDP, retrying over TCP if
+// the answer is truncated
+func (d *DNSRecorder) query(ctx context.Context, host string, qtype dnsmessage.Type) ([]DNSAnswer, error) {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return nil, errors.Wrap(err, "warc: invalid host")
+	}
+	var id [2]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := d.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res, err := d.exchange(ctx, "udp", msg)
+	if err != nil {
+		return nil, err
+	}
+	var p dnsmessage.Parser
+	hdr, err := p.Start(res)
+	if err == nil && hdr.Truncated {
+		if res, err = d.exchange(ctx, "tcp", msg); err != nil {
+			return nil, err
+		}
+		hdr, err = p.Start(res)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "warc: parsing dns response")
+	}
+	if hdr.ID != binary.BigEndian.Uint16(id[:]) {
+		return nil, errors.New("warc: dns response id mismatch")
+	}
+	switch hdr.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, &net.DNSError{Err: "no such host", Name: host, Server: d.Server, IsNotFound: true}
+	default:
+		return nil, &net.DNSError{Err: "server failure: " + hdr.RCode.String(), Name: host, Server: d.Server}
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, errors.Wrap(err, "warc: parsing dns response")
+	}
+	resources, err := p.AllAnswers()
+	if err != nil {
+		return nil, errors.Wrap(err, "warc: parsing dns response")
+	}
+
+	answers := make([]DNSAnswer, 0, len(resources))
+	for _, r := range resources {
+		a := DNSAnswer{
+			Name:  r.Header.Name.String(),
+			TTL:   r.Header.TTL,
+			Class: "IN",
+			Type:  strings.TrimPrefix(r.Header.Type.String(), "Type"),
+		}
+		switch body := r.Body.(type) {
+		case *dnsmessage.AResource:
+			a.Data = net.IP(body.A[:]).String()
+		case *dnsmessage.AAAAResource:
+			a.Data = net.IP(body.AAAA[:]).String()
+		case *dnsmessage.CNAMEResource:
+			a.Data = body.CNAME.String()
+		case *dnsmessage.MXResource:
+			a.Data = fmt.Sprintf("%d %s", body.Pref, body.MX.String())
+		case *dnsmessage.NSResource:
+			a.Data = body.NS.String()
+		case *dnsmessage.PTRResource:
+			a.Data = body.PTR.String()
+		case *dnsmessage.TXTResource:
+			quoted := make([]string, len(body.TXT))
+			for i, s := range body.TXT {
+				quoted[i] = strconv.Quote(s)
+			}
+			a.Data = strings.Join(quoted, " ")
+		default:
+			continue
+		}
+		answers = append(answers, a)
+	}
+	return answers, nil
+}
+
+// exchange sends a query message & reads the response
+func (d *DNSRecorder) exchange(ctx context.Context, network string, msg []byte) ([]byte, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, d.Server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if network == "udp" {
+		if _, err := conn.Write(msg); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+
+	// messages over tcp are prefixed with their length
+	framed := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(framed, uint16(len(msg)))
+	copy(framed[2:], msg)
+	if _, err := conn.Write(framed); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func minTTL(answers []DNSAnswer) uint32 {
+	var lowest uint32
+	for i, a := range answers {
+		if i == 0 || a.TTL < lowest {
+			lowest = a.TTL
+		}
+	}
+	return lowest
+}
+
+// NewDNSRecord creates a text/dns response record of the answers to a
+// lookup of host fetched at a given time
+func NewDNSRecord(host string, fetched time.Time, answers []DNSAnswer) *Record {
+	rec := &Record{Format: RecordFormatWarc, Type: RecordTypeResponse, Headers: Header{}, Content: &bytes.Buffer{}}
+	rec.Headers.Set(FieldNameWARCRecordID, NewUUID())
+	rec.Headers.Set(FieldNameWARCTargetURI, "dns:"+host)
+	rec.Headers.Set(FieldNameWARCDate, fetched.UTC().Format(TimeFormat))
+	rec.Headers.Set(FieldNameContentType, "text/dns")
+
+	rec.Content.WriteString(fetched.UTC().Format(TimestampFormat) + "\n")
+	for _, a := range answers {
+		rec.Content.WriteString(a.String() + "\n")
+	}
+	rec.Headers.Set(FieldNameWARCPayloadDigest, Sha1Digest(rec.Content.Bytes()))
+	return rec
+}
+
+// ParseDNSRecord reads the fetch time & answers from a dns record in the
+// form written by NewDNSRecord
+func ParseDNSRecord(rec *Record) (fetched time.Time, answers []DNSAnswer, err error) {
+	if !strings.HasPrefix(rec.TargetURI(), "dns:") {
+		return fetched, nil, errors.Errorf("warc: not a dns record: %s", rec.TargetURI())
+	}
+	s := bufio.NewScanner(bytes.NewReader(rec.Content.Bytes()))
+	for s.Scan() {
+		line := strings.TrimRight(s.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if fetched.IsZero() {
+			if fetched, err = time.Parse(TimestampFormat, strings.TrimSpace(line)); err != nil {
+				return fetched, nil, errors.Wrap(err, "warc: invalid dns fetch time")
+			}
+			continue
+		}
+
+		// data is kept as written, TXT data may hold runs of spaces
+		fields, data := cutFields(line, 4)
+		if data == "" {
+			return fetched, nil, errors.Errorf("warc: invalid dns answer: %q", line)
+		}
+		ttl, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return fetched, nil, errors.Errorf("warc: invalid dns answer ttl: %q", line)
+		}
+		answers = append(answers, DNSAnswer{
+			Name:  fields[0],
+			TTL:   uint32(ttl),
+			Class: fields[2],
+			Type:  fields[3],
+			Data:  data,
+		})
+	}
+	if fetched.IsZero() {
+		return fetched, nil, errors.New("warc: empty dns record")
+	}
+	return fetched, answers, s.Err()
+}
+
+// cutFields splits the first n whitespace separated fields from s, returning
+// them & the rest of s following them
+func cutFields(s string, n int) (fields []string, rest string) {
+	for len(fields) < n {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		i := strings.IndexFunc(s, unicode.IsSpace)
+		if i < 0 {
+			if s != "" {
+				fields = append(fields, s)
+			}
+			return fields, ""
+		}
+		fields, s = append(fields, s[:i]), s[i:]
+	}
+	return fields, strings.TrimLeftFunc(s, unicode.IsSpace)
+}
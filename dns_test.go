@@ -0,0 +1,289 @@
+package warc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testDNSServer answers queries over udp & tcp from a map of names to
+// resources. Names ending in "truncated." get a truncated udp response
+type testDNSServer struct {
+	addr    string
+	records map[string][]dnsmessage.Resource
+
+	mu      sync.Mutex
+	queries int
+}
+
+func newTestDNSServer(t *testing.T, records map[string][]dnsmessage.Resource) (*testDNSServer, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testDNSServer{addr: pc.LocalAddr().String(), records: records}
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(s.answer(buf[:n], true), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			var length [2]byte
+			io.ReadFull(conn, length[:])
+			msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+			io.ReadFull(conn, msg)
+			res := s.answer(msg, false)
+			binary.BigEndian.PutUint16(length[:], uint16(len(res)))
+			conn.Write(append(length[:], res...))
+			conn.Close()
+		}
+	}()
+	return s, func() {
+		pc.Close()
+		l.Close()
+	}
+}
+
+func (s *testDNSServer) queryCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+func (s *testDNSServer) answer(msg []byte, udp bool) []byte {
+	s.mu.Lock()
+	s.queries++
+	s.mu.Unlock()
+
+	var p dnsmessage.Parser
+	hdr, _ := p.Start(msg)
+	q, _ := p.Question()
+	name := q.Name.String()
+
+	res := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: hdr.ID, Response: true},
+		Questions: []dnsmessage.Question{q},
+	}
+	records, ok := s.records[name]
+	switch {
+	case !ok:
+		res.Header.RCode = dnsmessage.RCodeNameError
+	case udp && strings.HasSuffix(name, "truncated."):
+		res.Header.Truncated = true
+	default:
+		for _, r := range records {
+			if r.Header.Type == q.Type {
+				res.Answers = append(res.Answers, r)
+			}
+		}
+	}
+	data, _ := res.Pack()
+	return data
+}
+
+func testResource(name string, ttl uint32, body dnsmessage.ResourceBody) dnsmessage.Resource {
+	var typ dnsmessage.Type
+	switch body.(type) {
+	case *dnsmessage.AResource:
+		typ = dnsmessage.TypeA
+	case *dnsmessage.AAAAResource:
+		typ = dnsmessage.TypeAAAA
+	case *dnsmessage.MXResource:
+		typ = dnsmessage.TypeMX
+	case *dnsmessage.TXTResource:
+		typ = dnsmessage.TypeTXT
+	}
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   body,
+	}
+}
+
+func TestDNSRecorder(t *testing.T) {
+	srv, stop := newTestDNSServer(t, map[string][]dnsmessage.Resource{
+		"example.com.": {
+			testResource("example.com.", 300, &dnsmessage.AResource{A: [4]byte{93, 184, 216, 34}}),
+			testResource("example.com.", 200, &dnsmessage.AResource{A: [4]byte{93, 184, 216, 35}}),
+			testResource("example.com.", 300, &dnsmessage.AAAAResource{AAAA: [16]byte{0x26, 0x06, 15: 1}}),
+			testResource("example.com.", 60, &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mail.example.com.")}),
+			testResource("example.com.", 60, &dnsmessage.TXTResource{TXT: []string{"v=spf1 -all"}}),
+		},
+		"big.truncated.": {
+			testResource("big.truncated.", 0, &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}),
+		},
+	})
+	defer stop()
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDNSRecorder(w, srv.addr)
+	d.Types = []string{"A", "AAAA", "MX", "TXT"}
+	ctx := context.Background()
+
+	answers, err := d.Lookup(ctx, "Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []DNSAnswer{
+		{"example.com.", 300, "IN", "A", "93.184.216.34"},
+		{"example.com.", 200, "IN", "A", "93.184.216.35"},
+		{"example.com.", 300, "IN", "AAAA", "2606::1"},
+		{"example.com.", 60, "IN", "MX", "10 mail.example.com."},
+		{"example.com.", 60, "IN", "TXT", `"v=spf1 -all"`},
+	}
+	if len(answers) != len(expect) {
+		t.Fatalf("answer count mismatch. expected: %d, got: %d", len(expect), len(answers))
+	}
+	for i, a := range expect {
+		if answers[i] != a {
+			t.Errorf("case %d answer mismatch. expected: %v, got: %v", i, a, answers[i])
+		}
+	}
+
+	// cached
+	queries := srv.queryCount()
+	if _, err := d.Lookup(ctx, "example.com."); err != nil {
+		t.Fatal(err)
+	}
+	if srv.queryCount() != queries {
+		t.Errorf("expected cached answers to be used")
+	}
+
+	d.Types = nil
+	if answers, err := d.Lookup(ctx, "big.truncated"); err != nil || len(answers) != 1 || answers[0].Data != "10.0.0.1" {
+		t.Errorf("expected answer over tcp, got: %v %v", answers, err)
+	}
+	_, err = d.Lookup(ctx, "missing.example.com")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Errorf("expected not found error, got: %v", err)
+	}
+
+	records := readTestRecordsFrom(t, buf)
+	if len(records) != 2 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", 2, len(records))
+	}
+	rec := records[0]
+	if rec.Type != RecordTypeResponse || rec.TargetURI() != "dns:example.com" || rec.Headers.Get(FieldNameContentType) != "text/dns" {
+		t.Errorf("expected text/dns response record, got: %s %s %s", rec.Type, rec.TargetURI(), rec.Headers.Get(FieldNameContentType))
+	}
+	if rec.Headers.Get(FieldNameWARCIPAddress) != "127.0.0.1" {
+		t.Errorf("expected dns server ip address, got: %s", rec.Headers.Get(FieldNameWARCIPAddress))
+	}
+	lines := strings.Split(rec.Content.String(), "\n")
+	if len(lines[0]) != 14 || lines[1] != "example.com.\t300\tIN\tA\t93.184.216.34" {
+		t.Errorf("unexpected record block: %q", rec.Content.String())
+	}
+	fetched, parsed, err := ParseDNSRecord(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.Format(TimestampFormat) != lines[0] || len(parsed) != len(expect) {
+		t.Fatalf("parse mismatch. got: %s %v", fetched, parsed)
+	}
+	for i, a := range expect {
+		if parsed[i] != a {
+			t.Errorf("case %d parsed answer mismatch. expected: %v, got: %v", i, a, parsed[i])
+		}
+	}
+}
+
+func TestDNSRecorderDialContext(t *testing.T) {
+	httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer httpSrv.Close()
+	srv, stop := newTestDNSServer(t, map[string][]dnsmessage.Resource{
+		"archive.test.": {testResource("archive.test.", 60, &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}})},
+	})
+	defer stop()
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDNSRecorder(w, srv.addr)
+	d.Timeout = time.Second
+	client := &http.Client{Transport: &http.Transport{DialContext: d.DialContext(nil)}}
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(httpSrv.URL, "http://"))
+	res, err := client.Get("http://archive.test:" + port + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	records := readTestRecordsFrom(t, buf)
+	if len(records) != 1 || records[0].TargetURI() != "dns:archive.test" {
+		t.Errorf("expected dns record for archive.test, got: %d records", len(records))
+	}
+}
+
+func TestParseDNSRecord(t *testing.T) {
+	rdr, err := NewReader(bytes.NewReader(bytes.TrimLeft(DNSResponseRecord, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := rdr.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetched, answers, err := ParseDNSRecord(&rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetched.Format(TimestampFormat) != "20170509000739" {
+		t.Errorf("fetch time mismatch. got: %s", fetched)
+	}
+	expect := []string{"209.148.113.239", "209.148.113.238", "209.148.113.250"}
+	if len(answers) != len(expect) {
+		t.Fatalf("answer count mismatch. expected: %d, got: %d", len(expect), len(answers))
+	}
+	for i, ip := range expect {
+		if a := answers[i]; a.Name != "google.com." || a.TTL != 185 || a.Type != "A" || a.Data != ip {
+			t.Errorf("case %d answer mismatch. got: %v", i, a)
+		}
+	}
+
+	// TXT data is kept as written, spaces & all
+	txt := []DNSAnswer{{Name: "example.com.", TTL: 300, Class: "IN", Type: "TXT", Data: `"v=spf1  include:_spf.example.com  ~all" "two\tparts "`}}
+	date := time.Date(2017, 5, 9, 0, 7, 39, 0, time.UTC)
+	fetched, answers, err = ParseDNSRecord(NewDNSRecord("example.com", date, txt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fetched.Equal(date) || len(answers) != 1 || answers[0] != txt[0] {
+		t.Errorf("txt round trip mismatch. expected: %v, got: %v", txt, answers)
+	}
+
+	if _, _, err := ParseDNSRecord(&Record{Headers: Header{FieldNameWARCTargetURI: "http://example.com/"}, Content: &bytes.Buffer{}}); err == nil {
+		t.Error("expected error parsing non-dns record")
+	}
+}
@@ -0,0 +1,344 @@
+package warc
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Extractor writes the payloads of response and resource records to a
+// directory tree mirroring the host & path of each record's target uri, so
+// http://example.com/docs/a.pdf is written to {Dir}/example.com/docs/a.pdf.
+// HTTP payloads have any chunked transfer-encoding and gzip or deflate
+// content-encoding removed.
+//
+// Create an Extractor with NewExtractor. An Extractor can be used with
+// multiple WARC files, captures of the same url are written to numbered
+// files (a.pdf, a~1.pdf, a~2.pdf, ...). A url that's also the parent of
+// others is written to an "index" file in their directory, eg: docs/index
+// for http://example.com/docs, or if it was written first, the other urls are
+// written to a numbered directory, eg: docs~1/a.pdf.
+//
+// Records with payloads that can't be read, like HTTP responses that don't
+// parse or have a Content-Encoding other than gzip or deflate (eg: br), are
+// skipped by Extract & logged to ErrorLog.
+type Extractor struct {
+	Dir string
+
+	// MimeTypes limits extraction to payloads with one of these media types.
+	// values ending in "/" match all subtypes, eg: "image/"
+	MimeTypes []string
+	// Statuses limits extraction of response records to these HTTP
+	// status codes. resource records have no status and are unaffected
+	Statuses []int
+	// URLPattern limits extraction to target uris that match
+	URLPattern *regexp.Regexp
+	// ErrorLog logs records Extract skips. If nil, the log package's
+	// standard logger is used
+	ErrorLog *log.Logger
+
+	paths map[string]bool
+	// dirs maps url directories to the directories they're written to
+	dirs map[string]string
+	// madeDirs is the set of directories written to
+	madeDirs map[string]bool
+}
+
+// ExtractedFile describes a payload written by an Extractor, forming one row
+// of the extraction manifest
+type ExtractedFile struct {
+	// Path of the file relative to the extraction directory
+	Path      string
+	RecordID  string
+	TargetURI string
+	Date      string
+	MimeType  string
+	// Status is the HTTP status of response records, 0 for resource records
+	Status int
+	Size   int64
+}
+
+// NewExtractor creates an extractor writing to dir
+func NewExtractor(dir string) *Extractor {
+	return &Extractor{
+		Dir:   dir,
+		paths: map[string]bool{},
+	}
+}
+
+// Extract writes all matching payloads in the WARC file read from r to disk
+func (e *Extractor) Extract(r io.Reader) ([]ExtractedFile, error) {
+	rdr, err := NewOffsetReader(r)
+	if err != nil {
+		return nil, err
+	}
+	files := []ExtractedFile{}
+	for {
+		rec, _, _, err := rdr.Read()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, err
+		}
+		f, err := e.ExtractRecord(rec)
+		if perr, ok := err.(payloadError); ok {
+			e.logf("warc: skipping record %s: %s", rec.ID(), perr.err)
+			continue
+		}
+		if err != nil {
+			return files, errors.Wrapf(err, "extracting record %s", rec.ID())
+		}
+		if f != nil {
+			files = append(files, *f)
+		}
+	}
+}
+
+// ExtractRecord writes the payload of a single record to disk, returning nil
+// if the record doesn't match the extractor's filters. Nothing is left on disk
+// if the payload can't be read
+func (e *Extractor) ExtractRecord(rec *Record) (*ExtractedFile, error) {
+	if e.URLPattern != nil && !e.URLPattern.MatchString(rec.TargetURI()) {
+		return nil, nil
+	}
+
+	f := &ExtractedFile{
+		RecordID:  rec.Headers.Get(FieldNameWARCRecordID),
+		TargetURI: rec.TargetURI(),
+		Date:      rec.Headers.Get(FieldNameWARCDate),
+	}
+	var body io.ReadCloser
+	switch rec.Type {
+	case RecordTypeResponse:
+		if !strings.HasPrefix(rec.TargetURI(), "http") {
+			return nil, nil
+		}
+		res, err := rec.HTTPResponse()
+		if err != nil {
+			return nil, payloadError{err}
+		}
+		f.Status = res.StatusCode
+		f.MimeType = mediaType(res.Header.Get("Content-Type"))
+		if !e.matchStatus(f.Status) || !e.matchMime(f.MimeType) {
+			res.Body.Close()
+			return nil, nil
+		}
+		if body, err = DecodeContentEncoding(res); err != nil {
+			res.Body.Close()
+			return nil, payloadError{err}
+		}
+	case RecordTypeResource:
+		f.MimeType = mediaType(rec.Headers.Get(FieldNameContentType))
+		if !e.matchMime(f.MimeType) {
+			return nil, nil
+		}
+		body = ioutil.NopCloser(bytes.NewReader(rec.Content.Bytes()))
+	default:
+		return nil, nil
+	}
+	defer body.Close()
+
+	f.Path = e.filePath(rec.TargetURI(), f.MimeType)
+	abs := filepath.Join(e.Dir, f.Path)
+	if err := os.MkdirAll(filepath.Dir(abs), os.ModePerm); err != nil {
+		return nil, err
+	}
+	out, err := os.Create(abs)
+	if err != nil {
+		return nil, err
+	}
+	if f.Size, err = io.Copy(out, payloadReader{body}); err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err != nil {
+		os.Remove(abs)
+		delete(e.paths, filepath.ToSlash(f.Path))
+		return nil, err
+	}
+	return f, nil
+}
+
+func (e *Extractor) logf(format string, args ...interface{}) {
+	if e.ErrorLog != nil {
+		e.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// payloadError is an error reading a record's payload, as opposed to an
+// error writing it
+type payloadError struct {
+	err error
+}
+
+func (e payloadError) Error() string {
+	return e.err.Error()
+}
+
+// payloadReader marks errors reading r as payloadErrors
+type payloadReader struct {
+	r io.Reader
+}
+
+func (p payloadReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF {
+		err = payloadError{err}
+	}
+	return n, err
+}
+
+func (e *Extractor) matchStatus(status int) bool {
+	if len(e.Statuses) == 0 {
+		return true
+	}
+	for _, s := range e.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Extractor) matchMime(mime string) bool {
+	if len(e.MimeTypes) == 0 {
+		return true
+	}
+	for _, m := range e.MimeTypes {
+		if m == mime || (strings.HasSuffix(m, "/") && strings.HasPrefix(mime, m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// filePath maps a target uri to a unique path relative to the extraction
+// directory. paths ending in "/" are written to an "index" file, query
+// strings are appended to the file name
+func (e *Extractor) filePath(rawurl, mime string) string {
+	host, p, query := "unknown", "/", ""
+	if u, err := url.Parse(rawurl); err == nil {
+		if u.Host != "" {
+			host = unsafePathChars.ReplaceAllString(u.Host, "_")
+		}
+		if strings.Trim(host, ".") == "" {
+			host = "unknown"
+		}
+		if u.Path != "" {
+			p = u.Path
+		}
+		query = u.RawQuery
+	}
+	if strings.HasSuffix(p, "/") {
+		p += "index"
+		if mime == "text/html" {
+			p += ".html"
+		}
+	}
+	p = path.Clean("/" + p)
+
+	ext := path.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	if query != "" {
+		base += "_" + unsafePathChars.ReplaceAllString(query, "_")
+	}
+
+	if e.paths == nil {
+		e.paths = map[string]bool{}
+	}
+	dir := e.resolveDir(path.Dir(host + base))
+	stem := path.Base(host + base)
+	if e.isDir(path.Join(dir, stem+ext)) {
+		// the url is also the parent of others, write it inside their directory
+		dir, stem, ext = path.Join(dir, stem+ext), "index", ""
+	}
+	rel := path.Join(dir, stem+ext)
+	for i := 1; e.paths[rel] || e.isDir(rel); i++ {
+		rel = path.Join(dir, fmt.Sprintf("%s~%d%s", stem, i, ext))
+	}
+	e.paths[rel] = true
+	return filepath.FromSlash(rel)
+}
+
+// resolveDir gives the directory to write the files of a url directory to,
+// numbering it (docs~1, docs~2, ...) if a file was written at its path
+func (e *Extractor) resolveDir(dir string) string {
+	if e.dirs == nil {
+		e.dirs = map[string]string{}
+		e.madeDirs = map[string]bool{}
+	}
+	if resolved, ok := e.dirs[dir]; ok {
+		return resolved
+	}
+	resolved := dir
+	if parent := path.Dir(dir); parent != "." {
+		resolved = path.Join(e.resolveDir(parent), path.Base(dir))
+	}
+	name := resolved
+	for i := 1; e.isFile(resolved); i++ {
+		resolved = fmt.Sprintf("%s~%d", name, i)
+	}
+	e.dirs[dir] = resolved
+	e.madeDirs[resolved] = true
+	return resolved
+}
+
+// isFile reports whether a file was written at the relative path rel
+func (e *Extractor) isFile(rel string) bool {
+	if e.paths[rel] {
+		return true
+	}
+	fi, err := os.Stat(filepath.Join(e.Dir, filepath.FromSlash(rel)))
+	return err == nil && !fi.IsDir()
+}
+
+// isDir reports whether a directory was written at the relative path rel
+func (e *Extractor) isDir(rel string) bool {
+	if e.madeDirs[rel] {
+		return true
+	}
+	fi, err := os.Stat(filepath.Join(e.Dir, filepath.FromSlash(rel)))
+	return err == nil && fi.IsDir()
+}
+
+var unsafePathChars = regexp.MustCompile(`[^a-zA-Z0-9._=-]+`)
+
+// WriteExtractManifest writes a CSV file mapping extracted files back to
+// the records they came from
+func WriteExtractManifest(w io.Writer, files []ExtractedFile) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"path", "record_id", "target_uri", "date", "mime", "status", "size"})
+	for _, f := range files {
+		status := ""
+		if f.Status != 0 {
+			status = strconv.Itoa(f.Status)
+		}
+		cw.Write([]string{
+			filepath.ToSlash(f.Path),
+			f.RecordID,
+			f.TargetURI,
+			f.Date,
+			f.MimeType,
+			status,
+			strconv.FormatInt(f.Size, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,237 @@
+package warc
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestExtractor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc_extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := NewExtractor(dir)
+	for _, path := range []string{"testdata/warcio/example.warc.gz", "testdata/warcio/example-iana.org-chunked.warc", "testdata/warcio/example-resource.warc.gz"} {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, err := e.Extract(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if len(files) == 0 {
+			t.Errorf("%s: expected extracted files", path)
+		}
+		for _, ef := range files {
+			data, err := ioutil.ReadFile(filepath.Join(dir, ef.Path))
+			if err != nil {
+				t.Errorf("%s: %s", path, err)
+				continue
+			}
+			if int64(len(data)) != ef.Size {
+				t.Errorf("%s: size mismatch for %s. expected: %d, got: %d", path, ef.Path, ef.Size, len(data))
+			}
+		}
+	}
+
+	// gzip content-encoding should be removed
+	data, err := ioutil.ReadFile(filepath.Join(dir, "example.com", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("Example Domain")) {
+		t.Errorf("expected decoded html content")
+	}
+	// chunked transfer-encoding should be removed
+	data, err = ioutil.ReadFile(filepath.Join(dir, "www.iana.org", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("<!doctype html>")) || !bytes.HasSuffix(bytes.TrimSpace(data), []byte("</html>")) {
+		t.Errorf("expected dechunked html content, got: %q...", data[:40])
+	}
+}
+
+func TestExtractorFilters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc_extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		e      *Extractor
+		expect int
+	}{
+		{&Extractor{Dir: dir}, 1},
+		{&Extractor{Dir: dir, MimeTypes: []string{"text/"}}, 1},
+		{&Extractor{Dir: dir, MimeTypes: []string{"application/pdf"}}, 0},
+		{&Extractor{Dir: dir, Statuses: []int{200}}, 1},
+		{&Extractor{Dir: dir, Statuses: []int{404}}, 0},
+		{&Extractor{Dir: dir, URLPattern: regexp.MustCompile(`example\.com`)}, 1},
+		{&Extractor{Dir: dir, URLPattern: regexp.MustCompile(`\.pdf$`)}, 0},
+	}
+
+	for i, c := range cases {
+		f, err := os.Open("testdata/warcio/example.warc.gz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		files, err := c.e.Extract(f)
+		f.Close()
+		if err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		if len(files) != c.expect {
+			t.Errorf("case %d file count mismatch. expected: %d, got: %d", i, c.expect, len(files))
+		}
+	}
+}
+
+func TestExtractorUndecodable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc_extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logged := &bytes.Buffer{}
+	e := NewExtractor(dir)
+	e.ErrorLog = log.New(logged, "", 0)
+	files, err := e.Extract(testRecordsWARC(t,
+		testEncodedResponse("http://example.com/a.html", "br", "\x8b\x03\x80<p>a</p>"),
+		testEncodedResponse("http://example.com/b.html", "gzip", "\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xff\xff\xff"),
+		testEncodedResponse("http://example.com/c.html", "identity", "<p>c</p>"),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || filepath.ToSlash(files[0].Path) != "example.com/c.html" {
+		t.Fatalf("files mismatch. expected: [example.com/c.html], got: %v", files)
+	}
+	for _, name := range []string{"a.html", "b.html"} {
+		if _, err := os.Stat(filepath.Join(dir, "example.com", name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s not to be written", name)
+		}
+	}
+	if n := strings.Count(logged.String(), "skipping record"); n != 2 {
+		t.Errorf("logged records mismatch. expected: 2, got: %d\n%s", n, logged.String())
+	}
+}
+
+// testRecordsWARC writes recs to an uncompressed WARC
+func testRecordsWARC(t *testing.T, recs ...*Record) io.Reader {
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		if _, _, err := w.WriteRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf
+}
+
+func TestExtractorPathCollisions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc_extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		urls   []string
+		expect []string
+	}{
+		{[]string{"http://example.com/docs", "http://example.com/docs/a.pdf"}, []string{"example.com/docs", "example.com/docs~1/a.pdf"}},
+		{[]string{"http://example.org/docs/a.pdf", "http://example.org/docs"}, []string{"example.org/docs/a.pdf", "example.org/docs/index"}},
+	}
+	for i, c := range cases {
+		var responses []string
+		for _, u := range c.urls {
+			responses = append(responses, u, "200 OK", u)
+		}
+		files, err := NewExtractor(dir).Extract(testResponseWARC(t, "2018-01-01T00:00:00Z", responses...))
+		if err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		if len(files) != len(c.expect) {
+			t.Fatalf("case %d file count mismatch. expected: %d, got: %d", i, len(c.expect), len(files))
+		}
+		for j, f := range files {
+			if got := filepath.ToSlash(f.Path); got != c.expect[j] {
+				t.Errorf("case %d.%d path mismatch. expected: %s, got: %s", i, j, c.expect[j], got)
+			}
+			data, err := ioutil.ReadFile(filepath.Join(dir, f.Path))
+			if err != nil || string(data) != c.urls[j] {
+				t.Errorf("case %d.%d content mismatch. expected: %q, got: %q %v", i, j, c.urls[j], data, err)
+			}
+		}
+	}
+}
+
+func TestExtractorFilePath(t *testing.T) {
+	e := NewExtractor("")
+	cases := []struct {
+		url, mime, expect string
+	}{
+		{"http://example.com/", "text/html", "example.com/index.html"},
+		{"http://example.com/docs/a.pdf", "application/pdf", "example.com/docs/a.pdf"},
+		{"http://example.com/docs/a.pdf", "application/pdf", "example.com/docs/a~1.pdf"},
+		{"http://example.com:8080/search?q=a b&x=1", "text/html", "example.com_8080/search_q=a_b_x=1"},
+		{"http://example.com/../../etc/passwd", "text/plain", "example.com/etc/passwd"},
+		{"http://../x", "text/plain", "unknown/x"},
+		// a url that's also a directory is written inside it
+		{"http://example.com/docs", "text/html", "example.com/docs/index"},
+		{"http://example.com/docs/", "text/plain", "example.com/docs/index~1"},
+		// a directory that's already a file is numbered
+		{"http://example.com/a/b", "text/plain", "example.com/a/b"},
+		{"http://example.com/a/b/c.pdf", "application/pdf", "example.com/a/b~1/c.pdf"},
+		{"http://example.com/a/b/d/e.pdf", "application/pdf", "example.com/a/b~1/d/e.pdf"},
+		{"http://example.com/a/b~1", "text/plain", "example.com/a/b~1/index"},
+	}
+	for i, c := range cases {
+		if got := filepath.ToSlash(e.filePath(c.url, c.mime)); got != c.expect {
+			t.Errorf("case %d mismatch. expected: %s, got: %s", i, c.expect, got)
+		}
+	}
+}
+
+func TestWriteExtractManifest(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := WriteExtractManifest(buf, []ExtractedFile{
+		{Path: "example.com/a.pdf", RecordID: "<urn:uuid:1>", TargetURI: "http://example.com/a.pdf", Date: "2017-01-01T00:00:00Z", MimeType: "application/pdf", Status: 200, Size: 10},
+		{Path: "example.com/b.txt", RecordID: "<urn:uuid:2>", TargetURI: "http://example.com/b.txt", MimeType: "text/plain", Size: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "path,record_id,target_uri,date,mime,status,size|example.com/a.pdf,<urn:uuid:1>,http://example.com/a.pdf,2017-01-01T00:00:00Z,application/pdf,200,10|example.com/b.txt,<urn:uuid:2>,http://example.com/b.txt,,text/plain,,5"
+	got := []string{}
+	for _, row := range rows {
+		got = append(got, strings.Join(row, ","))
+	}
+	if strings.Join(got, "|") != expect {
+		t.Errorf("manifest mismatch.\nexpected: %s\ngot:      %s", expect, strings.Join(got, "|"))
+	}
+}
@@ -142,4 +142,13 @@ const (
 	// This field is mandatory on the last 'continuation' record of a series,
 	// and shall not be used elsewhere.
 	FieldNameWARCSegmentTotalLength = "WARC-Segment-Total-Length"
+	// The languages of a record's content, as a comma-separated list of
+	// ISO 639-3 codes. Not part of the WARC standard, this field is written
+	// to 'conversion' records of WET files by Common Crawl.
+	FieldNameWARCIdentifiedContentLanguage = "WARC-Identified-Content-Language"
+	// The protocol an exchange was made over, when a request or response
+	// record holds a message of another protocol serialised in HTTP/1.1 form,
+	// eg: 'h2' for HTTP/2. Not part of the WARC 1.1 standard, this field is a
+	// proposed extension to it.
+	FieldNameWARCProtocol = "WARC-Protocol"
 )
@@ -0,0 +1,295 @@
+package warc
+
+import (
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Filter reports whether a record should be kept. Filters are combined
+// with FilterAll, FilterAny and FilterNot to build expressions, eg: text/html
+// responses from example.com:
+//
+//	FilterAll(
+//		FilterTypes(RecordTypeResponse),
+//		FilterMimeTypes("text/html"),
+//		FilterSURTPrefix("com,example)/"),
+//	)
+type Filter func(rec *Record) bool
+
+// FilterAll matches records that match all filters. An empty list matches
+// all records
+func FilterAll(filters ...Filter) Filter {
+	return func(rec *Record) bool {
+		for _, f := range filters {
+			if !f(rec) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// FilterAny matches records that match at least one filter. An empty list
+// matches no records
+func FilterAny(filters ...Filter) Filter {
+	return func(rec *Record) bool {
+		for _, f := range filters {
+			if f(rec) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// FilterNot inverts a filter
+func FilterNot(f Filter) Filter {
+	return func(rec *Record) bool {
+		return !f(rec)
+	}
+}
+
+// FilterTypes matches records of any of the given types
+func FilterTypes(types ...RecordType) Filter {
+	return func(rec *Record) bool {
+		return hasType(types, rec.Type)
+	}
+}
+
+// FilterURLPrefix matches records with a target uri starting with prefix
+func FilterURLPrefix(prefix string) Filter {
+	return func(rec *Record) bool {
+		return strings.HasPrefix(rec.TargetURI(), prefix)
+	}
+}
+
+// FilterSURTPrefix matches records with a SURT-formatted target uri starting
+// with prefix. "com,example)/" matches all of example.com, "com,example"
+// also matches subdomains. prefix may also be a url, which is converted
+// to SURT form
+func FilterSURTPrefix(prefix string) (Filter, error) {
+	if strings.Contains(prefix, "://") {
+		surt, err := SURT(prefix)
+		if err != nil {
+			return nil, err
+		}
+		prefix = surt
+	}
+	prefix = strings.ToLower(prefix)
+	return func(rec *Record) bool {
+		surt, err := SURT(rec.TargetURI())
+		return err == nil && rec.TargetURI() != "" && strings.HasPrefix(surt, prefix)
+	}, nil
+}
+
+// FilterMimeTypes matches records with a payload of one of the given media
+// types. Values ending in "/" match all subtypes, eg: "image/". The media
+// type of response & revisit records is read from the HTTP Content-Type
+// header, other records use the WARC Content-Type
+func FilterMimeTypes(types ...string) Filter {
+	return func(rec *Record) bool {
+		mime, _ := recordMimeStatus(rec)
+		for _, m := range types {
+			if m == mime || (strings.HasSuffix(m, "/") && strings.HasPrefix(mime, m)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// FilterStatuses matches response & revisit records with one of the given
+// HTTP status codes
+func FilterStatuses(codes ...int) Filter {
+	return func(rec *Record) bool {
+		_, status := recordMimeStatus(rec)
+		for _, c := range codes {
+			if c == status {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// FilterDateRange matches records with a WARC-Date in the range [from, to).
+// A zero from or to leaves that end of the range open
+func FilterDateRange(from, to time.Time) Filter {
+	return func(rec *Record) bool {
+		d := rec.Date()
+		if d.IsZero() {
+			return false
+		}
+		return (from.IsZero() || !d.Before(from)) && (to.IsZero() || d.Before(to))
+	}
+}
+
+// FilterHeader matches records with a WARC header value matching pattern
+func FilterHeader(name string, pattern *regexp.Regexp) Filter {
+	return func(rec *Record) bool {
+		v := rec.Headers.Get(name)
+		return v != "" && pattern.MatchString(v)
+	}
+}
+
+// FilterHTTPHeader matches records holding an HTTP message with a header
+// value matching pattern
+func FilterHTTPHeader(name string, pattern *regexp.Regexp) Filter {
+	return func(rec *Record) bool {
+		var h http.Header
+		switch rec.Type {
+		case RecordTypeRequest:
+			req, err := rec.HTTPRequest()
+			if err != nil {
+				return false
+			}
+			h = req.Header
+		case RecordTypeResponse, RecordTypeRevisit:
+			res, err := rec.HTTPResponse()
+			if err != nil {
+				return false
+			}
+			res.Body.Close()
+			h = res.Header
+		default:
+			return false
+		}
+		for _, v := range h[http.CanonicalHeaderKey(name)] {
+			if pattern.MatchString(v) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// recordMimeStatus gives the payload media type and HTTP status of a record,
+// status is 0 for records that aren't HTTP responses
+func recordMimeStatus(rec *Record) (mime string, status int) {
+	switch rec.Type {
+	case RecordTypeResponse, RecordTypeRevisit:
+		if strings.HasPrefix(rec.TargetURI(), "http") {
+			if res, err := rec.HTTPResponse(); err == nil {
+				res.Body.Close()
+				return mediaType(res.Header.Get("Content-Type")), res.StatusCode
+			}
+		}
+	}
+	return mediaType(rec.Headers.Get(FieldNameContentType)), 0
+}
+
+// Pipeline streams records from WARC files through a filter into a Writer
+type Pipeline struct {
+	// Filter selects records to write, a nil filter keeps all records
+	Filter Filter
+	// KeepGroups keeps records linked by WARC-Concurrent-To together. Groups
+	// are kept or dropped as a whole, based on the group's primary record:
+	// the first response, resource or revisit record, or the first record of
+	// the group if there is none. Record type filters are judged on the
+	// primary record too, so leave KeepGroups off when filtering by type
+	KeepGroups bool
+	// KeepWarcinfo writes warcinfo records regardless of Filter
+	KeepWarcinfo bool
+	// Rewrite is called on each kept record before it's written, and may
+	// modify the record
+	Rewrite func(rec *Record) error
+}
+
+// PipelineStats counts the records processed by a Pipeline
+type PipelineStats struct {
+	Read    int
+	Written int
+}
+
+// Run reads all records from r, writing the records that pass the filter
+// to w
+func (p *Pipeline) Run(w *Writer, r io.Reader) (PipelineStats, error) {
+	stats := PipelineStats{}
+	rdr, err := NewOffsetReader(r)
+	if err != nil {
+		return stats, err
+	}
+
+	var group []*Record
+	for {
+		rec, _, _, err := rdr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.Read++
+
+		if p.KeepGroups && rec.Type != RecordTypeWarcInfo {
+			if len(group) == 0 || linkedRecord(group, rec) {
+				group = append(group, rec)
+				continue
+			}
+			if err := p.writeGroup(w, group, &stats); err != nil {
+				return stats, err
+			}
+			group = []*Record{rec}
+			continue
+		}
+
+		if err := p.writeGroup(w, group, &stats); err != nil {
+			return stats, err
+		}
+		group = nil
+		if err := p.writeGroup(w, []*Record{rec}, &stats); err != nil {
+			return stats, err
+		}
+	}
+	err = p.writeGroup(w, group, &stats)
+	return stats, err
+}
+
+// writeGroup writes a group of records if its primary record is kept
+func (p *Pipeline) writeGroup(w *Writer, group []*Record, stats *PipelineStats) error {
+	if len(group) == 0 {
+		return nil
+	}
+	primary := group[0]
+	for _, rec := range group {
+		if rec.Type == RecordTypeResponse || rec.Type == RecordTypeResource || rec.Type == RecordTypeRevisit {
+			primary = rec
+			break
+		}
+	}
+	keep := p.Filter == nil || p.Filter(primary) || (p.KeepWarcinfo && primary.Type == RecordTypeWarcInfo)
+	if !keep {
+		return nil
+	}
+
+	for _, rec := range group {
+		if p.Rewrite != nil {
+			if err := p.Rewrite(rec); err != nil {
+				return errors.Wrapf(err, "rewriting record %s", rec.ID())
+			}
+		}
+		if _, _, err := w.WriteRecord(rec); err != nil {
+			return err
+		}
+		stats.Written++
+	}
+	return nil
+}
+
+// linkedRecord reports whether rec is linked to any record in group by
+// WARC-Concurrent-To, in either direction
+func linkedRecord(group []*Record, rec *Record) bool {
+	id, to := rec.Headers.Get(FieldNameWARCRecordID), rec.Headers.Get(FieldNameWARCConcurrentTo)
+	for _, g := range group {
+		gid, gto := g.Headers.Get(FieldNameWARCRecordID), g.Headers.Get(FieldNameWARCConcurrentTo)
+		if (to != "" && (to == gid || to == gto)) || (id != "" && id == gto) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package warc
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func readTestRecords(t *testing.T, path string) []*Record {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	return readTestRecordsFrom(t, f)
+}
+
+func readTestRecordsFrom(t *testing.T, r io.Reader) []*Record {
+	rdr, err := NewOffsetReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []*Record
+	for {
+		rec, _, _, err := rdr.Read()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	records := readTestRecords(t, "testdata/warcio/example.warc.gz")
+	surt, err := FilterSURTPrefix("com,example)/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	surtURL, err := FilterSURTPrefix("http://www.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		filter Filter
+		expect int
+	}{
+		{FilterAll(), 6},
+		{FilterAny(), 0},
+		{FilterTypes(RecordTypeResponse, RecordTypeRevisit), 2},
+		{FilterNot(FilterTypes(RecordTypeWarcInfo)), 4},
+		{FilterURLPrefix("http://example.com/"), 4},
+		{FilterURLPrefix("http://example.org/"), 0},
+		{surt, 4},
+		{surtURL, 4},
+		{FilterMimeTypes("text/html"), 2},
+		{FilterMimeTypes("text/"), 2},
+		{FilterMimeTypes("application/warc-fields"), 2},
+		{FilterStatuses(200), 2},
+		{FilterStatuses(404), 0},
+		{FilterDateRange(time.Date(2017, 3, 6, 4, 3, 0, 0, time.UTC), time.Time{}), 4},
+		{FilterDateRange(time.Time{}, time.Date(2017, 3, 6, 4, 3, 0, 0, time.UTC)), 2},
+		{FilterHeader(FieldNameWARCProfile, regexp.MustCompile("identical-payload-digest$")), 1},
+		{FilterHTTPHeader("content-encoding", regexp.MustCompile("^gzip$")), 2},
+		{FilterHTTPHeader("User-Agent", regexp.MustCompile("Mozilla")), 2},
+		{FilterAll(FilterTypes(RecordTypeResponse), FilterMimeTypes("text/html")), 1},
+	}
+
+	for i, c := range cases {
+		got := 0
+		for _, rec := range records {
+			if c.filter(rec) {
+				got++
+			}
+		}
+		if got != c.expect {
+			t.Errorf("case %d match count mismatch. expected: %d, got: %d", i, c.expect, got)
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	cases := []struct {
+		path     string
+		pipeline *Pipeline
+		read     int
+		written  []string
+	}{
+		{"testdata/warcio/example.warc.gz", &Pipeline{}, 6, []string{"warcinfo", "warcinfo", "response", "request", "revisit", "request"}},
+		{"testdata/warcio/example.warc.gz", &Pipeline{Filter: FilterStatuses(200)}, 6, []string{"response", "revisit"}},
+		{"testdata/warcio/example.warc.gz", &Pipeline{Filter: FilterTypes(RecordTypeRevisit), KeepGroups: true, KeepWarcinfo: true}, 6, []string{"warcinfo", "warcinfo", "revisit", "request"}},
+		{"testdata/warcio/post-test.warc.gz", &Pipeline{Filter: FilterNot(FilterURLPrefix("http://httpbin.org/post?foo=bar")), KeepGroups: true}, 6, []string{"response", "request", "response", "request"}},
+		{"testdata/warcio/post-test.warc.gz", &Pipeline{Filter: FilterURLPrefix("http://httpbin.org/post?"), Rewrite: func(rec *Record) error {
+			rec.Headers.Set(FieldNameWARCTargetURI, "http://example.com/")
+			return nil
+		}}, 6, []string{"response", "request"}},
+	}
+
+	for i, c := range cases {
+		f, err := os.Open(c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		w, err := NewWriterRaw(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats, err := c.pipeline.Run(w, f)
+		f.Close()
+		if err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		if stats.Read != c.read || stats.Written != len(c.written) {
+			t.Errorf("case %d stats mismatch. expected: %d/%d, got: %d/%d", i, c.read, len(c.written), stats.Read, stats.Written)
+		}
+
+		rdr, err := NewOffsetReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, typ := range c.written {
+			rec, _, _, err := rdr.Read()
+			if err != nil {
+				t.Errorf("case %d record %d error: %s", i, j, err)
+				break
+			}
+			if rec.Type.String() != typ {
+				t.Errorf("case %d record %d type mismatch. expected: %s, got: %s", i, j, typ, rec.Type)
+			}
+			if c.pipeline.Rewrite != nil && rec.TargetURI() != "http://example.com/" {
+				t.Errorf("case %d record %d expected rewritten target uri, got: %s", i, j, rec.TargetURI())
+			}
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package warc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HAR is an HTTP Archive, the JSON format browsers export network activity
+// in. See http://www.softwareishard.com/blog/har-12-spec/
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+// HARLog is the root of HAR data
+type HARLog struct {
+	Version string      `json:"version"`
+	Creator HARCreator  `json:"creator"`
+	Browser *HARCreator `json:"browser,omitempty"`
+	Pages   []HARPage   `json:"pages,omitempty"`
+	Entries []HAREntry  `json:"entries"`
+}
+
+// HARCreator names the application that created a HAR file
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HARPage is a page load, grouping the entries requested by the page
+type HARPage struct {
+	StartedDateTime string         `json:"startedDateTime"`
+	ID              string         `json:"id"`
+	Title           string         `json:"title"`
+	PageTimings     HARPageTimings `json:"pageTimings"`
+}
+
+// HARPageTimings are page load timings, in milliseconds. -1 is unknown
+type HARPageTimings struct {
+	OnContentLoad float64 `json:"onContentLoad,omitempty"`
+	OnLoad        float64 `json:"onLoad,omitempty"`
+}
+
+// HAREntry is a single HTTP request & response
+type HAREntry struct {
+	Pageref         string      `json:"pageref,omitempty"`
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	Connection      string      `json:"connection,omitempty"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+// HARRequest is an HTTP request
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+// HARResponse is an HTTP response
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+// HARNameValue is a header or query string parameter
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARCookie is a cookie sent with a request or set by a response
+type HARCookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path,omitempty"`
+	Domain   string `json:"domain,omitempty"`
+	Expires  string `json:"expires,omitempty"`
+	HTTPOnly bool   `json:"httpOnly,omitempty"`
+	Secure   bool   `json:"secure,omitempty"`
+}
+
+// HARPostData is the body of a request
+type HARPostData struct {
+	MimeType string         `json:"mimeType"`
+	Params   []HARNameValue `json:"params,omitempty"`
+	Text     string         `json:"text"`
+}
+
+// HARContent is the body of a response, with any content-encoding removed.
+// Binary bodies are base64 encoded, as are bodies that can't be decoded,
+// which are kept as stored with a Comment saying why
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARTimings break down the time taken by an entry, in milliseconds.
+// -1 is unknown
+type HARTimings struct {
+	Blocked float64 `json:"blocked,omitempty"`
+	DNS     float64 `json:"dns,omitempty"`
+	Connect float64 `json:"connect,omitempty"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl,omitempty"`
+}
+
+// ReadHAR decodes a HAR file
+func ReadHAR(r io.Reader) (*HAR, error) {
+	har := &HAR{}
+	if err := json.NewDecoder(r).Decode(har); err != nil {
+		return nil, errors.Wrap(err, "warc: reading har")
+	}
+	return har, nil
+}
+
+// ImportHAR writes a request & response record pair for each entry in the
+// HAR file read from r, returning the number of entries written. Entries
+// without a response (eg: blocked requests) are skipped
+func ImportHAR(w *Writer, r io.Reader) (int, error) {
+	har, err := ReadHAR(r)
+	if err != nil {
+		return 0, err
+	}
+	written := 0
+	for i := range har.Log.Entries {
+		e := &har.Log.Entries[i]
+		if e.Response.Status == 0 {
+			continue
+		}
+		req, res, err := NewHARRecords(e)
+		if err != nil {
+			return written, errors.Wrapf(err, "har entry %d", i)
+		}
+		for _, rec := range []*Record{req, res} {
+			if _, _, err := w.WriteRecord(rec); err != nil {
+				return written, err
+			}
+		}
+		written++
+	}
+	return written, nil
+}
+
+// NewHARRecords creates request & response records for a HAR entry,
+// reconstructing HTTP/1.x messages from the entry's headers & bodies.
+// As HAR bodies are stored decoded, the Content-Encoding &
+// Transfer-Encoding headers are dropped from responses and Content-Length
+// is set to the length of the decoded body.
+func NewHARRecords(e *HAREntry) (req, res *Record, err error) {
+	u, err := url.Parse(e.Request.URL)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid request url")
+	}
+	date := time.Now()
+	if e.StartedDateTime != "" {
+		if date, err = time.Parse(time.RFC3339Nano, e.StartedDateTime); err != nil {
+			return nil, nil, errors.Wrap(err, "invalid startedDateTime")
+		}
+	}
+
+	req = &Record{Format: RecordFormatWarc, Type: RecordTypeRequest, Headers: Header{}, Content: &bytes.Buffer{}}
+	res = &Record{Format: RecordFormatWarc, Type: RecordTypeResponse, Headers: Header{}, Content: &bytes.Buffer{}}
+	reqID, resID := NewUUID(), NewUUID()
+	req.Headers.Set(FieldNameWARCRecordID, reqID)
+	res.Headers.Set(FieldNameWARCRecordID, resID)
+	res.Headers.Set(FieldNameWARCConcurrentTo, reqID)
+	req.Headers.Set(FieldNameContentType, "application/http; msgtype=request")
+	res.Headers.Set(FieldNameContentType, "application/http; msgtype=response")
+	for _, rec := range []*Record{req, res} {
+		rec.Headers.Set(FieldNameWARCDate, date.UTC().Format(TimeFormat))
+		rec.Headers.Set(FieldNameWARCTargetURI, u.String())
+		if ip := strings.Trim(e.ServerIPAddress, "[]"); ip != "" {
+			rec.Headers.Set(FieldNameWARCIPAddress, ip)
+		}
+		if id := harWARCProtocol(e.Response.HTTPVersion); id != "" {
+			rec.Headers.Set(FieldNameWARCProtocol, id)
+		}
+	}
+
+	// request
+	var reqBody []byte
+	if e.Request.PostData != nil {
+		reqBody = []byte(e.Request.PostData.Text)
+	}
+	fmt.Fprintf(req.Content, "%s %s %s\r\n", e.Request.Method, u.RequestURI(), harProto(e.Request.HTTPVersion))
+	hasHost := false
+	for _, h := range e.Request.Headers {
+		if strings.EqualFold(h.Name, "host") {
+			hasHost = true
+		}
+		writeHARHeader(req.Content, h)
+	}
+	if !hasHost {
+		fmt.Fprintf(req.Content, "Host: %s\r\n", u.Host)
+	}
+	req.Content.WriteString("\r\n")
+	req.Content.Write(reqBody)
+	req.Headers.Set(FieldNameWARCPayloadDigest, Sha1Digest(reqBody))
+	req.Headers.Set(FieldNameWARCBlockDigest, Sha1Digest(req.Content.Bytes()))
+
+	// response
+	body := []byte(e.Response.Content.Text)
+	if e.Response.Content.Encoding == "base64" {
+		if body, err = base64.StdEncoding.DecodeString(e.Response.Content.Text); err != nil {
+			return nil, nil, errors.Wrap(err, "decoding response content")
+		}
+	}
+	status := e.Response.StatusText
+	if status == "" {
+		status = http.StatusText(e.Response.Status)
+	}
+	fmt.Fprintf(res.Content, "%s %03d %s\r\n", harProto(e.Response.HTTPVersion), e.Response.Status, status)
+	for _, h := range e.Response.Headers {
+		switch strings.ToLower(h.Name) {
+		case "content-encoding", "transfer-encoding", "content-length":
+			continue
+		}
+		writeHARHeader(res.Content, h)
+	}
+	if bodyAllowed(e.Response.Status) {
+		fmt.Fprintf(res.Content, "Content-Length: %d\r\n", len(body))
+	}
+	res.Content.WriteString("\r\n")
+	res.Content.Write(body)
+	res.Headers.Set(FieldNameWARCPayloadDigest, Sha1Digest(body))
+	if e.Response.Content.Size > int64(len(body)) {
+		// browsers may omit large or binary bodies
+		res.Headers.Set(FieldNameWARCTruncated, "unspecified")
+	}
+	return req, res, nil
+}
+
+// harProto normalises a HAR httpVersion to an HTTP/1.x protocol version.
+// Messages of other protocols are written in HTTP/1.1 form
+func harProto(version string) string {
+	switch v := strings.ToUpper(version); v {
+	case "HTTP/1.0", "HTTP/1.1":
+		return v
+	default:
+		return "HTTP/1.1"
+	}
+}
+
+// harWARCProtocol gives the WARC-Protocol id of a HAR httpVersion that's
+// written in HTTP/1.1 form, eg: "h2" for "HTTP/2.0" or "h2", or "" for
+// HTTP/1.x
+func harWARCProtocol(version string) string {
+	v := strings.ToUpper(version)
+	for proto, id := range httpProtocols {
+		if v == proto || v == strings.TrimSuffix(proto, ".0") || v == strings.ToUpper(id) {
+			return id
+		}
+	}
+	return ""
+}
+
+// writeHARHeader writes a header line, skipping HTTP/2 pseudo-headers
+// (eg: ":authority")
+func writeHARHeader(w io.Writer, h HARNameValue) {
+	if strings.HasPrefix(h.Name, ":") || h.Name == "" {
+		return
+	}
+	fmt.Fprintf(w, "%s: %s\r\n", h.Name, h.Value)
+}
+
+// bodyAllowed reports whether responses with status may include a body
+func bodyAllowed(status int) bool {
+	return !(status >= 100 && status < 200) && status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -0,0 +1,215 @@
+package warc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImportHAR(t *testing.T) {
+	f, err := os.Open("testdata/example.har")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := ImportHAR(w, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("entry count mismatch. expected: %d, got: %d", 2, n)
+	}
+
+	report, err := Validate(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() || report.Warnings != 0 {
+		t.Errorf("expected valid WARC, got issues: %v", report.Issues)
+	}
+
+	records := readTestRecordsFrom(t, buf)
+	if len(records) != 4 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", 4, len(records))
+	}
+
+	cases := []struct {
+		rec                          *Record
+		typ                          RecordType
+		uri, date, ip, proto, header string
+		body                         string
+	}{
+		{records[0], RecordTypeRequest, "http://example.com/", "2018-02-14T16:47:20Z", "93.184.216.34", "", "GET / HTTP/1.1\r\nHost: example.com\r\n", ""},
+		{records[1], RecordTypeResponse, "http://example.com/", "2018-02-14T16:47:20Z", "93.184.216.34", "", "HTTP/1.1 200 OK\r\nContent-Type: text/html; charset=UTF-8\r\nContent-Length: 52\r\n\r\n", "<html><body><h1>Example Domain</h1></body></html>\n\n\n"},
+		{records[2], RecordTypeRequest, "https://example.com/form?a=1", "2018-02-14T15:47:20Z", "2606:2800:220:1:248:1893:25c8:1946", "h2", "POST /form?a=1 HTTP/1.1\r\ncontent-type: application/x-www-form-urlencoded\r\nHost: example.com\r\n\r\n", "name=value"},
+		{records[3], RecordTypeResponse, "https://example.com/form?a=1", "2018-02-14T15:47:20Z", "2606:2800:220:1:248:1893:25c8:1946", "h2", "HTTP/1.1 201 Created\r\ncontent-type: image/gif\r\n", ""},
+	}
+	for i, c := range cases {
+		if c.rec.Type != c.typ {
+			t.Errorf("case %d type mismatch. expected: %s, got: %s", i, c.typ, c.rec.Type)
+		}
+		for _, f := range []struct{ name, expect string }{
+			{FieldNameWARCTargetURI, c.uri},
+			{FieldNameWARCDate, c.date},
+			{FieldNameWARCIPAddress, c.ip},
+			{FieldNameWARCProtocol, c.proto},
+		} {
+			if got := c.rec.Headers.Get(f.name); got != f.expect {
+				t.Errorf("case %d %s mismatch. expected: %s, got: %s", i, f.name, f.expect, got)
+			}
+		}
+		if !bytes.HasPrefix(c.rec.Content.Bytes(), []byte(c.header)) {
+			t.Errorf("case %d message mismatch. expected prefix: %q, got: %q", i, c.header, c.rec.Content.String())
+		}
+		if c.body != "" && !bytes.HasSuffix(c.rec.Content.Bytes(), []byte("\r\n\r\n"+c.body)) {
+			t.Errorf("case %d body mismatch. expected: %q, got: %q", i, c.body, c.rec.Content.String())
+		}
+	}
+
+	for i, rec := range records {
+		if rec.Headers.Get(FieldNameWARCTruncated) != "" {
+			t.Errorf("record %d unexpectedly truncated", i)
+		}
+	}
+	if records[1].Headers.Get(FieldNameWARCConcurrentTo) != records[0].Headers.Get(FieldNameWARCRecordID) {
+		t.Errorf("expected response to be concurrent to request")
+	}
+	res, err := records[3].HTTPResponse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gif, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gif) != 42 || string(gif[:6]) != "GIF89a" {
+		t.Errorf("expected decoded gif body, got: %q", gif)
+	}
+}
+
+func TestExportHAR(t *testing.T) {
+	f, err := os.Open("testdata/warcio/example.warc.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := &bytes.Buffer{}
+	if err := ExportHAR(buf, f); err != nil {
+		t.Fatal(err)
+	}
+	har, err := ReadHAR(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(har.Log.Entries) != 2 {
+		t.Fatalf("entry count mismatch. expected: %d, got: %d", 2, len(har.Log.Entries))
+	}
+	for i, e := range har.Log.Entries {
+		if e.Request.Method != "GET" || e.Request.URL != "http://example.com/" {
+			t.Errorf("case %d request mismatch. got: %s %s", i, e.Request.Method, e.Request.URL)
+		}
+		if len(e.Request.Headers) == 0 || e.Request.Headers[0].Name != "Host" {
+			t.Errorf("case %d expected request headers in original order, got: %v", i, e.Request.Headers)
+		}
+		if e.Response.Status != 200 || e.Response.StatusText != "OK" {
+			t.Errorf("case %d status mismatch. expected: 200 OK, got: %d %s", i, e.Response.Status, e.Response.StatusText)
+		}
+		// the revisit resolves to the gzipped body of the original response
+		c := e.Response.Content
+		if c.Size != 1270 || c.Encoding != "" || !strings.Contains(c.Text, "<title>Example Domain</title>") {
+			t.Errorf("case %d content mismatch. got: %d %q %q", i, c.Size, c.Encoding, c.Text)
+		}
+	}
+
+	// round trip through import
+	hf, err := os.Open("testdata/example.har")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hf.Close()
+	warcBuf := &bytes.Buffer{}
+	w, err := NewWriterRaw(warcBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportHAR(w, hf); err != nil {
+		t.Fatal(err)
+	}
+	if har, err = HARFromWARC(warcBuf); err != nil {
+		t.Fatal(err)
+	}
+	if len(har.Log.Entries) != 2 {
+		t.Fatalf("entry count mismatch. expected: %d, got: %d", 2, len(har.Log.Entries))
+	}
+
+	post := har.Log.Entries[0]
+	if post.StartedDateTime != "2018-02-14T15:47:20.000Z" {
+		t.Errorf("startedDateTime mismatch. expected: %s, got: %s", "2018-02-14T15:47:20.000Z", post.StartedDateTime)
+	}
+	if post.Request.Method != "POST" || post.Request.PostData == nil || post.Request.PostData.Text != "name=value" {
+		t.Errorf("expected POST with body, got: %s %v", post.Request.Method, post.Request.PostData)
+	}
+	if len(post.Request.QueryString) != 1 || post.Request.QueryString[0] != (HARNameValue{"a", "1"}) {
+		t.Errorf("query string mismatch. got: %v", post.Request.QueryString)
+	}
+	if post.Response.Content.Encoding != "base64" || post.Response.Content.Size != 42 || post.Response.Content.MimeType != "image/gif" {
+		t.Errorf("expected base64 gif content, got: %v", post.Response.Content)
+	}
+	if post.Request.HTTPVersion != "HTTP/2.0" || post.Response.HTTPVersion != "HTTP/2.0" {
+		t.Errorf("http version mismatch. expected: HTTP/2.0, got: %s, %s", post.Request.HTTPVersion, post.Response.HTTPVersion)
+	}
+	if post.ServerIPAddress != "2606:2800:220:1:248:1893:25c8:1946" {
+		t.Errorf("server ip mismatch. got: %s", post.ServerIPAddress)
+	}
+	get := har.Log.Entries[1]
+	if get.Response.Content.Text != "<html><body><h1>Example Domain</h1></body></html>\n\n\n" || get.Response.Content.Encoding != "" {
+		t.Errorf("expected text content, got: %v", get.Response.Content)
+	}
+}
+
+func TestExportHARUndecodable(t *testing.T) {
+	unparseable := testEncodedResponse("http://example.com/b", "identity", "")
+	unparseable.Content = bytes.NewBufferString("not http")
+	har, err := HARFromWARC(testRecordsWARC(t,
+		testEncodedResponse("http://example.com/a", "br", "\x8b\x03\x80<p>a</p>"),
+		unparseable,
+		testEncodedResponse("http://example.com/c", "identity", "<p>c</p>"),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(har.Log.Entries) != 3 {
+		t.Fatalf("entry count mismatch. expected: %d, got: %d", 3, len(har.Log.Entries))
+	}
+
+	cases := []struct {
+		status  int
+		text    string
+		comment string
+	}{
+		{200, base64.StdEncoding.EncodeToString([]byte("\x8b\x03\x80<p>a</p>")), "can't be decoded"},
+		{0, base64.StdEncoding.EncodeToString([]byte("not http")), "can't be parsed"},
+		{200, "<p>c</p>", ""},
+	}
+	for i, c := range cases {
+		e := har.Log.Entries[i]
+		if e.Response.Status != c.status {
+			t.Errorf("case %d status mismatch. expected: %d, got: %d", i, c.status, e.Response.Status)
+		}
+		if e.Response.Content.Text != c.text {
+			t.Errorf("case %d content mismatch. expected: %q, got: %q", i, c.text, e.Response.Content.Text)
+		}
+		if c.comment == "" && e.Response.Content.Comment != "" || !strings.Contains(e.Response.Content.Comment, c.comment) {
+			t.Errorf("case %d comment mismatch. expected: %q, got: %q", i, c.comment, e.Response.Content.Comment)
+		}
+	}
+}
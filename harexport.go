@@ -0,0 +1,329 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// ExportHAR writes a HAR 1.2 document of the HTTP exchanges in the WARC file
+// read from r. See HARFromWARC
+func ExportHAR(w io.Writer, r io.Reader) error {
+	har, err := HARFromWARC(r)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(har)
+}
+
+// HARFromWARC creates a HAR document with an entry for each HTTP response
+// or revisit record in the WARC file read from r, ordered by date. Request
+// records are paired with responses by WARC-Concurrent-To, in either
+// direction. Revisits take their body from the response they refer to, if
+// it's in the same file.
+func HARFromWARC(r io.Reader) (*HAR, error) {
+	rdr, err := NewOffsetReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var responses []*Record
+	requests := map[string]*Record{}  // by record id
+	concurrent := map[string]string{} // request id by response id
+	originals := map[string]*Record{} // responses by payload digest
+	for {
+		rec, _, _, err := rdr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(rec.TargetURI(), "http") {
+			continue
+		}
+		id, to := rec.Headers.Get(FieldNameWARCRecordID), rec.Headers.Get(FieldNameWARCConcurrentTo)
+		switch rec.Type {
+		case RecordTypeRequest:
+			requests[id] = rec
+			if to != "" {
+				concurrent[to] = id
+			}
+		case RecordTypeResponse:
+			if d := rec.Headers.Get(FieldNameWARCPayloadDigest); d != "" {
+				originals[d] = rec
+			}
+			fallthrough
+		case RecordTypeRevisit:
+			responses = append(responses, rec)
+			if to != "" {
+				concurrent[id] = to
+			}
+		}
+	}
+	sort.SliceStable(responses, func(i, j int) bool {
+		return responses[i].Date().Before(responses[j].Date())
+	})
+
+	har := &HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "github.com/datatogether/warc"},
+		Entries: []HAREntry{},
+	}}
+	for _, res := range responses {
+		req := requests[concurrent[res.Headers.Get(FieldNameWARCRecordID)]]
+		var original *Record
+		if res.Type == RecordTypeRevisit {
+			original = originals[res.Headers.Get(FieldNameWARCPayloadDigest)]
+		}
+		entry, err := NewHAREntry(req, res, original)
+		if err != nil {
+			return nil, errors.Wrapf(err, "record %s", res.Headers.Get(FieldNameWARCRecordID))
+		}
+		har.Log.Entries = append(har.Log.Entries, *entry)
+	}
+	return har, nil
+}
+
+// NewHAREntry creates a HAR entry from a response or revisit record & the
+// request record it's paired with. req may be nil, in which case a GET
+// request is assumed. original is the response a revisit refers to, or nil.
+//
+// Records that can't be parsed don't fail the entry: a GET request is
+// assumed for an unparseable request, and the content of an unparseable
+// response is its whole record block, base64 encoded. Bodies with a
+// Content-Encoding that can't be decoded (eg: br) are kept as stored. Each
+// case is noted in a comment
+func NewHAREntry(req, res, original *Record) (*HAREntry, error) {
+	e := &HAREntry{
+		StartedDateTime: res.Date().UTC().Format(harTimeFormat),
+		ServerIPAddress: res.Headers.Get(FieldNameWARCIPAddress),
+	}
+
+	e.Request = HARRequest{
+		Method:      http.MethodGet,
+		URL:         res.TargetURI(),
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []HARCookie{},
+		Headers:     []HARNameValue{},
+		HeadersSize: -1,
+		BodySize:    0,
+	}
+	if req != nil {
+		if d := req.Date(); !d.IsZero() {
+			e.StartedDateTime = d.UTC().Format(harTimeFormat)
+		}
+		hr, err := req.HTTPRequest()
+		if err != nil {
+			e.Comment = "request record can't be parsed: " + err.Error()
+			e.Request.QueryString = harQueryString(e.Request.URL)
+			setHARResponse(e, res, original)
+			return e, nil
+		}
+		body, err := ioutil.ReadAll(hr.Body)
+		if err != nil {
+			e.Comment = "request body can't be read: " + err.Error()
+		}
+		e.Request.Method = hr.Method
+		e.Request.HTTPVersion = hr.Proto
+		e.Request.Headers, e.Request.HeadersSize = rawHARHeaders(req.Content.Bytes())
+		if len(hr.TransferEncoding) == 0 && e.Request.HeadersSize >= 0 {
+			// requests are often recorded without a Content-Length
+			body = req.Content.Bytes()[e.Request.HeadersSize:]
+		}
+		for _, c := range hr.Cookies() {
+			e.Request.Cookies = append(e.Request.Cookies, HARCookie{Name: c.Name, Value: c.Value})
+		}
+		e.Request.BodySize = int64(len(body))
+		if len(body) > 0 {
+			e.Request.PostData = &HARPostData{MimeType: hr.Header.Get("Content-Type"), Text: string(body)}
+		}
+	}
+	e.Request.QueryString = harQueryString(e.Request.URL)
+	setHARResponse(e, res, original)
+	return e, nil
+}
+
+// setHARResponse sets the response of e from a response or revisit record
+func setHARResponse(e *HAREntry, res, original *Record) {
+	hr, err := res.HTTPResponse()
+	if err != nil {
+		block := res.Content.Bytes()
+		e.Response = HARResponse{
+			Cookies:     []HARCookie{},
+			Headers:     []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    int64(len(block)),
+			Content: HARContent{
+				Size:     int64(len(block)),
+				MimeType: res.Headers.Get(FieldNameContentType),
+				Text:     base64.StdEncoding.EncodeToString(block),
+				Encoding: "base64",
+				Comment:  "response record can't be parsed, content is the record block: " + err.Error(),
+			},
+		}
+		return
+	}
+	defer hr.Body.Close()
+	e.Response = HARResponse{
+		Status:      hr.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(hr.Status, strconv.Itoa(hr.StatusCode))),
+		HTTPVersion: hr.Proto,
+		Cookies:     []HARCookie{},
+		RedirectURL: hr.Header.Get("Location"),
+	}
+	for proto, id := range httpProtocols {
+		// restore the version of messages recorded in HTTP/1.1 form
+		if res.Headers.Get(FieldNameWARCProtocol) == id {
+			e.Request.HTTPVersion = proto
+			e.Response.HTTPVersion = proto
+		}
+	}
+	e.Response.Headers, e.Response.HeadersSize = rawHARHeaders(res.Content.Bytes())
+	if e.Response.HeadersSize >= 0 {
+		e.Response.BodySize = int64(res.Content.Len()) - e.Response.HeadersSize
+	}
+	for _, c := range hr.Cookies() {
+		e.Response.Cookies = append(e.Response.Cookies, HARCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			Expires:  expiresString(c),
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		})
+	}
+
+	body := res
+	if res.Type == RecordTypeRevisit {
+		body = original
+	}
+	e.Response.Content = HARContent{MimeType: hr.Header.Get("Content-Type")}
+	if body == nil {
+		return
+	}
+	data, decodeErr, err := harBody(body)
+	e.Response.Content.Size = int64(len(data))
+	switch {
+	case err != nil:
+		e.Response.Content.Comment = "response body can't be read, content is what could be: " + err.Error()
+	case decodeErr != nil:
+		e.Response.Content.Comment = "response body can't be decoded, content is as stored: " + decodeErr.Error()
+	case isTextMime(e.Response.Content.MimeType) && utf8.Valid(data):
+		e.Response.Content.Text = string(data)
+		return
+	}
+	if len(data) > 0 {
+		e.Response.Content.Text = base64.StdEncoding.EncodeToString(data)
+		e.Response.Content.Encoding = "base64"
+	}
+}
+
+// harBody reads the body of an HTTP response record with its content-encoding
+// removed. If the encoding can't be removed the stored body is returned with
+// the decoding error. Bodies that can't be read in full are returned as far
+// as they can be with err
+func harBody(rec *Record) (data []byte, decodeErr, err error) {
+	hr, err := rec.HTTPResponse()
+	if err != nil {
+		return nil, nil, err
+	}
+	rc, decodeErr := DecodeContentEncoding(hr)
+	if decodeErr == nil {
+		data, decodeErr = ioutil.ReadAll(rc)
+		rc.Close()
+		if decodeErr == nil {
+			return data, nil, nil
+		}
+	}
+	hr.Body.Close()
+	if hr, err = rec.HTTPResponse(); err != nil {
+		return nil, nil, err
+	}
+	defer hr.Body.Close()
+	data, err = ioutil.ReadAll(hr.Body)
+	return data, decodeErr, err
+}
+
+const harTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// rawHARHeaders lists the headers of an HTTP message in their original
+// order & case, returning the size of the message head in bytes
+func rawHARHeaders(msg []byte) ([]HARNameValue, int64) {
+	headers := []HARNameValue{}
+	end := bytes.Index(msg, doubleCrlf)
+	if end < 0 {
+		return headers, -1
+	}
+	s := bufio.NewScanner(bytes.NewReader(msg[:end]))
+	s.Scan() // skip the start line
+	for s.Scan() {
+		line := s.Text()
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(headers) > 0 {
+			headers[len(headers)-1].Value += " " + strings.TrimSpace(line)
+			continue
+		}
+		if i := strings.IndexByte(line, ':'); i > 0 {
+			headers = append(headers, HARNameValue{Name: line[:i], Value: strings.TrimSpace(line[i+1:])})
+		}
+	}
+	return headers, int64(end + len(doubleCrlf))
+}
+
+// harQueryString lists the query parameters of rawurl in order
+func harQueryString(rawurl string) []HARNameValue {
+	params := []HARNameValue{}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return params
+	}
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		p := HARNameValue{Name: kv[0]}
+		if len(kv) == 2 {
+			p.Value = kv[1]
+		}
+		if n, err := url.QueryUnescape(p.Name); err == nil {
+			p.Name = n
+		}
+		if v, err := url.QueryUnescape(p.Value); err == nil {
+			p.Value = v
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+func expiresString(c *http.Cookie) string {
+	if c.Expires.IsZero() {
+		return ""
+	}
+	return c.Expires.UTC().Format(harTimeFormat)
+}
+
+// isTextMime reports whether a content type holds text
+func isTextMime(contentType string) bool {
+	mt := mediaType(contentType)
+	return strings.HasPrefix(mt, "text/") ||
+		strings.HasSuffix(mt, "+xml") || strings.HasSuffix(mt, "+json") ||
+		strings.Contains(mt, "javascript") || strings.Contains(mt, "ecmascript") ||
+		mt == "application/json" || mt == "application/xml" || mt == "application/x-www-form-urlencoded"
+}
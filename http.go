@@ -0,0 +1,88 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPResponse parses the HTTP response message stored in the record block.
+// Any Transfer-Encoding (eg: chunked) is removed from the returned response
+// body, Content-Encoding is left as-is.
+func (r *Record) HTTPResponse() (*http.Response, error) {
+	if r.Content == nil {
+		return nil, errors.New("warc: record has no content")
+	}
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(r.Content.Bytes())), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "warc: reading http response")
+	}
+	return res, nil
+}
+
+// HTTPRequest parses the HTTP request message stored in the record block
+func (r *Record) HTTPRequest() (*http.Request, error) {
+	if r.Content == nil {
+		return nil, errors.New("warc: record has no content")
+	}
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(r.Content.Bytes())))
+	if err != nil {
+		return nil, errors.Wrap(err, "warc: reading http request")
+	}
+	return req, nil
+}
+
+// DecodeContentEncoding wraps the body of res to remove any gzip or deflate
+// Content-Encoding, removing the Content-Encoding and Content-Length headers
+// from res if the body was decoded. The returned reader should be closed
+// instead of res.Body
+func DecodeContentEncoding(res *http.Response) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return res.Body, nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "warc: decoding gzip content")
+		}
+		res.Header.Del("Content-Encoding")
+		res.Header.Del("Content-Length")
+		return readCloser{gz, res.Body}, nil
+	case "deflate":
+		// deflate is meant to be zlib-wrapped, but raw deflate is common
+		br := bufio.NewReader(res.Body)
+		var rd io.ReadCloser
+		if head, err := br.Peek(2); err == nil && head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+			if rd, err = zlib.NewReader(br); err != nil {
+				return nil, errors.Wrap(err, "warc: decoding deflate content")
+			}
+		} else {
+			rd = flate.NewReader(br)
+		}
+		res.Header.Del("Content-Encoding")
+		res.Header.Del("Content-Length")
+		return readCloser{rd, res.Body}, nil
+	default:
+		return nil, errors.Errorf("warc: unsupported Content-Encoding: %s", res.Header.Get("Content-Encoding"))
+	}
+}
+
+// readCloser reads from a decoding reader, closing both the
+// decoder and the underlying body on close
+type readCloser struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+// implements io.Closer
+func (r readCloser) Close() error {
+	r.ReadCloser.Close()
+	return r.body.Close()
+}
@@ -0,0 +1,180 @@
+package warc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Memento (RFC 7089) support for ReplayServer
+
+// mementoURLs builds the absolute urls a replay server uses to refer to
+// resources related to an original url
+type mementoURLs struct {
+	base       string // scheme & host the server is reachable at
+	collection string
+	original   string
+}
+
+func newMementoURLs(r *http.Request, collection, original string) mementoURLs {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return mementoURLs{
+		base:       scheme + "://" + r.Host,
+		collection: collection,
+		original:   original,
+	}
+}
+
+func (m mementoURLs) memento(timestamp string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", m.base, m.collection, timestamp, m.original)
+}
+
+func (m mementoURLs) timegate() string {
+	return fmt.Sprintf("%s/%s/timegate/%s", m.base, m.collection, m.original)
+}
+
+func (m mementoURLs) timemap(format string) string {
+	return fmt.Sprintf("%s/%s/timemap/%s/%s", m.base, m.collection, format, m.original)
+}
+
+// links formats a Link header value for a replayed memento
+func (m mementoURLs) links(cdx *CDXJRecord) string {
+	return strings.Join([]string{
+		fmt.Sprintf(`<%s>; rel="original"`, m.original),
+		fmt.Sprintf(`<%s>; rel="timegate"`, m.timegate()),
+		fmt.Sprintf(`<%s>; rel="timemap"; type="application/link-format"`, m.timemap("link")),
+		fmt.Sprintf(`<%s>; rel="memento"; datetime="%s"`, m.memento(cdx.Timestamp), cdx.Time().Format(http.TimeFormat)),
+	}, ", ")
+}
+
+// serveTimeGate redirects to the memento closest to the Accept-Datetime
+// request header, or the most recent memento if no header is given
+func (s *ReplayServer) serveTimeGate(w http.ResponseWriter, r *http.Request, collection, rawurl string) {
+	coll, ok := s.Collections[collection]
+	if !ok {
+		http.Error(w, fmt.Sprintf("collection not found: %s", collection), http.StatusNotFound)
+		return
+	}
+
+	t := time.Now()
+	if accept := r.Header.Get("Accept-Datetime"); accept != "" {
+		var err error
+		if t, err = http.ParseTime(accept); err != nil {
+			http.Error(w, fmt.Sprintf("invalid Accept-Datetime: '%s'", accept), http.StatusBadRequest)
+			return
+		}
+	}
+
+	rawurl = replayURL(rawurl, r.URL.RawQuery)
+	cdx := coll.Index.Closest(rawurl, t)
+	if cdx == nil {
+		http.Error(w, fmt.Sprintf("no captures found for: %s", rawurl), http.StatusNotFound)
+		return
+	}
+
+	urls := newMementoURLs(r, collection, cdx.URL)
+	w.Header().Set("Vary", "accept-datetime")
+	w.Header().Set("Link", strings.Join([]string{
+		fmt.Sprintf(`<%s>; rel="original"`, urls.original),
+		fmt.Sprintf(`<%s>; rel="timemap"; type="application/link-format"`, urls.timemap("link")),
+	}, ", "))
+	w.Header().Set("Location", urls.memento(cdx.Timestamp))
+	w.WriteHeader(http.StatusFound)
+}
+
+// serveTimeMap lists all mementos of a url. path is the portion of the
+// request path following "timemap/", in the form {format}/{url}
+func (s *ReplayServer) serveTimeMap(w http.ResponseWriter, r *http.Request, collection, path string) {
+	coll, ok := s.Collections[collection]
+	if !ok {
+		http.Error(w, fmt.Sprintf("collection not found: %s", collection), http.StatusNotFound)
+		return
+	}
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) != 2 || (parts[0] != "link" && parts[0] != "json") {
+		http.Error(w, "timemap paths take the form /{collection}/timemap/{link|json}/{url}", http.StatusBadRequest)
+		return
+	}
+
+	rawurl := replayURL(parts[1], r.URL.RawQuery)
+	captures := coll.Index.Captures(rawurl)
+	if len(captures) == 0 {
+		http.Error(w, fmt.Sprintf("no captures found for: %s", rawurl), http.StatusNotFound)
+		return
+	}
+	urls := newMementoURLs(r, collection, captures[0].URL)
+
+	switch parts[0] {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newTimeMapJSON(urls, captures))
+	default:
+		w.Header().Set("Content-Type", "application/link-format")
+		writeTimeMapLinks(w, urls, captures)
+	}
+}
+
+// writeTimeMapLinks writes a TimeMap in application/link-format
+func writeTimeMapLinks(w http.ResponseWriter, urls mementoURLs, captures CDXJIndex) {
+	first, last := captures[0], captures[len(captures)-1]
+	links := []string{
+		fmt.Sprintf(`<%s>; rel="original"`, urls.original),
+		fmt.Sprintf(`<%s>; rel="self"; type="application/link-format"; from="%s"; until="%s"`,
+			urls.timemap("link"), first.Time().Format(http.TimeFormat), last.Time().Format(http.TimeFormat)),
+		fmt.Sprintf(`<%s>; rel="timegate"`, urls.timegate()),
+	}
+	for i, c := range captures {
+		rel := "memento"
+		if len(captures) == 1 {
+			rel = "first last memento"
+		} else if i == 0 {
+			rel = "first memento"
+		} else if i == len(captures)-1 {
+			rel = "last memento"
+		}
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"; datetime="%s"`, urls.memento(c.Timestamp), rel, c.Time().Format(http.TimeFormat)))
+	}
+	fmt.Fprint(w, strings.Join(links, ",\n")+"\n")
+}
+
+// timeMapJSON is the JSON TimeMap format used by the Memento aggregator
+type timeMapJSON struct {
+	OriginalURI string            `json:"original_uri"`
+	TimegateURI string            `json:"timegate_uri"`
+	TimemapURI  map[string]string `json:"timemap_uri"`
+	Mementos    struct {
+		First mementoJSON   `json:"first"`
+		Last  mementoJSON   `json:"last"`
+		List  []mementoJSON `json:"list"`
+	} `json:"mementos"`
+}
+
+type mementoJSON struct {
+	Datetime string `json:"datetime"`
+	URI      string `json:"uri"`
+}
+
+func newTimeMapJSON(urls mementoURLs, captures CDXJIndex) *timeMapJSON {
+	tm := &timeMapJSON{
+		OriginalURI: urls.original,
+		TimegateURI: urls.timegate(),
+		TimemapURI: map[string]string{
+			"link_format": urls.timemap("link"),
+			"json_format": urls.timemap("json"),
+		},
+	}
+	for _, c := range captures {
+		tm.Mementos.List = append(tm.Mementos.List, mementoJSON{
+			Datetime: c.Time().Format(TimeFormat),
+			URI:      urls.memento(c.Timestamp),
+		})
+	}
+	tm.Mementos.First = tm.Mementos.List[0]
+	tm.Mementos.Last = tm.Mementos.List[len(tm.Mementos.List)-1]
+	return tm
+}
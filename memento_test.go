@@ -0,0 +1,126 @@
+package warc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTimeGate(t *testing.T) {
+	s := NewReplayServer(map[string]*Collection{
+		"test": testCollection(t),
+	})
+
+	cases := []struct {
+		path, acceptDatetime string
+		status               int
+		location             string
+	}{
+		{"/test/timegate/http://example.com/", "Mon, 06 Mar 2017 04:02:00 GMT", http.StatusFound, "http://example.org/test/20170306040206/http://example.com/"},
+		{"/test/timegate/http://example.com/", "Mon, 06 Mar 2017 04:03:50 GMT", http.StatusFound, "http://example.org/test/20170306040348/http://example.com/"},
+		{"/test/http://example.com/", "", http.StatusFound, "http://example.org/test/20170306040348/http://example.com/"},
+		{"/test/timegate/http://example.com/", "not a date", http.StatusBadRequest, ""},
+		{"/test/timegate/http://example.com/missing", "", http.StatusNotFound, ""},
+	}
+
+	for i, c := range cases {
+		req := httptest.NewRequest("GET", "http://example.org"+c.path, nil)
+		if c.acceptDatetime != "" {
+			req.Header.Set("Accept-Datetime", c.acceptDatetime)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		res := w.Result()
+		if res.StatusCode != c.status {
+			t.Errorf("case %d status mismatch. expected: %d, got: %d", i, c.status, res.StatusCode)
+			continue
+		}
+		if c.status != http.StatusFound {
+			continue
+		}
+		if got := res.Header.Get("Location"); got != c.location {
+			t.Errorf("case %d location mismatch. expected: %s, got: %s", i, c.location, got)
+		}
+		if res.Header.Get("Vary") != "accept-datetime" {
+			t.Errorf("case %d expected Vary: accept-datetime header", i)
+		}
+		if !strings.Contains(res.Header.Get("Link"), `<http://example.com/>; rel="original"`) {
+			t.Errorf("case %d expected original Link, got: %s", i, res.Header.Get("Link"))
+		}
+	}
+}
+
+func TestTimeMap(t *testing.T) {
+	s := NewReplayServer(map[string]*Collection{
+		"test": testCollection(t),
+	})
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "http://example.org/test/timemap/link/http://example.com/", nil))
+	res := w.Result()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status mismatch. expected: %d, got: %d", http.StatusOK, res.StatusCode)
+	}
+	if res.Header.Get("Content-Type") != "application/link-format" {
+		t.Errorf("content-type mismatch. got: %s", res.Header.Get("Content-Type"))
+	}
+	body := w.Body.String()
+	for _, expect := range []string{
+		`<http://example.com/>; rel="original"`,
+		`<http://example.org/test/timemap/link/http://example.com/>; rel="self"; type="application/link-format"; from="Mon, 06 Mar 2017 04:02:06 GMT"; until="Mon, 06 Mar 2017 04:03:48 GMT"`,
+		`<http://example.org/test/timegate/http://example.com/>; rel="timegate"`,
+		`<http://example.org/test/20170306040206/http://example.com/>; rel="first memento"; datetime="Mon, 06 Mar 2017 04:02:06 GMT"`,
+		`<http://example.org/test/20170306040348/http://example.com/>; rel="last memento"; datetime="Mon, 06 Mar 2017 04:03:48 GMT"`,
+	} {
+		if !strings.Contains(body, expect) {
+			t.Errorf("expected link-format timemap to contain: %s\ngot:\n%s", expect, body)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "http://example.org/test/timemap/json/http://example.com/", nil))
+	tm := &timeMapJSON{}
+	if err := json.NewDecoder(w.Body).Decode(tm); err != nil {
+		t.Fatal(err)
+	}
+	if len(tm.Mementos.List) != 2 {
+		t.Fatalf("expected 2 mementos, got: %d", len(tm.Mementos.List))
+	}
+	if tm.Mementos.First.Datetime != "2017-03-06T04:02:06Z" {
+		t.Errorf("first memento datetime mismatch. got: %s", tm.Mementos.First.Datetime)
+	}
+	if tm.TimemapURI["json_format"] != "http://example.org/test/timemap/json/http://example.com/" {
+		t.Errorf("json timemap uri mismatch. got: %s", tm.TimemapURI["json_format"])
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "http://example.org/test/timemap/xml/http://example.com/", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected bad request for unknown timemap format, got: %d", w.Code)
+	}
+}
+
+func TestMementoHeaders(t *testing.T) {
+	s := NewReplayServer(map[string]*Collection{
+		"test": testCollection(t),
+	})
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "http://example.org/test/2018/http://example.com/", nil))
+	res := w.Result()
+	if got := res.Header.Get("Memento-Datetime"); got != "Mon, 06 Mar 2017 04:03:48 GMT" {
+		t.Errorf("Memento-Datetime mismatch. got: %s", got)
+	}
+	link := res.Header.Get("Link")
+	for _, expect := range []string{
+		`<http://example.com/>; rel="original"`,
+		`<http://example.org/test/timegate/http://example.com/>; rel="timegate"`,
+		`<http://example.org/test/timemap/link/http://example.com/>; rel="timemap"; type="application/link-format"`,
+		`<http://example.org/test/20170306040348/http://example.com/>; rel="memento"; datetime="Mon, 06 Mar 2017 04:03:48 GMT"`,
+	} {
+		if !strings.Contains(link, expect) {
+			t.Errorf("expected Link header to contain: %s\ngot: %s", expect, link)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultMaxCaptureBody is the default limit on the bytes of a body
+// recorded by a CaptureHandler
+const DefaultMaxCaptureBody = 10 << 20
+
+// CaptureHandler is middleware that records the requests an http.Handler
+// serves & the responses it writes as request/response record pairs, for
+// archiving a service's own responses:
+//
+//	http.ListenAndServe(":8080", warc.NewCaptureHandler(w, mux))
+//
+// Bodies are recorded up to MaxBodySize bytes. Records of longer bodies are
+// truncated and marked with a WARC-Truncated field of "length". Only the
+// part of a request body the handler reads is recorded, records of request
+// bodies it doesn't read in full are marked "length" too. Connections
+// hijacked by the handler aren't recorded. Records'
+// WARC-IP-Address is the address the request was served on, client
+// addresses aren't recorded.
+//
+// A CaptureHandler is safe for concurrent use
+type CaptureHandler struct {
+	// Handler serves requests
+	Handler http.Handler
+	// WarcinfoID is set as the WARC-Warcinfo-ID of written records if not
+	// empty
+	WarcinfoID string
+	// MaxBodySize limits the bytes of each body recorded,
+	// DefaultMaxCaptureBody if 0
+	MaxBodySize int64
+	// ErrorLog logs errors writing records. If nil, the log package's
+	// standard logger is used
+	ErrorLog *log.Logger
+
+	mu sync.Mutex
+	w  *Writer
+}
+
+// NewCaptureHandler creates a CaptureHandler recording the traffic of h to w
+func NewCaptureHandler(w *Writer, h http.Handler) *CaptureHandler {
+	return &CaptureHandler{Handler: h, w: w}
+}
+
+// ServeHTTP implements http.Handler
+func (c *CaptureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	max := c.MaxBodySize
+	if max == 0 {
+		max = DefaultMaxCaptureBody
+	}
+
+	reqBody := &limitedBuffer{max: max}
+	var body *requestBody
+	if r.Body != nil && r.Body != http.NoBody {
+		body = &requestBody{ReadCloser: r.Body, buf: reqBody}
+		r.Body = body
+	}
+	cw := &captureResponseWriter{ResponseWriter: w, body: &limitedBuffer{max: max}}
+	c.Handler.ServeHTTP(cw, r)
+	if cw.hijacked {
+		return
+	}
+	if body != nil && !body.eof && body.n != r.ContentLength {
+		// the handler didn't read the whole body
+		reqBody.truncated = true
+	}
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if err := c.record(start, r, reqBody, cw); err != nil {
+		logf := log.Printf
+		if c.ErrorLog != nil {
+			logf = c.ErrorLog.Printf
+		}
+		logf("warc: recording %s: %s", r.URL, err)
+	}
+}
+
+// record writes the request & response record pair for an exchange
+func (c *CaptureHandler) record(start time.Time, r *http.Request, reqBody *limitedBuffer, cw *captureResponseWriter) error {
+	req := r.Clone(r.Context())
+	req.URL.Host = r.Host
+	req.URL.Scheme = "http"
+	if r.TLS != nil {
+		req.URL.Scheme = "https"
+	}
+	req.Body = ioutil.NopCloser(&reqBody.buf)
+	if req.ContentLength > 0 {
+		req.ContentLength = int64(reqBody.buf.Len())
+	}
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// stop Request.Write adding a default user agent
+		req.Header["User-Agent"] = []string{""}
+	}
+	info := CaptureHelper{
+		WarcinfoID:         c.WarcinfoID,
+		ReqBodyBytesBuffer: &reqBody.buf,
+	}
+	// WARC-IP-Address is the server's address, not the client's
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		info.RemoteAddr = addr.String()
+	}
+	if _, ok := cw.header["Content-Type"]; !ok && cw.body.buf.Len() > 0 {
+		// as set by net/http when writing the response
+		cw.header.Set("Content-Type", http.DetectContentType(cw.body.buf.Bytes()))
+	}
+	res := &http.Response{
+		Status:     fmt.Sprintf("%d %s", cw.status, http.StatusText(cw.status)),
+		StatusCode: cw.status,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     cw.header,
+		Body:       ioutil.NopCloser(&cw.body.buf),
+	}
+
+	reqRec, resRec, err := NewRequestResponseRecords(info, req, res)
+	if err != nil {
+		return err
+	}
+	date := start.UTC().Format(TimeFormat)
+	reqRec.Headers.Set(FieldNameWARCDate, date)
+	resRec.Headers.Set(FieldNameWARCDate, date)
+	if reqBody.truncated {
+		reqRec.Headers.Set(FieldNameWARCTruncated, "length")
+	}
+	if cw.body.truncated {
+		resRec.Headers.Set(FieldNameWARCTruncated, "length")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rec := range []*Record{&reqRec, &resRec} {
+		if _, _, err := c.w.WriteRecord(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// captureResponseWriter records the status, headers & body written to a
+// ResponseWriter
+type captureResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	hijacked    bool
+	status      int
+	header      http.Header
+	body        *limitedBuffer
+}
+
+func (w *captureResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	w.header = w.ResponseWriter.Header().Clone()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *captureResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.body.Write(p[:n])
+	return n, err
+}
+
+// Flush implements http.Flusher
+func (w *captureResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (w *captureResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := hj.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap gives the underlying ResponseWriter, for http.ResponseController
+func (w *captureResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// limitedBuffer keeps up to max bytes written to it, discarding the rest
+// requestBody copies the bytes of a request body read by a handler to buf
+type requestBody struct {
+	io.ReadCloser
+	buf *limitedBuffer
+	n   int64
+	eof bool
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	b.n += int64(n)
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int64
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - int64(b.buf.Len()); int64(len(p)) > room {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
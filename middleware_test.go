@@ -0,0 +1,180 @@
+package warc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCaptureHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s", r.Method, body)
+	})
+	mux.HandleFunc("/ignore", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>ignored</html>"))
+	})
+	mux.HandleFunc("/partial", func(w http.ResponseWriter, r *http.Request) {
+		body := make([]byte, 6)
+		io.ReadFull(r.Body, body)
+		w.Write(body)
+	})
+	mux.HandleFunc("/exact", func(w http.ResponseWriter, r *http.Request) {
+		// reads the Content-Length without seeing EOF
+		body := make([]byte, r.ContentLength)
+		io.ReadFull(r.Body, body)
+		w.Write(body)
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 10; i++ {
+			w.Write([]byte(strings.Repeat("x", 10)))
+		}
+	})
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := NewCaptureHandler(w, mux)
+	ch.MaxBodySize = 50
+	ch.WarcinfoID = NewUUID()
+	srv := httptest.NewServer(ch)
+	defer srv.Close()
+
+	cases := []struct {
+		method, path, body string
+		status             int
+		resBody            string
+		recReqBody         string
+		reqTrunc, resTrunc string
+	}{
+		{"POST", "/echo", "a=1", 201, "POST a=1", "a=1", "", ""},
+		{"PUT", "/ignore", "unread body", 200, "<html>ignored</html>", "", "length", ""},
+		{"PUT", "/partial", "partly read", 200, "partly", "partly", "length", ""},
+		{"GET", "/missing", "", 404, "404 page not found\n", "", "", ""},
+		{"POST", "/big", strings.Repeat("y", 60), 200, strings.Repeat("x", 100), "", "length", "length"},
+		{"POST", "/exact", "abc", 200, "abc", "abc", "", ""},
+	}
+	for i, c := range cases {
+		req, err := http.NewRequest(c.method, srv.URL+c.path, strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != c.status || string(body) != c.resBody {
+			t.Errorf("case %d response mismatch. expected: %d %q, got: %d %q", i, c.status, c.resBody, res.StatusCode, body)
+		}
+	}
+
+	records := readTestRecordsFrom(t, buf)
+	if len(records) != len(cases)*2 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", len(cases)*2, len(records))
+	}
+	for i, c := range cases {
+		req, res := records[i*2], records[i*2+1]
+		if res.Headers.Get(FieldNameWARCConcurrentTo) != req.Headers.Get(FieldNameWARCRecordID) {
+			t.Errorf("case %d expected response to be concurrent to request", i)
+		}
+		if req.TargetURI() != srv.URL+c.path {
+			t.Errorf("case %d target uri mismatch. expected: %s, got: %s", i, srv.URL+c.path, req.TargetURI())
+		}
+		if req.Headers.Get(FieldNameWARCIPAddress) != "127.0.0.1" || req.Headers.Get(FieldNameWARCWarcinfoID) != ch.WarcinfoID {
+			t.Errorf("case %d expected ip address & warcinfo id", i)
+		}
+		if req.Headers.Get(FieldNameWARCTruncated) != c.reqTrunc || res.Headers.Get(FieldNameWARCTruncated) != c.resTrunc {
+			t.Errorf("case %d truncation mismatch. expected: %q/%q, got: %q/%q", i, c.reqTrunc, c.resTrunc, req.Headers.Get(FieldNameWARCTruncated), res.Headers.Get(FieldNameWARCTruncated))
+		}
+
+		hreq, err := req.HTTPRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(hreq.Body)
+		if hreq.Method != c.method || string(body) != c.recReqBody {
+			t.Errorf("case %d recorded request mismatch. expected: %s %q, got: %s %q", i, c.method, c.recReqBody, hreq.Method, body)
+		}
+		if hreq.Header.Get("User-Agent") != "Go-http-client/1.1" {
+			t.Errorf("case %d expected client user agent, got: %q", i, hreq.Header.Get("User-Agent"))
+		}
+
+		hres, err := res.HTTPResponse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ = ioutil.ReadAll(hres.Body)
+		expect := c.resBody
+		if c.resTrunc != "" {
+			expect = expect[:50]
+		}
+		if hres.StatusCode != c.status || string(body) != expect {
+			t.Errorf("case %d recorded response mismatch. expected: %d %q, got: %d %q", i, c.status, expect, hres.StatusCode, body)
+		}
+	}
+	if ct := records[3].Content.String(); !strings.Contains(ct, "Content-Type: text/html; charset=utf-8") {
+		t.Errorf("expected sniffed content type, got: %q", ct)
+	}
+}
+
+func TestCaptureHandlerIPAddress(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := NewCaptureHandler(w, h)
+
+	// served by a listener
+	srv := httptest.NewServer(ch)
+	defer srv.Close()
+	res, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	srvIP, _, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a client address distinct from the local address
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+	req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 80}))
+	ch.ServeHTTP(httptest.NewRecorder(), req)
+
+	// no local address
+	req = httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+	ch.ServeHTTP(httptest.NewRecorder(), req)
+
+	records := readTestRecordsFrom(t, buf)
+	if len(records) != 6 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", 6, len(records))
+	}
+	for i, expect := range []string{srvIP, "198.51.100.1", ""} {
+		for _, rec := range records[i*2 : i*2+2] {
+			if got := rec.Headers.Get(FieldNameWARCIPAddress); got != expect {
+				t.Errorf("case %d %s ip address mismatch. expected: %q, got: %q", i, rec.Type, expect, got)
+			}
+		}
+	}
+}
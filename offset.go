@@ -0,0 +1,278 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// OffsetReader reads records from a WARC file, reporting the position of each
+// record within the file. Positions are what CDX indexes store to support
+// random access, see ReadRecordAt.
+//
+// For gzipped files each record is expected to be in its own gzip member
+// ("per-record" compression). Records that share a member with other records
+// (eg: whole-file gzip) can't be addressed independently, and are reported
+// with an endPos of -1. For bzip2 files positions refer to the uncompressed
+// stream.
+//
+// Create a new OffsetReader with NewOffsetReader
+type OffsetReader struct {
+	cr    *countReader
+	br    *bufio.Reader // buffered reader over the raw file
+	compr int
+	gz    *gzip.Reader
+	rr    *bufio.Reader // reader for records, within the current gzip member if any
+
+	memberStart   int64
+	memberRecords int
+	// terminated is true if the last record read was followed by 2xCRLF
+	terminated bool
+}
+
+// NewOffsetReader creates a new OffsetReader from an io.Reader, which should
+// be positioned at the start of a WARC file
+func NewOffsetReader(r io.Reader) (*OffsetReader, error) {
+	cr := &countReader{r: r}
+	br := bufio.NewReader(cr)
+	compr, err := guessCompression(br)
+	if err != nil {
+		return nil, err
+	}
+
+	rdr := &OffsetReader{
+		cr:    cr,
+		br:    br,
+		compr: compr,
+	}
+	switch compr {
+	case compressionNone:
+		rdr.rr = br
+	case compressionBZIP:
+		rdr.cr = &countReader{r: bzip2.NewReader(br)}
+		rdr.rr = bufio.NewReader(rdr.cr)
+	}
+	return rdr, nil
+}
+
+// Read a record, returning the position of the first byte of the record and the
+// position immediately following it. will return nil, io.EOF to signal no more
+// records
+func (r *OffsetReader) Read() (rec *Record, startPos, endPos int64, err error) {
+	if r.compr != compressionGZIP {
+		if err = skipBlankLines(r.rr); err != nil {
+			return nil, 0, 0, err
+		}
+		startPos = r.pos(r.rr)
+		if rec, err = readRecordFrom(r.rr); err != nil {
+			return nil, 0, 0, err
+		}
+		r.terminated = readRecordEnd(r.rr)
+		return rec, startPos, r.pos(r.rr), nil
+	}
+
+	for {
+		if r.rr == nil {
+			if err = r.nextMember(); err != nil {
+				return nil, 0, 0, err
+			}
+		}
+		if err = skipBlankLines(r.rr); err == io.EOF {
+			r.rr = nil
+			continue
+		} else if err != nil {
+			return nil, 0, 0, err
+		}
+		if rec, err = readRecordFrom(r.rr); err != nil {
+			return nil, 0, 0, err
+		}
+		r.terminated = readRecordEnd(r.rr)
+		r.memberRecords++
+		startPos = r.memberStart
+		endPos = -1
+
+		// check for the end of the gzip member
+		if _, perr := r.rr.Peek(1); perr == io.EOF {
+			if r.memberRecords == 1 {
+				endPos = r.pos(r.br)
+			}
+			r.rr = nil
+		} else if perr != nil {
+			return nil, 0, 0, perr
+		}
+		return rec, startPos, endPos, nil
+	}
+}
+
+// nextMember advances the reader to the next gzip member in the file
+func (r *OffsetReader) nextMember() (err error) {
+	r.memberStart = r.pos(r.br)
+	r.memberRecords = 0
+	if r.gz == nil {
+		r.gz, err = gzip.NewReader(r.br)
+	} else {
+		err = r.gz.Reset(r.br)
+	}
+	if err != nil {
+		return err
+	}
+	r.gz.Multistream(false)
+	r.rr = bufio.NewReader(r.gz)
+	return nil
+}
+
+// pos gives the number of bytes consumed from the underlying stream
+// that br reads from
+func (r *OffsetReader) pos(br *bufio.Reader) int64 {
+	return r.cr.n - int64(br.Buffered())
+}
+
+// ReadRecordAt reads a single record from a WARC file at offset. length is the
+// number of bytes the record occupies in the file, if length is 0 the record
+// will be read from offset onward. Compressed records must be gzipped
+// per-record.
+func ReadRecordAt(r io.ReaderAt, offset, length int64) (*Record, error) {
+	if length <= 0 {
+		length = 1<<63 - 1 - offset
+	}
+	br := bufio.NewReader(io.NewSectionReader(r, offset, length))
+	compr, err := guessCompression(br)
+	if err != nil {
+		return nil, err
+	}
+
+	switch compr {
+	case compressionGZIP:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		gz.Multistream(false)
+		defer gz.Close()
+		return readRecordFrom(bufio.NewReader(gz))
+	case compressionBZIP:
+		return nil, errors.New("warc: random access is not supported for bzip2 files")
+	default:
+		if err := skipBlankLines(br); err != nil {
+			return nil, err
+		}
+		return readRecordFrom(br)
+	}
+}
+
+// readRecordFrom reads exactly one record from br, using the Content-Length
+// header to determine the size of the record block. The 2xCRLF following the
+// block is not consumed, see readRecordEnd
+func readRecordFrom(br *bufio.Reader) (*Record, error) {
+	line, err := readLine(br)
+	if err != nil {
+		return nil, err
+	}
+	rec := &Record{
+		Format:  recordFormat(line),
+		Headers: Header{},
+	}
+	if rec.Format == RecordFormatUnknown {
+		return nil, errors.Errorf("Unknown record format: '%s'", line)
+	}
+
+	var key string
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if line == "" {
+			break
+		}
+		if (line[0] == ' ' || line[0] == '\t') && key != "" {
+			// folded header value
+			rec.Headers[key] += " " + strings.TrimSpace(line)
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			return nil, errors.Errorf("warc: malformed header line: '%s'", line)
+		}
+		key = CanonicalKey(line[:i])
+		rec.Headers[key] = strings.TrimSpace(line[i+1:])
+	}
+	rec.Type = ParseRecordType(rec.Headers[FieldNameWARCType])
+
+	length, err := strconv.ParseInt(rec.Headers[FieldNameContentLength], 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "warc: Invalid Content-Length")
+	}
+	rec.Content = bytes.NewBuffer(make([]byte, 0, int(length)))
+	if _, err := io.CopyN(rec.Content, br, length); err != nil {
+		return nil, errors.Wrap(unexpectedEOF(err), "warc: reading record content")
+	}
+
+	return rec, nil
+}
+
+// readRecordEnd consumes the 2xCRLF that should follow a record block,
+// reporting whether it was found. Any CR or LF bytes up to the length of
+// 2xCRLF are consumed.
+func readRecordEnd(br *bufio.Reader) bool {
+	end := make([]byte, 0, len(doubleCrlf))
+	for i := 0; i < len(doubleCrlf); i++ {
+		b, err := br.Peek(1)
+		if err != nil || (b[0] != '\r' && b[0] != '\n') {
+			break
+		}
+		br.ReadByte()
+		end = append(end, b[0])
+	}
+	return bytes.Equal(end, doubleCrlf)
+}
+
+// readLine reads a single line from br, with any trailing CRLF removed
+func readLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// skipBlankLines discards any CR or LF bytes at the head of br, returning
+// io.EOF if no data remains
+func skipBlankLines(br *bufio.Reader) error {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return err
+		}
+		if b[0] != '\r' && b[0] != '\n' {
+			return nil
+		}
+		br.ReadByte()
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// countReader counts the number of bytes read from r
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+// implements io.Reader
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,52 @@
+package warc
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestOffsetReader(t *testing.T) {
+	for _, path := range []string{"testdata/warcio/example.warc", "testdata/warcio/example.warc.gz"} {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		rdr, err := NewOffsetReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		for {
+			rec, start, end, err := rdr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s record %d: %s", path, count, err)
+			}
+			count++
+			if end <= start {
+				t.Errorf("%s record %d: invalid positions %d - %d", path, count, start, end)
+				continue
+			}
+
+			got, err := ReadRecordAt(f, start, end-start)
+			if err != nil {
+				t.Errorf("%s record %d: ReadRecordAt error: %s", path, count, err)
+				continue
+			}
+			if got.ID() != rec.ID() {
+				t.Errorf("%s record %d: id mismatch. expected: %s, got: %s", path, count, rec.ID(), got.ID())
+			}
+			if got.Content.String() != rec.Content.String() {
+				t.Errorf("%s record %d: content mismatch", path, count)
+			}
+		}
+		if count != 6 {
+			t.Errorf("%s: wrong number of records. expected: %d, got: %d", path, 6, count)
+		}
+	}
+}
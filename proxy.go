@@ -0,0 +1,331 @@
+package warc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CA is a certificate authority that issues certificates for any host,
+// letting Proxy intercept HTTPS traffic. Clients of the proxy must trust
+// the CA's certificate.
+//
+// Create a CA with NewCA or LoadCA
+type CA struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+
+	mu     sync.Mutex
+	leaves map[string]*tls.Certificate
+}
+
+// NewCA generates a self-signed CA certificate & key
+func NewCA(name string) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "warc: generating ca key")
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name, Organization: []string{name}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, errors.Wrap(err, "warc: creating ca certificate")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Cert: cert, Key: key}, nil
+}
+
+// LoadCA reads a CA from PEM encoded certificate & private key data, as
+// written by CertPEM and KeyPEM
+func LoadCA(certPEM, keyPEM []byte) (*CA, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, errors.Wrap(err, "warc: loading ca")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "warc: loading ca")
+	}
+	if !cert.IsCA {
+		return nil, errors.New("warc: loading ca: certificate is not a ca")
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("warc: loading ca: unsupported private key")
+	}
+	return &CA{Cert: cert, Key: key}, nil
+}
+
+// CertPEM encodes the CA certificate as PEM, for installing in browsers
+func (ca *CA) CertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Cert.Raw})
+}
+
+// KeyPEM encodes the CA private key as PEM
+func (ca *CA) KeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(ca.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, "warc: encoding ca key")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// Certificate gives a certificate for host signed by the CA. Certificates
+// are cached, so each host is issued one certificate
+func (ca *CA) Certificate(host string) (*tls.Certificate, error) {
+	host = strings.ToLower(host)
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if cert, ok := ca.leaves[host]; ok {
+		return cert, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "warc: generating certificate key")
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, key.Public(), ca.Key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "warc: creating certificate for %s", host)
+	}
+
+	cert := &tls.Certificate{Certificate: [][]byte{der, ca.Cert.Raw}, PrivateKey: key}
+	if ca.leaves == nil {
+		ca.leaves = map[string]*tls.Certificate{}
+	}
+	ca.leaves[host] = cert
+	return cert, nil
+}
+
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "warc: generating serial number")
+	}
+	return serial, nil
+}
+
+// Proxy is an HTTP forward proxy that records the traffic passing through
+// it as request/response record pairs. With a CA, HTTPS requests tunneled
+// with CONNECT are intercepted & recorded, without one tunnels are passed
+// through unrecorded.
+//
+// A Proxy is an http.Handler, serve it with an http.Server:
+//
+//	ca, _ := warc.NewCA("warc proxy")
+//	http.ListenAndServe("localhost:8080", warc.NewProxy(w, ca))
+type Proxy struct {
+	// CA issues certificates for intercepted hosts
+	CA *CA
+	// Transport makes upstream requests. If nil, a transport that doesn't
+	// use a proxy or add an Accept-Encoding header is used
+	Transport http.RoundTripper
+	// WarcinfoID is set as the WARC-Warcinfo-ID of written records if not
+	// empty
+	WarcinfoID string
+	// RecordTLS writes a metadata record of the upstream TLS session details
+	// of each HTTPS response, see NewTLSMetadataRecord
+	RecordTLS bool
+	// MaxBodySize limits the bytes of each response body recorded,
+	// DefaultMaxCaptureBody if 0. Responses are passed to clients in full
+	MaxBodySize int64
+
+	w        *Writer
+	initOnce sync.Once
+	rt       *RecordingTransport
+}
+
+// NewProxy creates a Proxy writing to w
+func NewProxy(w *Writer, ca *CA) *Proxy {
+	return &Proxy{CA: ca, w: w}
+}
+
+func (p *Proxy) init() {
+	p.initOnce.Do(func() {
+		rt := p.Transport
+		if rt == nil {
+			t := http.DefaultTransport.(*http.Transport).Clone()
+			t.Proxy = nil
+			t.DisableCompression = true
+			rt = t
+		}
+		p.rt = NewRecordingTransport(p.w, rt)
+		p.rt.WarcinfoID = p.WarcinfoID
+		p.rt.RecordTLS = p.RecordTLS
+		p.rt.MaxBodySize = p.MaxBodySize
+	})
+}
+
+// ServeHTTP implements http.Handler
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.init()
+	if r.Method == http.MethodConnect {
+		p.connect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "this is a proxy, requests must use an absolute url", http.StatusBadRequest)
+		return
+	}
+	p.forward(w, r)
+}
+
+// forward makes an upstream request for r, copying the response to w
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request) {
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	if r.ContentLength == 0 {
+		out.Body = nil
+	}
+	for _, h := range strings.Split(r.Header.Get("Connection"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			out.Header.Del(h)
+		}
+	}
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+
+	res, err := p.rt.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	for _, h := range hopHeaders {
+		res.Header.Del(h)
+	}
+	for k, v := range res.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(res.StatusCode)
+	io.Copy(w, res.Body)
+}
+
+// connect handles a CONNECT request, intercepting the tunneled connection
+// if the proxy has a CA
+func (p *Proxy) connect(w http.ResponseWriter, r *http.Request) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection can't be hijacked", http.StatusInternalServerError)
+		return
+	}
+
+	var upstream net.Conn
+	if p.CA == nil {
+		var err error
+		if upstream, err = net.Dial("tcp", r.Host); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		if upstream != nil {
+			upstream.Close()
+		}
+		return
+	}
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		conn.Close()
+		return
+	}
+
+	if upstream != nil {
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+		return
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	tlsConn := tls.Server(conn, &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "" {
+				return p.CA.Certificate(hello.ServerName)
+			}
+			return p.CA.Certificate(host)
+		},
+	})
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			req.URL.Scheme = "https"
+			req.URL.Host = req.Host
+			if req.URL.Host == "" {
+				req.URL.Host = r.Host
+			}
+			p.forward(w, req)
+		}),
+	}
+	srv.Serve(&connListener{conn: tlsConn})
+}
+
+// connListener is a net.Listener that accepts a single connection
+type connListener struct {
+	once sync.Once
+	conn net.Conn
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.once.Do(func() { conn = l.conn })
+	if conn == nil {
+		return nil, io.EOF
+	}
+	return conn, nil
+}
+
+func (l *connListener) Close() error   { return nil }
+func (l *connListener) Addr() net.Addr { return l.conn.LocalAddr() }
@@ -0,0 +1,138 @@
+package warc
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCA(t *testing.T) {
+	ca, err := NewCA("test ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ca.KeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCA(ca.CertPEM(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(loaded.Cert)
+
+	for i, host := range []string{"example.com", "127.0.0.1", "::1"} {
+		cert, err := loaded.Certificate(host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+			t.Errorf("case %d verify error: %s", i, err)
+		}
+		if again, _ := loaded.Certificate(host); again != cert {
+			t.Errorf("case %d expected cached certificate", i)
+		}
+	}
+
+	if _, err := LoadCA([]byte("nope"), key); err == nil {
+		t.Error("expected error loading invalid ca")
+	}
+}
+
+func TestProxy(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+	})
+	httpSrv := httptest.NewServer(handler)
+	defer httpSrv.Close()
+	tlsSrv := httptest.NewTLSServer(handler)
+	defer tlsSrv.Close()
+
+	ca, err := NewCA("test ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := NewProxy(w, ca)
+	// trust the test server's certificate upstream
+	upstream := tlsSrv.Client().Transport.(*http.Transport).Clone()
+	upstream.DisableCompression = true
+	proxy.Transport = upstream
+	proxySrv := httptest.NewServer(proxy)
+	defer proxySrv.Close()
+
+	proxyURL, _ := url.Parse(proxySrv.URL)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}}
+
+	cases := []struct {
+		method, url, body string
+		expect            string
+	}{
+		{"GET", httpSrv.URL + "/plain", "", "GET /plain "},
+		{"GET", tlsSrv.URL + "/secure", "", "GET /secure "},
+		{"POST", tlsSrv.URL + "/form", "a=1", "POST /form a=1"},
+		{"GET", tlsSrv.URL + "/again", "", "GET /again "},
+	}
+	for i, c := range cases {
+		req, err := http.NewRequest(c.method, c.url, strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("case %d request error: %s", i, err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if string(body) != c.expect {
+			t.Errorf("case %d body mismatch. expected: %q, got: %q", i, c.expect, body)
+		}
+	}
+	proxySrv.Close()
+
+	records := readTestRecordsFrom(t, buf)
+	if len(records) != len(cases)*2 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", len(cases)*2, len(records))
+	}
+	for i, c := range cases {
+		req, res := records[i*2], records[i*2+1]
+		if req.Type != RecordTypeRequest || res.Type != RecordTypeResponse {
+			t.Errorf("case %d type mismatch. got: %s, %s", i, req.Type, res.Type)
+		}
+		if req.TargetURI() != c.url || res.TargetURI() != c.url {
+			t.Errorf("case %d target uri mismatch. expected: %s, got: %s", i, c.url, res.TargetURI())
+		}
+		if res.Headers.Get(FieldNameWARCIPAddress) != "127.0.0.1" {
+			t.Errorf("case %d ip address mismatch. got: %s", i, res.Headers.Get(FieldNameWARCIPAddress))
+		}
+		if bytes.Contains(req.Content.Bytes(), []byte("Proxy-Connection")) {
+			t.Errorf("case %d expected hop-by-hop headers to be removed, got: %q", i, req.Content.String())
+		}
+		if res.Headers.Get(FieldNameWARCPayloadDigest) != Sha1Digest([]byte(c.expect)) {
+			t.Errorf("case %d response payload digest mismatch", i)
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RawCapture records HTTP/1.x exchanges at the connection level, teeing the
+// bytes sent & received on the connections it dials. Unlike
+// NewRequestResponseRecords, which rebuilds messages from a parsed
+// http.Response, records hold exactly what was on the wire: original header
+// casing, order & duplicates, and chunked transfer framing. Payload digests
+// are of the bodies with transfer framing removed.
+//
+// Use the dial functions with an http.Transport:
+//
+//	rc := warc.NewRawCapture(w)
+//	client := &http.Client{Transport: &http.Transport{
+//		DialContext:    rc.DialContext(nil),
+//		DialTLSContext: rc.DialTLSContext(nil, nil),
+//	}}
+//
+// Records are written as each response is read. Connections closed part
+// way through a response are recorded with a WARC-Truncated field of
+// "disconnect". Message bodies longer than MaxBodySize are recorded up to
+// the limit with a WARC-Truncated field of "length", and responses taking
+// longer than MaxDuration to read are recorded as far as they were read
+// with a WARC-Truncated field of "time". Recording of a connection stops at
+// a response cut short by time.
+//
+// Connections upgraded to WebSockets continue to be recorded. Each message
+// is written as a resource record with a content type of
+// WebSocketMessageContentType, and each control frame (close, ping & pong)
+// as an application/warc-fields metadata record. Both are linked to the
+// handshake response record with WARC-Concurrent-To, dated with the
+// microsecond the message began to be read, which needs them to be WARC/1.1
+// records. Messages compressed with the permessage-deflate extension are
+// recorded decompressed. Messages over MaxWebSocketMessage bytes are
+// recorded up to the limit with a WARC-Truncated field of "length".
+//
+// A RawCapture is safe for concurrent use
+type RawCapture struct {
+	// WarcinfoID is set as the WARC-Warcinfo-ID of written records if not
+	// empty
+	WarcinfoID string
+	// ErrorLog logs errors parsing connections & writing records. If nil,
+	// the log package's standard logger is used
+	ErrorLog *log.Logger
+	// MaxBodySize limits the bytes of each message body recorded, no limit
+	// if 0. Bytes past the limit aren't kept, but are still passed on.
+	// Records truncated by length have no WARC-Payload-Digest
+	MaxBodySize int64
+	// MaxDuration limits the time spent recording each response, no limit
+	// if 0
+	MaxDuration time.Duration
+
+	w  *Writer
+	mu sync.Mutex
+	wg sync.WaitGroup
+}
+
+// NewRawCapture creates a RawCapture writing to w
+func NewRawCapture(w *Writer) *RawCapture {
+	return &RawCapture{w: w}
+}
+
+// DialContext returns a function for http.Transport.DialContext that records
+// the exchanges on the connections it makes
+func (rc *RawCapture) DialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return rc.capture(conn, conn.RemoteAddr(), "http"), nil
+	}
+}
+
+// DialTLSContext returns a function for http.Transport.DialTLSContext that
+// makes TLS connections using config & records the exchanges on them. Only
+// HTTP/1.1 is offered to servers
+func (rc *RawCapture) DialTLSContext(dialer *net.Dialer, config *tls.Config) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg := &tls.Config{}
+		if config != nil {
+			cfg = config.Clone()
+		}
+		cfg.NextProtos = []string{"http/1.1"}
+		if cfg.ServerName == "" {
+			if cfg.ServerName, _, err = net.SplitHostPort(addr); err != nil {
+				cfg.ServerName = addr
+			}
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return rc.capture(tlsConn, conn.RemoteAddr(), "https"), nil
+	}
+}
+
+// Wait blocks until the exchanges on all connections have been recorded.
+// Connections are recorded until closed, so close idle connections first:
+//
+//	transport.CloseIdleConnections()
+//	rc.Wait()
+func (rc *RawCapture) Wait() {
+	rc.wg.Wait()
+}
+
+// capture wraps conn, recording its exchanges in the background
+func (rc *RawCapture) capture(conn net.Conn, remote net.Addr, scheme string) net.Conn {
+	c := &rawConn{Conn: conn, sent: newConnStream(), received: newConnStream()}
+	ip := ""
+	if addr, ok := remote.(*net.TCPAddr); ok {
+		ip = addr.IP.String()
+	}
+	rc.wg.Add(1)
+	go func() {
+		defer rc.wg.Done()
+		defer c.sent.discard()
+		defer c.received.discard()
+		if err := rc.record(c, ip, scheme); err != nil {
+			rc.logf("warc: recording connection to %s: %s", remote, err)
+		}
+	}()
+	return c
+}
+
+func (rc *RawCapture) logf(format string, args ...interface{}) {
+	if rc.ErrorLog != nil {
+		rc.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// record parses the exchanges on a connection, writing a request &
+// response record pair for each
+func (rc *RawCapture) record(c *rawConn, ip, scheme string) error {
+	sent := &rawStream{r: c.sent, max: rc.MaxBodySize}
+	sentBuf := bufio.NewReader(sent)
+	received := &rawStream{r: c.received, max: rc.MaxBodySize}
+	receivedBuf := bufio.NewReader(received)
+
+	for {
+		req, err := http.ReadRequest(sentBuf)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		date := time.Now()
+		reqDigester := sha1.New()
+		sent.limitBody(sentBuf)
+		if _, err := rc.copyBody(reqDigester, req.Body, sent, sentBuf, time.Time{}); err != nil {
+			return err
+		}
+		raw, long := sent.take(sentBuf)
+		reqRec := rc.newRecord(RecordTypeRequest, req, scheme, ip, date, raw)
+		if long {
+			reqRec.Headers.Set(FieldNameWARCTruncated, "length")
+		} else {
+			reqRec.Headers.Set(FieldNameWARCPayloadDigest, formatDigest(reqDigester.Sum(nil)))
+		}
+
+		var (
+			res         *http.Response
+			content     bytes.Buffer
+			resDigester = sha1.New()
+			truncated   string
+		)
+		for {
+			// informational responses are recorded with the final response
+			var timedOut bool
+			res, err = http.ReadResponse(receivedBuf, req)
+			if err == nil {
+				var deadline time.Time
+				if rc.MaxDuration > 0 {
+					deadline = time.Now().Add(rc.MaxDuration)
+				}
+				resDigester.Reset()
+				received.limitBody(receivedBuf)
+				timedOut, err = rc.copyBody(resDigester, res.Body, received, receivedBuf, deadline)
+			}
+			raw, long := received.take(receivedBuf)
+			content.Write(raw)
+			switch {
+			case long:
+				truncated = "length"
+			case timedOut:
+				truncated = "time"
+			case err != nil:
+				truncated = "disconnect"
+			}
+			if timedOut || err != nil || res.StatusCode >= 200 || res.StatusCode == http.StatusSwitchingProtocols {
+				break
+			}
+		}
+
+		recs := []*Record{reqRec}
+		var resRec *Record
+		if content.Len() > 0 {
+			resRec = rc.newRecord(RecordTypeResponse, req, scheme, ip, date, content.Bytes())
+			resRec.Headers.Set(FieldNameWARCConcurrentTo, reqRec.Headers.Get(FieldNameWARCRecordID))
+			if truncated != "" {
+				resRec.Headers.Set(FieldNameWARCTruncated, truncated)
+			}
+			if truncated != "length" {
+				// the payload kept is the payload read
+				resRec.Headers.Set(FieldNameWARCPayloadDigest, formatDigest(resDigester.Sum(nil)))
+			}
+			recs = append(recs, resRec)
+		}
+		if err := rc.writeRecords(recs...); err != nil {
+			return err
+		}
+		if truncated == "" && res.StatusCode == http.StatusSwitchingProtocols && strings.EqualFold(res.Header.Get("Upgrade"), "websocket") {
+			sent.stop()
+			received.stop()
+			return rc.recordWebSocket(resRec, sentBuf, receivedBuf)
+		}
+		if truncated == "time" || err != nil || res.StatusCode == http.StatusSwitchingProtocols || res.Close {
+			// the connection is done with, or no longer speaking HTTP
+			return nil
+		}
+	}
+}
+
+// copyBody copies a message body read through s & br to w, dropping the
+// bytes s keeps past its limit as it goes. Copying stops once deadline has
+// passed, if it isn't zero
+func (rc *RawCapture) copyBody(w io.Writer, body io.Reader, s *rawStream, br *bufio.Reader, deadline time.Time) (timedOut bool, err error) {
+	buf := make([]byte, 32<<10)
+	for {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return true, nil
+		}
+		n, err := body.Read(buf)
+		w.Write(buf[:n])
+		s.trim(br)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// newRecord creates a request or response record holding raw message bytes
+func (rc *RawCapture) newRecord(t RecordType, req *http.Request, scheme, ip string, date time.Time, raw []byte) *Record {
+	rec := &Record{Format: RecordFormatWarc, Type: t, Headers: Header{}, Content: bytes.NewBuffer(raw)}
+	rec.Headers.Set(FieldNameWARCRecordID, NewUUID())
+	target := scheme + "://" + req.Host + req.RequestURI
+	if req.URL.IsAbs() {
+		target = req.URL.String()
+	}
+	rec.Headers.Set(FieldNameWARCTargetURI, target)
+	rec.Headers.Set(FieldNameWARCDate, date.UTC().Format(TimeFormat))
+	if ip != "" {
+		rec.Headers.Set(FieldNameWARCIPAddress, ip)
+	}
+	if rc.WarcinfoID != "" {
+		rec.Headers.Set(FieldNameWARCWarcinfoID, rc.WarcinfoID)
+	}
+	if t == RecordTypeRequest {
+		rec.Headers.Set(FieldNameContentType, "application/http; msgtype=request")
+	} else {
+		rec.Headers.Set(FieldNameContentType, "application/http; msgtype=response")
+	}
+	rec.Headers.Set(FieldNameWARCBlockDigest, Sha1Digest(raw))
+	return rec
+}
+
+func (rc *RawCapture) writeRecords(recs ...*Record) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for _, rec := range recs {
+		if _, _, err := rc.w.WriteRecord(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rawConn is a net.Conn that copies the bytes written & read to streams
+type rawConn struct {
+	net.Conn
+	sent     *connStream
+	received *connStream
+}
+
+func (c *rawConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.received.Write(p[:n])
+	if err != nil {
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			c.received.Close()
+		}
+	}
+	return n, err
+}
+
+func (c *rawConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.sent.Write(p[:n])
+	return n, err
+}
+
+func (c *rawConn) Close() error {
+	err := c.Conn.Close()
+	c.sent.Close()
+	c.received.Close()
+	return err
+}
+
+// connStream is an unbounded pipe, so connections are never held up by
+// recording. Reads block until there's data or the stream is closed
+type connStream struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      bytes.Buffer
+	closed   bool
+	discards bool
+}
+
+func newConnStream() *connStream {
+	s := &connStream{}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *connStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.discards {
+		s.buf.Write(p)
+		s.cond.Broadcast()
+	}
+	return len(p), nil
+}
+
+func (s *connStream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.buf.Len() == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if s.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return s.buf.Read(p)
+}
+
+func (s *connStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.cond.Broadcast()
+	return nil
+}
+
+// discard drops buffered & future writes, once the stream isn't being read
+func (s *connStream) discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.discards = true
+	s.buf.Reset()
+}
+
+// rawStream keeps the bytes read from r, so the exact bytes of messages
+// parsed from a bufio.Reader reading it can be taken. Bodies are kept up to
+// max bytes, if it isn't 0
+type rawStream struct {
+	r       io.Reader
+	raw     bytes.Buffer
+	stopped bool
+	max     int64
+	// keep is the number of bytes of the current message to keep, and
+	// dropped the number dropped past it
+	keep    int
+	dropped int
+}
+
+func (s *rawStream) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if !s.stopped {
+		s.raw.Write(p[:n])
+	}
+	return n, err
+}
+
+// stop stops keeping bytes, once the connection no longer carries HTTP
+func (s *rawStream) stop() {
+	s.stopped = true
+	s.raw.Reset()
+}
+
+// take removes & returns the bytes consumed from br since the last take,
+// leaving those br has buffered but not yet returned. truncated is true if
+// bytes past the limit were dropped
+func (s *rawStream) take(br *bufio.Reader) (raw []byte, truncated bool) {
+	s.trim(br)
+	raw = append([]byte(nil), s.raw.Next(s.raw.Len()-br.Buffered())...)
+	truncated = s.dropped > 0
+	s.keep, s.dropped = 0, 0
+	return raw, truncated
+}
+
+// limitBody limits the bytes kept of the current message to those consumed
+// from br so far, its head, plus max
+func (s *rawStream) limitBody(br *bufio.Reader) {
+	if s.max > 0 {
+		s.keep = s.raw.Len() - br.Buffered() + int(s.max)
+	}
+}
+
+// trim drops the bytes consumed from br past the limit of the current
+// message
+func (s *rawStream) trim(br *bufio.Reader) {
+	consumed := s.raw.Len() - br.Buffered()
+	if s.keep == 0 || consumed <= s.keep {
+		return
+	}
+	b := s.raw.Bytes()
+	n := copy(b[s.keep:], b[consumed:])
+	s.raw.Truncate(s.keep + n)
+	s.dropped += consumed - s.keep
+}
@@ -0,0 +1,250 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRawCapture(t *testing.T) {
+	responses := []string{
+		"HTTP/1.1 200 OK\r\ncontent-TYPE: text/plain\r\nX-Dup: a\r\nX-Dup: b\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n7\r\n, world\r\n0\r\n\r\n",
+		"HTTP/1.1 201 Created\r\nContent-Length: 3\r\nzzz-last: 1\r\n\r\nok\n",
+		"HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\ncut short",
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		for _, res := range responses {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			io.Copy(ioutil.Discard, req.Body)
+			io.WriteString(conn, res)
+		}
+	}()
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := NewRawCapture(w)
+	transport := &http.Transport{DialContext: rc.DialContext(nil)}
+	client := &http.Client{Transport: transport}
+	base := "http://" + l.Addr().String()
+
+	res, err := client.Get(base + "/chunked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(res.Body); string(body) != "hello, world" {
+		t.Errorf("body mismatch. got: %q", body)
+	}
+	res.Body.Close()
+	if res, err = client.Post(base+"/post", "text/plain", strings.NewReader("foo=bar")); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res, err = client.Get(base + "/truncated"); err == nil {
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+	transport.CloseIdleConnections()
+	rc.Wait()
+
+	records := readTestRecordsFrom(t, buf)
+	if len(records) != 6 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", 6, len(records))
+	}
+	cases := []struct {
+		target    string
+		request   string
+		payload   string
+		truncated string
+	}{
+		{base + "/chunked", "GET /chunked HTTP/1.1\r\n", "hello, world", ""},
+		{base + "/post", "POST /post HTTP/1.1\r\n", "ok\n", ""},
+		{base + "/truncated", "GET /truncated HTTP/1.1\r\n", "cut short", "disconnect"},
+	}
+	for i, c := range cases {
+		req, res := records[i*2], records[i*2+1]
+		if req.Type != RecordTypeRequest || res.Type != RecordTypeResponse {
+			t.Fatalf("case %d record types mismatch. got: %s, %s", i, req.Type, res.Type)
+		}
+		if req.TargetURI() != c.target || res.TargetURI() != c.target {
+			t.Errorf("case %d target mismatch. expected: %s, got: %s, %s", i, c.target, req.TargetURI(), res.TargetURI())
+		}
+		if !strings.HasPrefix(req.Content.String(), c.request) {
+			t.Errorf("case %d request mismatch. expected prefix: %q, got: %q", i, c.request, req.Content.String())
+		}
+		if got := res.Content.String(); got != responses[i] {
+			t.Errorf("case %d raw response mismatch. expected: %q, got: %q", i, responses[i], got)
+		}
+		if got := res.Headers.Get(FieldNameWARCPayloadDigest); got != Sha1Digest([]byte(c.payload)) {
+			t.Errorf("case %d payload digest mismatch. expected: %s, got: %s", i, Sha1Digest([]byte(c.payload)), got)
+		}
+		if got := res.Headers.Get(FieldNameWARCConcurrentTo); got != req.Headers.Get(FieldNameWARCRecordID) {
+			t.Errorf("case %d concurrent to mismatch. expected: %s, got: %s", i, req.Headers.Get(FieldNameWARCRecordID), got)
+		}
+		if got := res.Headers.Get(FieldNameWARCTruncated); got != c.truncated {
+			t.Errorf("case %d truncated mismatch. expected: %q, got: %q", i, c.truncated, got)
+		}
+		if got := res.Headers.Get(FieldNameWARCIPAddress); got != "127.0.0.1" {
+			t.Errorf("case %d ip address mismatch. got: %s", i, got)
+		}
+	}
+	if !strings.HasSuffix(records[2].Content.String(), "\r\n\r\nfoo=bar") {
+		t.Errorf("expected raw request body, got: %q", records[2].Content.String())
+	}
+	if got := records[2].Headers.Get(FieldNameWARCPayloadDigest); got != Sha1Digest([]byte("foo=bar")) {
+		t.Errorf("request payload digest mismatch. got: %s", got)
+	}
+}
+
+func TestRawCaptureLimits(t *testing.T) {
+	big := strings.Repeat("x", 100000)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		for i := 0; i < 3; i++ {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			io.Copy(ioutil.Discard, req.Body)
+			switch req.URL.Path {
+			case "/big":
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 100000\r\n\r\n"+big)
+			case "/upload":
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+			case "/slow":
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 15\r\n\r\naaaaa")
+				for _, part := range []string{"bbbbb", "ccccc"} {
+					time.Sleep(200 * time.Millisecond)
+					io.WriteString(conn, part)
+				}
+			}
+		}
+	}()
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := NewRawCapture(w)
+	rc.MaxBodySize = 10
+	rc.MaxDuration = 50 * time.Millisecond
+	transport := &http.Transport{DialContext: rc.DialContext(nil)}
+	client := &http.Client{Transport: transport}
+	base := "http://" + l.Addr().String()
+
+	expect := []string{big, "ok", "aaaaabbbbbccccc"}
+	for i, path := range []string{"/big", "/upload", "/slow"} {
+		res, err := client.Post(base+path, "text/plain", strings.NewReader(strings.Repeat("y", 50)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if string(got) != expect[i] {
+			t.Errorf("case %d body mismatch. expected %d bytes, got: %d", i, len(expect[i]), len(got))
+		}
+	}
+	transport.CloseIdleConnections()
+	rc.Wait()
+
+	records := readTestRecordsFrom(t, buf)
+	if len(records) != 6 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", 6, len(records))
+	}
+	cases := []struct {
+		suffix    string
+		truncated string
+	}{
+		{"\r\n\r\nyyyyyyyyyy", "length"},
+		{"\r\n\r\nxxxxxxxxxx", "length"},
+		{"\r\n\r\nyyyyyyyyyy", "length"},
+		{"\r\n\r\nok", ""},
+		{"\r\n\r\nyyyyyyyyyy", "length"},
+		{"\r\n\r\naaaaabbbbb", "time"},
+	}
+	for i, c := range cases {
+		rec := records[i]
+		if !strings.HasSuffix(rec.Content.String(), c.suffix) {
+			t.Errorf("case %d content mismatch. expected suffix: %q, got: %q", i, c.suffix, rec.Content.String())
+		}
+		if got := rec.Headers.Get(FieldNameWARCTruncated); got != c.truncated {
+			t.Errorf("case %d truncated mismatch. expected: %q, got: %q", i, c.truncated, got)
+		}
+		if got := rec.Headers.Get(FieldNameWARCPayloadDigest); (got == "") != (c.truncated == "length") {
+			t.Errorf("case %d expected payload digest only if not truncated by length, got: %q", i, got)
+		}
+	}
+}
+
+func TestRawCaptureTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secure")
+	}))
+	defer srv.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := NewRawCapture(w)
+	transport := &http.Transport{DialTLSContext: rc.DialTLSContext(nil, &tls.Config{RootCAs: pool, ServerName: "example.com"})}
+	res, err := (&http.Client{Transport: transport}).Get(srv.URL + "/secure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	transport.CloseIdleConnections()
+	rc.Wait()
+
+	records := readTestRecordsFrom(t, buf)
+	if len(records) != 2 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", 2, len(records))
+	}
+	if records[1].TargetURI() != srv.URL+"/secure" {
+		t.Errorf("target mismatch. expected: %s, got: %s", srv.URL+"/secure", records[1].TargetURI())
+	}
+	if !strings.HasPrefix(records[1].Content.String(), "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(records[1].Content.String(), "secure") {
+		t.Errorf("unexpected response record: %q", records[1].Content.String())
+	}
+}
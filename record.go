@@ -244,12 +244,17 @@ const (
 	RecordFormatWarc RecordFormat = iota
 	// RecordFormatUnknown reporesents unknown / errored record format
 	RecordFormatUnknown
+	// RecordFormatWarc11 is the Warc Format 1.1, needed for WARC-Date
+	// values with fractional seconds
+	RecordFormatWarc11
 )
 
 func (r RecordFormat) String() string {
 	switch r {
 	case RecordFormatWarc:
 		return "WARC/1.0"
+	case RecordFormatWarc11:
+		return "WARC/1.1"
 	default:
 		return ""
 	}
@@ -259,6 +264,8 @@ func recordFormat(s string) RecordFormat {
 	switch s {
 	case "WARC/1.0":
 		return RecordFormatWarc
+	case "WARC/1.1":
+		return RecordFormatWarc11
 	default:
 		return RecordFormatUnknown
 	}
@@ -0,0 +1,333 @@
+package warc
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Compression is the compression format of a WARC file
+type Compression int
+
+const (
+	// CompressionNone writes uncompressed WARC files
+	CompressionNone Compression = iota
+	// CompressionGzip compresses each record as a separate gzip member, the
+	// conventional format which allows records to be read independently
+	CompressionGzip
+	// CompressionGzipFile compresses the whole file as a single gzip member
+	CompressionGzipFile
+	// CompressionBzip2 compresses the whole file as a bzip2 stream
+	CompressionBzip2
+)
+
+// String implements the fmt.Stringer interface
+func (c Compression) String() string {
+	switch c {
+	case CompressionGzip:
+		return "gzip"
+	case CompressionGzipFile:
+		return "gzip-file"
+	case CompressionBzip2:
+		return "bzip2"
+	default:
+		return "none"
+	}
+}
+
+// Ext gives the conventional file extension for WARC files compressed with c
+func (c Compression) Ext() string {
+	switch c {
+	case CompressionGzip, CompressionGzipFile:
+		return ".warc.gz"
+	case CompressionBzip2:
+		return ".warc.bz2"
+	default:
+		return ".warc"
+	}
+}
+
+// ParseCompression parses the string form of a compression format
+func ParseCompression(s string) (Compression, error) {
+	switch strings.ToLower(s) {
+	case "none", "":
+		return CompressionNone, nil
+	case "gzip", "gz":
+		return CompressionGzip, nil
+	case "gzip-file":
+		return CompressionGzipFile, nil
+	case "bzip2", "bz2":
+		return CompressionBzip2, nil
+	default:
+		return CompressionNone, errors.Errorf("warc: unknown compression: '%s'", s)
+	}
+}
+
+// OffsetRemap records the move of a record from one file to another,
+// for updating indexes that point into the original file. Offsets of
+// records in whole-file gzip or bzip2 files refer to the uncompressed
+// stream, source records that share a gzip member have a length of -1
+type OffsetRemap struct {
+	RecordID    string
+	SrcFilename string
+	SrcOffset   int64
+	SrcLength   int64
+	DstFilename string
+	DstOffset   int64
+	DstLength   int64
+}
+
+// WriteOffsetRemaps writes remaps as CSV
+func WriteOffsetRemaps(w io.Writer, remaps []OffsetRemap) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"record_id", "src_filename", "src_offset", "src_length", "dst_filename", "dst_offset", "dst_length"})
+	for _, r := range remaps {
+		cw.Write([]string{
+			r.RecordID,
+			r.SrcFilename,
+			strconv.FormatInt(r.SrcOffset, 10),
+			strconv.FormatInt(r.SrcLength, 10),
+			r.DstFilename,
+			strconv.FormatInt(r.DstOffset, 10),
+			strconv.FormatInt(r.DstLength, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Repacker copies records from WARC files into new files, merging,
+// splitting and changing compression along the way. Records linked by
+// WARC-Concurrent-To are never split across output files, and the
+// WARC-Filename field of warcinfo records is set to the name of the file
+// they're written to.
+type Repacker struct {
+	// Dir output files are written to
+	Dir string
+	// Name gives the file name of the i-th output file (counting from 0),
+	// defaulting to "warc-00000" with the extension of Compression
+	Name func(i int) string
+	// Compression of output files
+	Compression Compression
+	// MaxSize starts a new output file once the current file is at least
+	// this many bytes. Sizes of whole-file compressed outputs are approximate.
+	// 0 means no limit
+	MaxSize int64
+}
+
+// RepackResult lists the files a Repacker wrote & the new location of
+// each record
+type RepackResult struct {
+	Files  []string
+	Remaps []OffsetRemap
+}
+
+// Repack copies all records in the given input files to outputs,
+// in order. With no MaxSize set, Repack merges all inputs into a single file
+func (p *Repacker) Repack(inputs ...string) (*RepackResult, error) {
+	out := &repackOutput{p: p, res: &RepackResult{}}
+	defer out.close()
+	for _, path := range inputs {
+		err := eachSourceRecord(path, func(rec *Record, start, end int64) error {
+			if p.MaxSize > 0 && out.size() >= p.MaxSize && !out.linked(rec) {
+				if err := out.close(); err != nil {
+					return err
+				}
+			}
+			return out.write(rec, path, start, end)
+		})
+		if err != nil {
+			return out.res, err
+		}
+	}
+	return out.res, out.close()
+}
+
+// Split copies the records of a single file into n files of roughly equal
+// size. Fewer files are written if the input has too few records
+func (p *Repacker) Split(input string, n int) (*RepackResult, error) {
+	if n < 1 {
+		return nil, errors.Errorf("warc: can't split into %d files", n)
+	}
+	var total int64
+	err := eachSourceRecord(input, func(rec *Record, start, end int64) error {
+		total += sourceSize(rec, start, end)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	out := &repackOutput{p: p, res: &RepackResult{}}
+	defer out.close()
+	var read int64
+	err = eachSourceRecord(input, func(rec *Record, start, end int64) error {
+		// start a new file at the record boundary closest to the end of this
+		// file's share of the input
+		size := sourceSize(rec, start, end)
+		if out.w != nil && !out.linked(rec) {
+			share := total * int64(len(out.res.Files)) / int64(n)
+			if read >= share || read+size-share > share-read {
+				if err := out.close(); err != nil {
+					return err
+				}
+			}
+		}
+		read += size
+		return out.write(rec, input, start, end)
+	})
+	if err != nil {
+		return out.res, err
+	}
+	return out.res, out.close()
+}
+
+func (p *Repacker) name(i int) string {
+	if p.Name != nil {
+		return p.Name(i)
+	}
+	return fmt.Sprintf("warc-%05d%s", i, p.Compression.Ext())
+}
+
+// sourceSize gives the size of a record in its source file
+func sourceSize(rec *Record, start, end int64) int64 {
+	if end < 0 {
+		return int64(rec.ContentLength())
+	}
+	return end - start
+}
+
+// eachSourceRecord calls fn for each record in a file with the record's
+// position in the file. endPos is -1 for records that share a gzip member
+func eachSourceRecord(path string, fn func(rec *Record, start, end int64) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rdr, err := NewOffsetReader(f)
+	if err != nil {
+		return errors.Wrap(err, path)
+	}
+	for {
+		rec, start, end, err := rdr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, path)
+		}
+		if err := fn(rec, start, end); err != nil {
+			return err
+		}
+	}
+}
+
+// repackOutput is the file a Repacker is currently writing to
+type repackOutput struct {
+	p   *Repacker
+	res *RepackResult
+
+	file     *os.File
+	filename string
+	counter  io.WriteSeeker // counts bytes written to file
+	closer   io.Closer      // whole-file compressor, if any
+	w        *Writer
+	last     *Record
+}
+
+// open starts the next output file
+func (o *repackOutput) open() error {
+	o.filename = o.p.name(len(o.res.Files))
+	path := filepath.Join(o.p.Dir, o.filename)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	o.file = f
+	o.counter = CountWriter(f)
+	o.res.Files = append(o.res.Files, path)
+
+	switch o.p.Compression {
+	case CompressionGzip:
+		o.w, err = NewWriterCompressed(o.counter, gzip.NewWriter(o.counter))
+	case CompressionGzipFile:
+		gz := gzip.NewWriter(o.counter)
+		o.closer = gz
+		o.w, err = NewWriterRaw(CountWriter(gz))
+	case CompressionBzip2:
+		bz := newBzip2Writer(o.counter)
+		o.closer = bz
+		o.w, err = NewWriterRaw(CountWriter(bz))
+	default:
+		o.w, err = NewWriterRaw(o.counter)
+	}
+	return err
+}
+
+func (o *repackOutput) write(rec *Record, src string, start, end int64) error {
+	if o.w == nil {
+		if err := o.open(); err != nil {
+			return err
+		}
+	}
+	if rec.Type == RecordTypeWarcInfo {
+		rec.Headers.Set(FieldNameWARCFilename, o.filename)
+	}
+	dstStart, dstEnd, err := o.w.WriteRecord(rec)
+	if err != nil {
+		return err
+	}
+	length := int64(-1)
+	if end >= 0 {
+		length = end - start
+	}
+	o.res.Remaps = append(o.res.Remaps, OffsetRemap{
+		RecordID:    rec.Headers.Get(FieldNameWARCRecordID),
+		SrcFilename: filepath.Base(src),
+		SrcOffset:   start,
+		SrcLength:   length,
+		DstFilename: o.filename,
+		DstOffset:   dstStart,
+		DstLength:   dstEnd - dstStart,
+	})
+	o.last = rec
+	return nil
+}
+
+// linked reports whether rec belongs with the last record written
+func (o *repackOutput) linked(rec *Record) bool {
+	return o.last != nil && linkedRecord([]*Record{o.last}, rec)
+}
+
+// size gives the number of bytes written to the current file
+func (o *repackOutput) size() int64 {
+	if o.counter == nil {
+		return 0
+	}
+	n, _ := o.counter.Seek(0, io.SeekCurrent)
+	return n
+}
+
+// close finishes the current file, if any
+func (o *repackOutput) close() error {
+	if o.file == nil {
+		return nil
+	}
+	var err error
+	if o.closer != nil {
+		err = o.closer.Close()
+	}
+	if cerr := o.file.Close(); err == nil {
+		err = cerr
+	}
+	o.file, o.counter, o.closer, o.w, o.last = nil, nil, nil, nil, nil
+	return err
+}
@@ -0,0 +1,201 @@
+package warc
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBzip2Writer(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := make([]byte, 100000)
+	rnd.Read(random)
+	letters := make([]byte, 1000000)
+	for i := range letters {
+		letters[i] = byte('a' + rnd.Intn(4))
+	}
+	example, err := ioutil.ReadFile("testdata/warcio/example.warc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := [][]byte{
+		nil,
+		[]byte("a"),
+		bytes.Repeat([]byte("a"), 1000),
+		bytes.Repeat([]byte("ab"), 10000),
+		random,
+		letters,
+		example,
+	}
+	for i, c := range cases {
+		buf := &bytes.Buffer{}
+		z := newBzip2Writer(buf)
+		if _, err := z.Write(c); err != nil {
+			t.Fatal(err)
+		}
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(bzip2.NewReader(buf))
+		if err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		if !bytes.Equal(got, c) {
+			t.Errorf("case %d mismatch. expected %d bytes, got: %d", i, len(c), len(got))
+		}
+	}
+}
+
+func FuzzBzip2Writer(f *testing.F) {
+	f.Add([]byte(""), 0)
+	f.Add([]byte("a"), 1)
+	f.Add(bytes.Repeat([]byte("a"), 300), 4)
+	f.Add(bytes.Repeat([]byte("abc"), 1000), 255)
+	f.Add([]byte("WARC/1.0\r\nWARC-Type: response\r\n\r\n\x00\xff\x00\xff"), 10)
+	f.Fuzz(func(t *testing.T, data []byte, split int) {
+		buf := &bytes.Buffer{}
+		z := newBzip2Writer(buf)
+		// write in two parts to cover runs spanning writes
+		if split < 0 || split > len(data) {
+			split = len(data) / 2
+		}
+		if _, err := z.Write(data[:split]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := z.Write(data[split:]); err != nil {
+			t.Fatal(err)
+		}
+		if err := z.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(bzip2.NewReader(buf))
+		if err != nil {
+			t.Fatalf("decompressing: %s", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("round trip mismatch. expected %d bytes, got: %d", len(data), len(got))
+		}
+	})
+}
+
+func TestRepackerCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc_repack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := "testdata/warcio/example.warc.gz"
+	expect := readTestRecords(t, src)
+	for i, c := range []Compression{CompressionNone, CompressionGzip, CompressionGzipFile, CompressionBzip2} {
+		p := &Repacker{Dir: dir, Compression: c}
+		p.Name = func(int) string { return "out-" + c.String() + c.Ext() }
+		res, err := p.Repack(src)
+		if err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		if len(res.Files) != 1 || len(res.Remaps) != len(expect) {
+			t.Errorf("case %d expected 1 file & %d remaps, got: %d, %d", i, len(expect), len(res.Files), len(res.Remaps))
+			continue
+		}
+
+		got := readTestRecords(t, res.Files[0])
+		if len(got) != len(expect) {
+			t.Errorf("case %d record count mismatch. expected: %d, got: %d", i, len(expect), len(got))
+			continue
+		}
+		for j, rec := range got {
+			if rec.Headers.Get(FieldNameWARCRecordID) != expect[j].Headers.Get(FieldNameWARCRecordID) || !bytes.Equal(rec.Content.Bytes(), expect[j].Content.Bytes()) {
+				t.Errorf("case %d record %d mismatch", i, j)
+			}
+		}
+		if fn := got[0].Headers.Get(FieldNameWARCFilename); fn != filepath.Base(res.Files[0]) {
+			t.Errorf("case %d filename mismatch. expected: %s, got: %s", i, filepath.Base(res.Files[0]), fn)
+		}
+
+		// remaps into randomly accessible files should point at the record
+		if c != CompressionNone && c != CompressionGzip {
+			continue
+		}
+		f, err := os.Open(res.Files[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, r := range res.Remaps {
+			if r.SrcFilename != "example.warc.gz" || r.RecordID != expect[j].Headers.Get(FieldNameWARCRecordID) {
+				t.Errorf("case %d remap %d source mismatch: %v", i, j, r)
+			}
+			rec, err := ReadRecordAt(f, r.DstOffset, r.DstLength)
+			if err != nil {
+				t.Errorf("case %d remap %d error: %s", i, j, err)
+				continue
+			}
+			if rec.Headers.Get(FieldNameWARCRecordID) != r.RecordID {
+				t.Errorf("case %d remap %d id mismatch. expected: %s, got: %s", i, j, r.RecordID, rec.Headers.Get(FieldNameWARCRecordID))
+			}
+		}
+		f.Close()
+	}
+}
+
+func TestRepackerMergeSplit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc_repack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		prefix  string
+		maxSize int64
+		split   int
+		inputs  []string
+		counts  []int
+	}{
+		// request & response pairs are kept together
+		{"a", 1, 0, []string{"testdata/warcio/example.warc.gz"}, []int{1, 1, 2, 2}},
+		{"b", 0, 0, []string{"testdata/warcio/example.warc.gz", "testdata/warcio/post-test.warc.gz"}, []int{12}},
+		{"c", 1, 0, []string{"testdata/warcio/example.warc.gz", "testdata/warcio/post-test.warc.gz"}, []int{1, 1, 2, 2, 2, 2, 2}},
+		{"d", 0, 2, []string{"testdata/warcio/post-test.warc.gz"}, []int{4, 2}},
+		{"e", 0, 10, []string{"testdata/warcio/post-test.warc.gz"}, []int{2, 2, 2}},
+	}
+
+	for i, c := range cases {
+		p := &Repacker{Dir: dir, Compression: CompressionGzip, MaxSize: c.maxSize}
+		prefix := c.prefix
+		p.Name = func(i int) string { return prefix + "-" + string('0'+rune(i)) + ".warc.gz" }
+
+		var res *RepackResult
+		if c.split > 0 {
+			res, err = p.Split(c.inputs[0], c.split)
+		} else {
+			res, err = p.Repack(c.inputs...)
+		}
+		if err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+
+		var counts []int
+		for _, path := range res.Files {
+			counts = append(counts, len(readTestRecords(t, path)))
+		}
+		if len(counts) != len(c.counts) {
+			t.Errorf("case %d file count mismatch. expected: %v, got: %v", i, c.counts, counts)
+			continue
+		}
+		for j := range counts {
+			if counts[j] != c.counts[j] {
+				t.Errorf("case %d record counts mismatch. expected: %v, got: %v", i, c.counts, counts)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package warc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ReadAtCloser is the interface for random access to a WARC file,
+// *os.File satisfies ReadAtCloser
+type ReadAtCloser interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// Collection is a set of WARC files addressed through a CDXJ index
+type Collection struct {
+	Index CDXJIndex
+	// Open gives random access to a WARC file named in the index
+	Open func(filename string) (ReadAtCloser, error)
+}
+
+// DirOpener returns an Open func for a Collection that opens files from dir
+func DirOpener(dir string) func(filename string) (ReadAtCloser, error) {
+	return func(filename string) (ReadAtCloser, error) {
+		return os.Open(filepath.Join(dir, filepath.Clean("/"+filename)))
+	}
+}
+
+// Capture locates & reads the record for an index entry, resolving
+// revisit records to the original capture where possible
+func (c *Collection) Capture(cdx *CDXJRecord) (*Record, error) {
+	if cdx.Mime == "warc/revisit" {
+		if orig := c.Index.Original(cdx); orig != nil {
+			cdx = orig
+		}
+	}
+	f, err := c.Open(cdx.Filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadRecordAt(f, cdx.Offset, cdx.Length)
+}
+
+// ReplayServer is an http.Handler that serves archived captures wayback-style,
+// at paths of the form:
+//
+//	/{collection}/{timestamp}/{url}
+//
+// Timestamps may be partial ("2017" is read as 20170101000000), the
+// capture closest to the requested time is served. Links in HTML, CSS and
+// javascript responses are rewritten to point back into the archive, unless
+// the timestamp carries an "id_" (identity) modifier, eg: 2017id_. Responses
+// with a Content-Encoding that can't be decoded (eg: br) are served as
+// archived, without rewriting
+//
+// ReplayServer also implements the Memento protocol (RFC 7089), with
+// TimeMaps & TimeGates served at:
+//
+//	/{collection}/timemap/link/{url}
+//	/{collection}/timemap/json/{url}
+//	/{collection}/timegate/{url}
+//	/{collection}/{url}
+type ReplayServer struct {
+	Collections map[string]*Collection
+}
+
+// NewReplayServer creates a replay server for a set of named collections
+func NewReplayServer(collections map[string]*Collection) *ReplayServer {
+	return &ReplayServer{Collections: collections}
+}
+
+// ServeHTTP implements http.Handler
+func (s *ReplayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(parts) == 3 {
+		switch parts[1] {
+		case "timemap":
+			s.serveTimeMap(w, r, parts[0], parts[2])
+			return
+		case "timegate":
+			s.serveTimeGate(w, r, parts[0], parts[2])
+			return
+		}
+	}
+	if len(parts) > 1 && parts[1] != "" && !replayTimestamp.MatchString(parts[1]) {
+		// paths without a timestamp act as a timegate
+		s.serveTimeGate(w, r, parts[0], strings.Join(parts[1:], "/"))
+		return
+	}
+	s.serveMemento(w, r)
+}
+
+// serveMemento replays the capture closest to the requested timestamp
+func (s *ReplayServer) serveMemento(w http.ResponseWriter, r *http.Request) {
+	req, err := parseReplayPath(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	coll, ok := s.Collections[req.Collection]
+	if !ok {
+		http.Error(w, fmt.Sprintf("collection not found: %s", req.Collection), http.StatusNotFound)
+		return
+	}
+
+	cdx := coll.Index.Closest(req.URL, req.Time)
+	if cdx == nil {
+		http.Error(w, fmt.Sprintf("no captures found for: %s", req.URL), http.StatusNotFound)
+		return
+	}
+	rec, err := coll.Capture(cdx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	urls := newMementoURLs(r, req.Collection, cdx.URL)
+	w.Header().Set("Memento-Datetime", cdx.Time().Format(http.TimeFormat))
+	w.Header().Set("Link", urls.links(cdx))
+
+	var rw *URLRewriter
+	if req.Modifier != "id_" {
+		prefix := fmt.Sprintf("/%s/%s/", req.Collection, req.Timestamp)
+		if rw, err = NewURLRewriter(prefix, cdx.URL); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := writeReplay(w, rec, rw); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// replayRequest is a parsed wayback-style replay path
+type replayRequest struct {
+	Collection string
+	Timestamp  string
+	Modifier   string
+	Time       time.Time
+	URL        string
+}
+
+var replayTimestamp = regexp.MustCompile(`^(\d{1,14})([a-z]{2}_)?$`)
+
+// parseReplayPath breaks a request path into collection, timestamp and url
+func parseReplayPath(r *http.Request) (*replayRequest, error) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return nil, errors.New("replay paths take the form /{collection}/{timestamp}/{url}")
+	}
+	m := replayTimestamp.FindStringSubmatch(parts[1])
+	if m == nil {
+		return nil, errors.Errorf("invalid timestamp: '%s'", parts[1])
+	}
+
+	req := &replayRequest{
+		Collection: parts[0],
+		Timestamp:  padTimestamp(m[1]),
+		Modifier:   m[2],
+		URL:        replayURL(parts[2], r.URL.RawQuery),
+	}
+	t, err := time.Parse(TimestampFormat, req.Timestamp)
+	if err != nil {
+		return nil, errors.Errorf("invalid timestamp: '%s'", parts[1])
+	}
+	req.Time = t
+	return req, nil
+}
+
+// padTimestamp fills in missing trailing digits of a partial timestamp
+func padTimestamp(ts string) string {
+	const pad = "00000101000000"
+	if len(ts) >= len(pad) {
+		return ts[:len(pad)]
+	}
+	return ts + pad[len(ts):]
+}
+
+// replayURL restores an archived url from the tail of a replay path. Slashes
+// following the scheme can be merged by path cleaning, and query params
+// are split off by url parsing
+func replayURL(path, rawQuery string) string {
+	for _, scheme := range []string{"http:", "https:"} {
+		if strings.HasPrefix(path, scheme) && !strings.HasPrefix(path, scheme+"//") {
+			path = scheme + "//" + strings.TrimLeft(path[len(scheme):], "/")
+		}
+	}
+	if !strings.Contains(path, "://") {
+		path = "http://" + path
+	}
+	if rawQuery != "" {
+		path += "?" + rawQuery
+	}
+	return path
+}
+
+// hopHeaders are headers that apply to a single connection, and shouldn't be
+// replayed
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// writeReplay writes the stored HTTP response of rec to w. resource records
+// are served with their record Content-Type. If rw isn't nil urls in the
+// response are rewritten, unless its Content-Encoding can't be decoded
+func writeReplay(w http.ResponseWriter, rec *Record, rw *URLRewriter) error {
+	var (
+		status  = http.StatusOK
+		body    io.ReadCloser
+		rewrite = true
+	)
+	switch rec.Type {
+	case RecordTypeResponse, RecordTypeRevisit:
+		res, err := rec.HTTPResponse()
+		if err != nil {
+			return err
+		}
+		status = res.StatusCode
+		body = res.Body
+		for key, vals := range res.Header {
+			// keep any headers set by the server, eg: Memento Link headers
+			w.Header()[key] = append(w.Header()[key], vals...)
+		}
+
+		if rw != nil {
+			if rewriteFunc(res.Header.Get("Content-Type"), rw) != nil {
+				if decoded, err := DecodeContentEncoding(res); err == nil {
+					body = decoded
+					w.Header().Del("Content-Encoding")
+					w.Header().Del("Content-Length")
+				} else {
+					// serve the archived bytes as they are, unrewritten
+					res.Body.Close()
+					if res, err = rec.HTTPResponse(); err != nil {
+						return err
+					}
+					body = res.Body
+					rewrite = false
+				}
+			}
+			if loc := res.Header.Get("Location"); loc != "" {
+				w.Header().Set("Location", rw.RewriteURL(loc))
+			}
+		}
+	case RecordTypeResource:
+		w.Header().Set("Content-Type", rec.Headers.Get(FieldNameContentType))
+		body = ioutil.NopCloser(bytes.NewReader(rec.Content.Bytes()))
+	default:
+		return errors.Errorf("cannot replay %s record", rec.Type)
+	}
+	defer body.Close()
+
+	for _, key := range hopHeaders {
+		w.Header().Del(key)
+	}
+	w.WriteHeader(status)
+	// headers are sent, errors from here on can't be reported to the client
+	if fn := rewriteFunc(w.Header().Get("Content-Type"), rw); fn != nil && rewrite {
+		fn(w, body)
+	} else {
+		io.Copy(w, body)
+	}
+	return nil
+}
+
+// rewriteFunc picks a rewriter method for a content type, returning nil if
+// the content type isn't rewritten or rw is nil
+func rewriteFunc(contentType string, rw *URLRewriter) func(w io.Writer, r io.Reader) error {
+	if rw == nil {
+		return nil
+	}
+	switch mediaType(contentType) {
+	case "text/html", "application/xhtml+xml":
+		return rw.RewriteHTML
+	case "text/css":
+		return rw.RewriteCSS
+	case "application/javascript", "application/x-javascript", "text/javascript", "application/ecmascript":
+		return rw.RewriteJS
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+package warc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReplayServer(t *testing.T) {
+	s := NewReplayServer(map[string]*Collection{
+		"test": testCollection(t),
+	})
+
+	cases := []struct {
+		path   string
+		status int
+		link   string
+	}{
+		{"/test/20170306040206/http://example.com/", http.StatusOK, `href="/test/20170306040206/http://www.iana.org/domains/example"`},
+		{"/test/2018/http:/example.com/", http.StatusOK, `href="/test/20180101000000/http://www.iana.org/domains/example"`},
+		{"/test/2017id_/example.com/", http.StatusOK, `href="http://www.iana.org/domains/example"`},
+		{"/test/2017/http://example.com/missing", http.StatusNotFound, ""},
+		{"/missing/2017/http://example.com/", http.StatusNotFound, ""},
+		{"/test/notatimestamp/http://example.com/", http.StatusNotFound, ""},
+		{"/test", http.StatusBadRequest, ""},
+	}
+
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", c.path, nil))
+		res := w.Result()
+		var err error
+		if res.StatusCode != c.status {
+			t.Errorf("case %d status mismatch. expected: %d, got: %d", i, c.status, res.StatusCode)
+			continue
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+
+		if res.Header.Get("Content-Type") != "text/html" {
+			t.Errorf("case %d content-type mismatch. expected: text/html, got: %s", i, res.Header.Get("Content-Type"))
+		}
+		if res.Header.Get("Connection") != "" {
+			t.Errorf("case %d hop-by-hop Connection header shouldn't be replayed", i)
+		}
+		var rdr io.Reader = res.Body
+		if res.Header.Get("Content-Encoding") == "gzip" {
+			if rdr, err = gzip.NewReader(res.Body); err != nil {
+				t.Errorf("case %d: %s", i, err)
+				continue
+			}
+		}
+		body, err := ioutil.ReadAll(rdr)
+		if err != nil {
+			t.Errorf("case %d: %s", i, err)
+			continue
+		}
+		if !strings.Contains(string(body), "Example Domain") {
+			t.Errorf("case %d: expected archived body", i)
+		}
+		if c.link != "" && !strings.Contains(string(body), c.link) {
+			t.Errorf("case %d: expected body to contain link: %s", i, c.link)
+		}
+	}
+}
+
+func testCollection(t *testing.T) *Collection {
+	return &Collection{
+		Index: testIndex(t),
+		Open:  DirOpener("testdata/warcio"),
+	}
+}
+
+func TestWriteReplayUndecodable(t *testing.T) {
+	rec := testEncodedResponse("http://example.com/", "br", "\x8b\x03\x80<a href=\"/x\">")
+	rw, err := NewURLRewriter("/coll/2017/", "http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	if err := writeReplay(w, rec, rw); err != nil {
+		t.Fatal(err)
+	}
+	res := w.Result()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("status mismatch. expected: %d, got: %d", http.StatusOK, res.StatusCode)
+	}
+	if res.Header.Get("Content-Encoding") != "br" {
+		t.Errorf("content-encoding mismatch. expected: br, got: %s", res.Header.Get("Content-Encoding"))
+	}
+	if body := w.Body.String(); body != "\x8b\x03\x80<a href=\"/x\">" {
+		t.Errorf("body mismatch. expected archived bytes, got: %q", body)
+	}
+}
+
+// testEncodedResponse creates a response record of an html body with a
+// Content-Encoding
+func testEncodedResponse(url, encoding, body string) *Record {
+	return &Record{
+		Format: RecordFormatWarc,
+		Type:   RecordTypeResponse,
+		Headers: Header{
+			FieldNameWARCRecordID:  NewUUID(),
+			FieldNameWARCDate:      "2017-03-06T04:02:06Z",
+			FieldNameWARCTargetURI: url,
+			FieldNameContentType:   "application/http; msgtype=response",
+		},
+		Content: bytes.NewBufferString(fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n%s", encoding, len(body), body)),
+	}
+}
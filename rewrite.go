@@ -0,0 +1,261 @@
+package warc
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// URLRewriter rewrites urls found in archived content so they point into a
+// replay archive instead of escaping to the live web. With a Prefix of
+// "/collection/20170306040206/" and a Base of "http://example.com/a/", the
+// link "b.css" becomes "/collection/20170306040206/http://example.com/a/b.css"
+type URLRewriter struct {
+	// Prefix is prepended to absolute urls
+	Prefix string
+	// Base is the url of the document being rewritten, used to
+	// resolve relative urls
+	Base *url.URL
+}
+
+// NewURLRewriter creates a rewriter for the document at baseURL
+func NewURLRewriter(prefix, baseURL string) (*URLRewriter, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	return &URLRewriter{Prefix: prefix, Base: base}, nil
+}
+
+// RewriteURL rewrites a single url. urls that don't point to a http(s) resource,
+// like fragments, "data:" and "javascript:" urls are returned unchanged
+func (rw *URLRewriter) RewriteURL(rawurl string) string {
+	trimmed := strings.TrimSpace(rawurl)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, rw.Prefix) {
+		return rawurl
+	}
+	u, err := url.Parse(trimmed)
+	if err != nil {
+		return rawurl
+	}
+	if rw.Base != nil {
+		u = rw.Base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return rawurl
+	}
+	return rw.Prefix + u.String()
+}
+
+// urlAttrs lists html attributes that hold a single url
+var urlAttrs = map[string]bool{
+	"action":     true,
+	"background": true,
+	"cite":       true,
+	"codebase":   true,
+	"data":       true,
+	"formaction": true,
+	"href":       true,
+	"icon":       true,
+	"longdesc":   true,
+	"manifest":   true,
+	"poster":     true,
+	"src":        true,
+}
+
+// RewriteHTML streams html from r to w, rewriting urls in attributes, srcset
+// values, inline styles, meta refresh tags, and <script> & <style> elements.
+// A <base href> tag changes the url relative links resolve against.
+func (rw *URLRewriter) RewriteHTML(w io.Writer, r io.Reader) error {
+	z := html.NewTokenizer(r)
+	rawTag := ""
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return nil
+			}
+			return z.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			// Token may modify the raw slice, so copy first
+			raw := append([]byte(nil), z.Raw()...)
+			tok := z.Token()
+			rawTag = ""
+			if tt == html.StartTagToken && (tok.Data == "script" || tok.Data == "style") {
+				rawTag = tok.Data
+			}
+			if rw.rewriteAttrs(&tok) {
+				raw = []byte(tok.String())
+			}
+			if _, err := w.Write(raw); err != nil {
+				return err
+			}
+			continue
+		case html.TextToken:
+			text := string(z.Raw())
+			switch rawTag {
+			case "script":
+				text = rw.rewriteJS(text)
+			case "style":
+				text = rw.rewriteCSS(text)
+			}
+			if _, err := io.WriteString(w, text); err != nil {
+				return err
+			}
+			continue
+		case html.EndTagToken:
+			rawTag = ""
+		}
+		if _, err := w.Write(z.Raw()); err != nil {
+			return err
+		}
+	}
+}
+
+// rewriteAttrs rewrites urls in the attributes of tok, returning true
+// if any attributes were changed
+func (rw *URLRewriter) rewriteAttrs(tok *html.Token) (changed bool) {
+	if tok.Data == "base" {
+		for _, a := range tok.Attr {
+			if a.Key == "href" {
+				if u, err := url.Parse(strings.TrimSpace(a.Val)); err == nil {
+					if rw.Base != nil {
+						u = rw.Base.ResolveReference(u)
+					}
+					rw.Base = u
+				}
+			}
+		}
+	}
+
+	isRefresh := false
+	for _, a := range tok.Attr {
+		if a.Key == "http-equiv" && strings.EqualFold(a.Val, "refresh") {
+			isRefresh = true
+		}
+	}
+
+	for i, a := range tok.Attr {
+		val := a.Val
+		switch {
+		case urlAttrs[a.Key]:
+			val = rw.RewriteURL(a.Val)
+		case a.Key == "srcset" || a.Key == "imagesrcset":
+			val = rw.rewriteSrcset(a.Val)
+		case a.Key == "style":
+			val = rw.rewriteCSS(a.Val)
+		case a.Key == "content" && isRefresh:
+			val = rw.rewriteRefresh(a.Val)
+		}
+		if val != a.Val {
+			tok.Attr[i].Val = val
+			changed = true
+		}
+	}
+	return changed
+}
+
+// rewriteSrcset rewrites a comma-separated list of "url [descriptor]" pairs
+func (rw *URLRewriter) rewriteSrcset(srcset string) string {
+	candidates := strings.Split(srcset, ",")
+	for i, c := range candidates {
+		fields := strings.Fields(c)
+		if len(fields) == 0 {
+			continue
+		}
+		fields[0] = rw.RewriteURL(fields[0])
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+var refreshURL = regexp.MustCompile(`(?i)(url\s*=\s*['"]?)([^'"]+)`)
+
+// rewriteRefresh rewrites the url in a meta refresh value
+func (rw *URLRewriter) rewriteRefresh(content string) string {
+	return replaceURLs(refreshURL, content, rw.RewriteURL, 2)
+}
+
+var (
+	cssURL    = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]+))\s*\)`)
+	cssImport = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+	jsAssign  = regexp.MustCompile(`\b(?:(?:window|document)\.)?location(?:\.href)?\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	jsCall    = regexp.MustCompile(`\blocation\.(?:replace|assign)\(\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// RewriteCSS streams css from r to w, rewriting url() values and @import rules
+func (rw *URLRewriter) RewriteCSS(w io.Writer, r io.Reader) error {
+	return rewriteLines(w, r, rw.rewriteCSS)
+}
+
+// RewriteJS streams javascript from r to w, rewriting string literals assigned
+// to window.location, location.href or passed to location.replace/assign
+func (rw *URLRewriter) RewriteJS(w io.Writer, r io.Reader) error {
+	return rewriteLines(w, r, rw.rewriteJS)
+}
+
+func (rw *URLRewriter) rewriteCSS(css string) string {
+	css = replaceURLs(cssURL, css, rw.RewriteURL)
+	return replaceURLs(cssImport, css, rw.RewriteURL)
+}
+
+func (rw *URLRewriter) rewriteJS(js string) string {
+	js = replaceURLs(jsAssign, js, rw.RewriteURL)
+	return replaceURLs(jsCall, js, rw.RewriteURL)
+}
+
+// rewriteLines applies fn to each line of r, writing results to w
+func rewriteLines(w io.Writer, r io.Reader, fn func(string) string) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			if _, werr := io.WriteString(w, fn(line)); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// replaceURLs replaces the first matching subgroup of each match of re in s
+// with fn(subgroup). if groups are specified only those subgroups are
+// considered
+func replaceURLs(re *regexp.Regexp, s string, fn func(string) string, groups ...int) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	if len(groups) == 0 {
+		for g := 1; g <= re.NumSubexp(); g++ {
+			groups = append(groups, g)
+		}
+	}
+
+	b := strings.Builder{}
+	last := 0
+	for _, m := range matches {
+		for _, g := range groups {
+			start, end := m[2*g], m[2*g+1]
+			if start < 0 {
+				continue
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(fn(s[start:end]))
+			last = end
+			break
+		}
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
@@ -0,0 +1,92 @@
+package warc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRewriteURL(t *testing.T) {
+	rw, err := NewURLRewriter("/coll/20170306040206/", "http://example.com/a/page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		in, expect string
+	}{
+		{"b.css", "/coll/20170306040206/http://example.com/a/b.css"},
+		{"/root.js", "/coll/20170306040206/http://example.com/root.js"},
+		{"//cdn.example.com/x.png", "/coll/20170306040206/http://cdn.example.com/x.png"},
+		{"https://other.org/?q=1", "/coll/20170306040206/https://other.org/?q=1"},
+		{"/coll/20170306040206/http://example.com/", "/coll/20170306040206/http://example.com/"},
+		{"#section", "#section"},
+		{"data:image/png;base64,AAAA", "data:image/png;base64,AAAA"},
+		{"javascript:void(0)", "javascript:void(0)"},
+		{"mailto:someone@example.com", "mailto:someone@example.com"},
+		{"", ""},
+	}
+	for i, c := range cases {
+		if got := rw.RewriteURL(c.in); got != c.expect {
+			t.Errorf("case %d mismatch. expected: '%s', got: '%s'", i, c.expect, got)
+		}
+	}
+}
+
+func TestRewriteHTML(t *testing.T) {
+	const p = "/coll/2017/"
+	cases := []struct {
+		in, expect string
+	}{
+		{`<a href="/a">link</a>`, `<a href="/coll/2017/http://example.com/a">link</a>`},
+		{`<A HREF='b' class=x>`, `<a href="/coll/2017/http://example.com/dir/b" class="x">`},
+		{`<p title="unchanged"   >text &amp; more</p>`, `<p title="unchanged"   >text &amp; more</p>`},
+		{`<img src="i.png" srcset="i-1x.png 1x, i-2x.png 2x"/>`, `<img src="/coll/2017/http://example.com/dir/i.png" srcset="/coll/2017/http://example.com/dir/i-1x.png 1x, /coll/2017/http://example.com/dir/i-2x.png 2x"/>`},
+		{`<div style="background: url('bg.png')">`, `<div style="background: url(&#39;/coll/2017/http://example.com/dir/bg.png&#39;)">`},
+		{`<meta http-equiv="refresh" content="0; url=http://other.org/">`, `<meta http-equiv="refresh" content="0; url=/coll/2017/http://other.org/">`},
+		{`<base href="http://base.org/x/"><a href="y">`, `<base href="/coll/2017/http://base.org/x/"><a href="/coll/2017/http://base.org/x/y">`},
+		{`<script>window.location = "/next"; var a = "<a href='x'>";</script>`, `<script>window.location = "/coll/2017/http://example.com/next"; var a = "<a href='x'>";</script>`},
+		{`<style>@import "s.css"; body { background: url(bg.png) }</style>`, `<style>@import "/coll/2017/http://example.com/dir/s.css"; body { background: url(/coll/2017/http://example.com/dir/bg.png) }</style>`},
+	}
+
+	for i, c := range cases {
+		rw, err := NewURLRewriter(p, "http://example.com/dir/index.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		if err := rw.RewriteHTML(buf, strings.NewReader(c.in)); err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		if buf.String() != c.expect {
+			t.Errorf("case %d mismatch.\nexpected: %s\ngot:      %s", i, c.expect, buf.String())
+		}
+	}
+}
+
+func TestRewriteCSSAndJS(t *testing.T) {
+	rw, err := NewURLRewriter("/coll/2017/", "http://example.com/css/main.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	css := "@import url(\"base.css\");\n.a { background: url( '../img/a.png' ) }\n.b { background: url(data:image/png;base64,AA==) }\n"
+	expect := "@import url(\"/coll/2017/http://example.com/css/base.css\");\n.a { background: url( '/coll/2017/http://example.com/img/a.png' ) }\n.b { background: url(data:image/png;base64,AA==) }\n"
+	buf := &bytes.Buffer{}
+	if err := rw.RewriteCSS(buf, strings.NewReader(css)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expect {
+		t.Errorf("css mismatch.\nexpected: %s\ngot:      %s", expect, buf.String())
+	}
+
+	js := "location.href = 'http://other.org/';\ndocument.location.replace(\"/x\");\nvar location2 = 'foo';\nmylocation = \"/y\";"
+	expect = "location.href = '/coll/2017/http://other.org/';\ndocument.location.replace(\"/coll/2017/http://example.com/x\");\nvar location2 = 'foo';\nmylocation = \"/y\";"
+	buf.Reset()
+	if err := rw.RewriteJS(buf, strings.NewReader(js)); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expect {
+		t.Errorf("js mismatch.\nexpected: %s\ngot:      %s", expect, buf.String())
+	}
+}
@@ -0,0 +1,199 @@
+package warc
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// StatCount is the number & total block size of a group of records
+type StatCount struct {
+	Records int64 `json:"records"`
+	Bytes   int64 `json:"bytes"`
+}
+
+func (c *StatCount) add(size int64) {
+	c.Records++
+	c.Bytes += size
+}
+
+// Stats aggregates record counts & sizes across one or more WARC files.
+// Sizes are record block lengths. Captures are response, resource & revisit
+// records, MIME types, statuses & hosts are only counted for captures.
+//
+// Create a Stats with NewStats, then add records with AddFile or Add
+type Stats struct {
+	Records int64 `json:"records"`
+	Bytes   int64 `json:"bytes"`
+
+	Types     map[string]*StatCount `json:"types"`
+	MimeTypes map[string]*StatCount `json:"mime_types"`
+	Statuses  map[string]*StatCount `json:"statuses"`
+	Hosts     map[string]*StatCount `json:"hosts"`
+	// Days groups records by the UTC date of their WARC-Date, as YYYY-MM-DD
+	Days map[string]*StatCount `json:"days"`
+
+	// Captures counts records with a payload
+	Captures int64 `json:"captures"`
+	// DuplicatePayloads counts revisit records & captures with a payload
+	// digest already seen
+	DuplicatePayloads int64 `json:"duplicate_payloads"`
+	// DuplicateRatio is the fraction of captures with a duplicate payload
+	DuplicateRatio float64 `json:"duplicate_ratio"`
+
+	// Truncated counts records with a WARC-Truncated field, by reason
+	Truncated map[string]int64 `json:"truncated"`
+
+	digests map[string]bool
+}
+
+// NewStats creates an empty Stats
+func NewStats() *Stats {
+	return &Stats{
+		Types:     map[string]*StatCount{},
+		MimeTypes: map[string]*StatCount{},
+		Statuses:  map[string]*StatCount{},
+		Hosts:     map[string]*StatCount{},
+		Days:      map[string]*StatCount{},
+		Truncated: map[string]int64{},
+		digests:   map[string]bool{},
+	}
+}
+
+// AddFile adds all records read from a WARC file
+func (s *Stats) AddFile(r io.Reader) error {
+	rdr, err := NewOffsetReader(r)
+	if err != nil {
+		return err
+	}
+	for {
+		rec, _, _, err := rdr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.Add(rec)
+	}
+}
+
+// Add a single record
+func (s *Stats) Add(rec *Record) {
+	size := int64(rec.ContentLength())
+	if rec.Content != nil {
+		size = int64(rec.Content.Len())
+	}
+	s.Records++
+	s.Bytes += size
+	countStat(s.Types, rec.Headers.Get(FieldNameWARCType), size)
+	if d := rec.Date(); !d.IsZero() {
+		countStat(s.Days, d.UTC().Format("2006-01-02"), size)
+	}
+	if t := rec.Headers.Get(FieldNameWARCTruncated); t != "" {
+		s.Truncated[t]++
+	}
+
+	switch rec.Type {
+	case RecordTypeResponse, RecordTypeResource, RecordTypeRevisit:
+	default:
+		return
+	}
+	mime, status := recordMimeStatus(rec)
+	countStat(s.MimeTypes, mime, size)
+	if status != 0 {
+		countStat(s.Statuses, strconv.Itoa(status), size)
+	}
+	if u, err := url.Parse(rec.TargetURI()); err == nil && u.Hostname() != "" {
+		countStat(s.Hosts, strings.ToLower(u.Hostname()), size)
+	}
+
+	s.Captures++
+	if rec.Type == RecordTypeRevisit {
+		s.DuplicatePayloads++
+	} else if rec.Content != nil {
+		digest := rec.Headers.Get(FieldNameWARCPayloadDigest)
+		if digest == "" {
+			digest = payloadDigest(rec)
+		}
+		if s.digests[digest] {
+			s.DuplicatePayloads++
+		}
+		s.digests[digest] = true
+	}
+	s.DuplicateRatio = float64(s.DuplicatePayloads) / float64(s.Captures)
+}
+
+func countStat(m map[string]*StatCount, key string, size int64) {
+	if key == "" {
+		key = "-"
+	}
+	c, ok := m[key]
+	if !ok {
+		c = &StatCount{}
+		m[key] = c
+	}
+	c.add(size)
+}
+
+// WriteTables writes stats as plain text tables, largest groups first.
+// Days are listed in date order
+func (s *Stats) WriteTables(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "records\t%d\t\n", s.Records)
+	fmt.Fprintf(tw, "bytes\t%d\t\n", s.Bytes)
+	fmt.Fprintf(tw, "captures\t%d\t\n", s.Captures)
+	fmt.Fprintf(tw, "duplicate payloads\t%d\t\n", s.DuplicatePayloads)
+	fmt.Fprintf(tw, "duplicate ratio\t%.4f\t\n", s.DuplicateRatio)
+	truncated := int64(0)
+	for _, n := range s.Truncated {
+		truncated += n
+	}
+	fmt.Fprintf(tw, "truncated\t%d\t\n", truncated)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	tables := []struct {
+		title  string
+		counts map[string]*StatCount
+		byKey  bool
+	}{
+		{"type", s.Types, false},
+		{"mime type", s.MimeTypes, false},
+		{"status", s.Statuses, false},
+		{"host", s.Hosts, false},
+		{"day", s.Days, true},
+	}
+	for _, t := range tables {
+		if len(t.counts) == 0 {
+			continue
+		}
+		keys := make([]string, 0, len(t.counts))
+		for k := range t.counts {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, b := t.counts[keys[i]], t.counts[keys[j]]
+			if t.byKey || a.Records == b.Records {
+				return keys[i] < keys[j]
+			}
+			return a.Records > b.Records
+		})
+
+		fmt.Fprintln(w)
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "%s\trecords\tbytes\n", strings.ToUpper(t.title))
+		for _, k := range keys {
+			fmt.Fprintf(tw, "%s\t%d\t%d\n", k, t.counts[k].Records, t.counts[k].Bytes)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
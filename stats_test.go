@@ -0,0 +1,66 @@
+package warc
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	s := NewStats()
+	for _, path := range []string{"testdata/warcio/example.warc.gz", "testdata/warcio/example-resource.warc.gz"} {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.AddFile(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+	}
+
+	counts := []struct {
+		name   string
+		counts map[string]*StatCount
+		key    string
+		expect int64
+	}{
+		{"types", s.Types, "warcinfo", 4},
+		{"types", s.Types, "request", 2},
+		{"types", s.Types, "revisit", 1},
+		{"types", s.Types, "resource", 1},
+		{"mime", s.MimeTypes, "text/html", 3},
+		{"status", s.Statuses, "200", 2},
+		{"host", s.Hosts, "example.com", 3},
+		{"day", s.Days, "2017-03-06", 6},
+		{"day", s.Days, "2017-04-29", 3},
+	}
+	for i, c := range counts {
+		got := int64(0)
+		if sc, ok := c.counts[c.key]; ok {
+			got = sc.Records
+		}
+		if got != c.expect {
+			t.Errorf("case %d %s[%s] mismatch. expected: %d, got: %d", i, c.name, c.key, c.expect, got)
+		}
+	}
+
+	if s.Records != 9 {
+		t.Errorf("records mismatch. expected: %d, got: %d", 9, s.Records)
+	}
+	if s.Captures != 3 || s.DuplicatePayloads != 1 {
+		t.Errorf("duplicates mismatch. expected: 1 of 3, got: %d of %d", s.DuplicatePayloads, s.Captures)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := s.WriteTables(buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"duplicate ratio     0.3333", "TYPE      records  bytes", "example.com"} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("expected tables to contain '%s', got:\n%s", line, buf.String())
+		}
+	}
+}
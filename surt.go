@@ -0,0 +1,67 @@
+package warc
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SURT converts a URL to Sort-friendly URI Reordering Transform form, the key
+// format used by CDX indexes. Hosts are reversed and comma-separated, "www"
+// prefixes, default ports & fragments are dropped, query params are sorted,
+// and the whole thing is lowercased, so all of these:
+//
+//	http://www.example.com/Path?b=2&a=1
+//	https://example.com:443/path?a=1&b=2#frag
+//
+// become:
+//
+//	com,example)/path?a=1&b=2
+func SURT(rawurl string) (string, error) {
+	if !strings.Contains(rawurl, "://") {
+		if i := strings.IndexByte(rawurl, ':'); i > 0 && !strings.ContainsAny(rawurl[:i], "./") {
+			// non-hierarchical uri like dns:example.com
+			return strings.ToLower(rawurl), nil
+		}
+		rawurl = "http://" + rawurl
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+
+	host := strings.ToLower(u.Hostname())
+	host = strings.Trim(host, ".")
+	host = wwwPrefix.ReplaceAllString(host, "")
+	parts := strings.Split(host, ".")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	key := strings.Join(parts, ",")
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		key += ":" + port
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	key += ")" + path
+
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		sort.Strings(params)
+		key += "?" + strings.Join(params, "&")
+	}
+
+	return strings.ToLower(key), nil
+}
+
+var wwwPrefix = regexp.MustCompile(`^www\d*\.`)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
@@ -0,0 +1,30 @@
+package warc
+
+import (
+	"testing"
+)
+
+func TestSURT(t *testing.T) {
+	cases := []struct {
+		in, expect string
+	}{
+		{"http://example.com", "com,example)/"},
+		{"http://www.example.com/", "com,example)/"},
+		{"https://WWW2.Example.com:443/Path?b=2&a=1#frag", "com,example)/path?a=1&b=2"},
+		{"http://example.com:8080/", "com,example:8080)/"},
+		{"example.com/a/b", "com,example)/a/b"},
+		{"http://sub.domain.example.co.uk/", "uk,co,example,domain,sub)/"},
+		{"dns:Example.com", "dns:example.com"},
+	}
+
+	for i, c := range cases {
+		got, err := SURT(c.in)
+		if err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		if got != c.expect {
+			t.Errorf("case %d mismatch. expected: '%s', got: '%s'", i, c.expect, got)
+		}
+	}
+}
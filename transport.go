@@ -0,0 +1,230 @@
+package warc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RecordingTransport is an http.RoundTripper that records the requests &
+// responses it carries as request/response record pairs, making any
+// http.Client an archiving client:
+//
+//	client := &http.Client{Transport: warc.NewRecordingTransport(w, nil)}
+//
+// A pair is written once the response body has been read to EOF or closed.
+// Response bodies are recorded up to MaxBodySize bytes, records of longer
+// bodies are truncated and marked with a WARC-Truncated field of "length".
+// Closing a body before reading all of it reads the remainder first, up to
+// the size limit and for at most DrainTimeout, so archived responses are
+// complete where possible. Records cut short by the timeout are marked with
+// a WARC-Truncated field of "time". Errors writing records are returned by
+// the body's Close method. Remote IP addresses are recorded for transports
+// that report connections to net/http/httptrace, like http.Transport.
+//
+// A RecordingTransport is safe for concurrent use
+type RecordingTransport struct {
+	// Transport makes requests, http.DefaultTransport if nil
+	Transport http.RoundTripper
+	// WarcinfoID is set as the WARC-Warcinfo-ID of written records if not
+	// empty
+	WarcinfoID string
+	// RecordTLS writes a metadata record of the TLS session details of each
+	// HTTPS response, see NewTLSMetadataRecord
+	RecordTLS bool
+	// MaxBodySize limits the bytes of each response body recorded,
+	// DefaultMaxCaptureBody if 0
+	MaxBodySize int64
+	// DrainTimeout limits the time Close spends reading the rest of a body,
+	// DefaultDrainTimeout if 0
+	DrainTimeout time.Duration
+
+	mu sync.Mutex
+	w  *Writer
+}
+
+// DefaultDrainTimeout is the default limit on the time closing a response
+// body of a RecordingTransport spends reading the rest of it
+const DefaultDrainTimeout = 5 * time.Second
+
+// NewRecordingTransport creates a RecordingTransport writing to w, making
+// requests with rt
+func NewRecordingTransport(w *Writer, rt http.RoundTripper) *RecordingTransport {
+	return &RecordingTransport{Transport: rt, w: w}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt := t.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	c := &roundTripCapture{
+		t:       t,
+		start:   time.Now(),
+		info:    CaptureHelper{WarcinfoID: t.WarcinfoID},
+		reqBody: &bytes.Buffer{},
+		resBody: &limitedBuffer{max: t.MaxBodySize},
+	}
+	if c.resBody.max == 0 {
+		c.resBody.max = DefaultMaxCaptureBody
+	}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(ci httptrace.GotConnInfo) {
+			c.info.RemoteAddr = ci.Conn.RemoteAddr().String()
+		},
+	}
+	c.req = req.Clone(httptrace.WithClientTrace(req.Context(), trace))
+	if req.Body != nil && req.Body != http.NoBody {
+		// tee the request body as it's sent, starting over if the transport
+		// rewinds it to retry
+		c.req.Body = teeReadCloser{io.TeeReader(req.Body, c.reqBody), req.Body}
+		if req.GetBody != nil {
+			c.req.GetBody = func() (io.ReadCloser, error) {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				c.reqBody.Reset()
+				return teeReadCloser{io.TeeReader(body, c.reqBody), body}, nil
+			}
+		}
+	}
+
+	res, err := rt.RoundTrip(c.req)
+	if err != nil {
+		return nil, err
+	}
+	c.res = res
+	c.body = res.Body
+	res.Body = c
+	return res, nil
+}
+
+func (t *RecordingTransport) writeRecords(recs ...*Record) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, rec := range recs {
+		if _, _, err := t.w.WriteRecord(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// roundTripCapture records a single request & response, standing in for
+// the response body
+type roundTripCapture struct {
+	t       *RecordingTransport
+	start   time.Time
+	info    CaptureHelper
+	req     *http.Request
+	reqBody *bytes.Buffer
+	res     *http.Response
+	body    io.ReadCloser
+	resBody *limitedBuffer
+
+	once      sync.Once
+	truncated string
+	err       error
+}
+
+func (c *roundTripCapture) Read(p []byte) (int, error) {
+	n, err := c.body.Read(p)
+	c.resBody.Write(p[:n])
+	if err == io.EOF {
+		c.once.Do(c.write)
+	}
+	return n, err
+}
+
+func (c *roundTripCapture) Close() error {
+	c.once.Do(func() {
+		if err := c.drain(); err != nil {
+			c.err = err
+			return
+		}
+		c.write()
+	})
+	if err := c.body.Close(); err != nil && c.err == nil {
+		return err
+	}
+	return c.err
+}
+
+// drain reads the rest of the body to record it, stopping past the size
+// limit or once the drain timeout passes
+func (c *roundTripCapture) drain() error {
+	if c.resBody.truncated {
+		return nil
+	}
+	timeout := c.t.DrainTimeout
+	if timeout == 0 {
+		timeout = DefaultDrainTimeout
+	}
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		// interrupt a blocked read
+		c.body.Close()
+	})
+	defer timer.Stop()
+
+	// read past the limit to detect truncation
+	_, err := io.CopyN(c.resBody, c.body, c.resBody.max-int64(c.resBody.buf.Len())+1)
+	switch {
+	case timedOut.Load():
+		c.truncated = "time"
+		return nil
+	case err == io.EOF:
+		return nil
+	}
+	return err
+}
+
+// write creates & writes the record pair once the response is read
+func (c *roundTripCapture) write() {
+	req := c.req.WithContext(context.Background())
+	req.GetBody = nil
+	req.Body = ioutil.NopCloser(c.reqBody)
+	c.info.ReqBodyBytesBuffer = c.reqBody
+	res := *c.res
+	res.Body = ioutil.NopCloser(&c.resBody.buf)
+
+	reqRec, resRec, err := NewRequestResponseRecords(c.info, req, &res)
+	if err != nil {
+		c.err = err
+		return
+	}
+	date := c.start.UTC().Format(TimeFormat)
+	reqRec.Headers.Set(FieldNameWARCDate, date)
+	resRec.Headers.Set(FieldNameWARCDate, date)
+	if c.resBody.truncated {
+		resRec.Headers.Set(FieldNameWARCTruncated, "length")
+	} else if c.truncated != "" {
+		resRec.Headers.Set(FieldNameWARCTruncated, c.truncated)
+	}
+	recs := []*Record{&reqRec, &resRec}
+	if c.t.RecordTLS && c.res.TLS != nil {
+		c.info.TLSState = c.res.TLS
+		md, err := NewTLSMetadataRecord(c.info, resRec)
+		if err != nil {
+			c.err = err
+			return
+		}
+		recs = append(recs, &md)
+	}
+	c.err = c.t.writeRecords(recs...)
+}
+
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,256 @@
+package warc
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecordingTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+	}))
+	defer srv.Close()
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := NewRecordingTransport(w, nil)
+	rt.WarcinfoID = NewUUID()
+	client := &http.Client{Transport: rt}
+
+	cases := []struct {
+		method, path, body string
+		readAll            bool
+		expect             string
+	}{
+		{"GET", "/a", "", true, "GET /a "},
+		{"POST", "/b", "name=value", true, "POST /b name=value"},
+		{"PUT", "/c", strings.Repeat("body ", 1000), false, "PUT /c " + strings.Repeat("body ", 1000)},
+	}
+	for i, c := range cases {
+		req, err := http.NewRequest(c.method, srv.URL+c.path, strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.readAll {
+			body, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != c.expect {
+				t.Errorf("case %d body mismatch. expected: %q, got: %q", i, c.expect, body)
+			}
+		}
+		if err := res.Body.Close(); err != nil {
+			t.Errorf("case %d unexpected close error: %s", i, err)
+		}
+	}
+
+	records := readTestRecordsFrom(t, buf)
+	if len(records) != len(cases)*2 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", len(cases)*2, len(records))
+	}
+	for i, c := range cases {
+		req, res := records[i*2], records[i*2+1]
+		if req.Type != RecordTypeRequest || res.Type != RecordTypeResponse {
+			t.Errorf("case %d type mismatch. got: %s, %s", i, req.Type, res.Type)
+		}
+		if res.Headers.Get(FieldNameWARCConcurrentTo) != req.Headers.Get(FieldNameWARCRecordID) {
+			t.Errorf("case %d expected response to be concurrent to request", i)
+		}
+		for _, rec := range []*Record{req, res} {
+			if rec.TargetURI() != srv.URL+c.path {
+				t.Errorf("case %d target uri mismatch. expected: %s, got: %s", i, srv.URL+c.path, rec.TargetURI())
+			}
+			if rec.Headers.Get(FieldNameWARCIPAddress) != "127.0.0.1" {
+				t.Errorf("case %d ip address mismatch. expected: 127.0.0.1, got: %s", i, rec.Headers.Get(FieldNameWARCIPAddress))
+			}
+			if rec.Headers.Get(FieldNameWARCWarcinfoID) != rt.WarcinfoID {
+				t.Errorf("case %d expected warcinfo id", i)
+			}
+		}
+		if req.Headers.Get(FieldNameWARCPayloadDigest) != Sha1Digest([]byte(c.body)) {
+			t.Errorf("case %d request payload digest mismatch", i)
+		}
+		if res.Headers.Get(FieldNameWARCPayloadDigest) != Sha1Digest([]byte(c.expect)) {
+			t.Errorf("case %d response payload digest mismatch", i)
+		}
+		hr, err := res.HTTPResponse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(hr.Body)
+		if string(body) != c.expect {
+			t.Errorf("case %d recorded body mismatch. expected: %q, got: %q", i, c.expect, body)
+		}
+	}
+}
+
+func TestRecordingTransportConcurrent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: NewRecordingTransport(w, nil)}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := client.Get(fmt.Sprintf("%s/%d", srv.URL, i))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ioutil.ReadAll(res.Body)
+			res.Body.Close()
+		}(i)
+	}
+	wg.Wait()
+
+	records := readTestRecordsFrom(t, buf)
+	if len(records) != 40 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", 40, len(records))
+	}
+	for i := 0; i < len(records); i += 2 {
+		if records[i+1].Headers.Get(FieldNameWARCConcurrentTo) != records[i].Headers.Get(FieldNameWARCRecordID) {
+			t.Errorf("record %d expected request & response to be written together", i)
+		}
+	}
+}
+
+func TestRecordingTransportTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secure")
+	}))
+	defer srv.Close()
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := NewRecordingTransport(w, srv.Client().Transport)
+	rt.RecordTLS = true
+	res, err := (&http.Client{Transport: rt}).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	records := readTestRecordsFrom(t, buf)
+	if len(records) != 3 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", 3, len(records))
+	}
+	md := records[2]
+	if md.Type != RecordTypeMetadata || md.Headers.Get(FieldNameWARCConcurrentTo) != records[1].Headers.Get(FieldNameWARCRecordID) {
+		t.Errorf("expected metadata record concurrent to response, got: %s", md.Type)
+	}
+	if !strings.Contains(md.Content.String(), "peerCertificate: ") {
+		t.Errorf("expected peer certificate, got: %q", md.Content.String())
+	}
+}
+
+func TestRecordingTransportLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/short":
+			w.Write([]byte(strings.Repeat("s", 50)))
+		case "/long":
+			w.Write([]byte(strings.Repeat("l", 500)))
+		default:
+			// an endless stream
+			for {
+				if _, err := w.Write([]byte("0123456789")); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		path      string
+		maxSize   int64
+		readAll   bool
+		read      int
+		truncated string
+		size      int
+	}{
+		{"/short", 100, false, 0, "", 50},
+		{"/long", 100, true, 500, "length", 100},
+		{"/endless", 100, false, 10, "length", 100},
+		{"/endless", 1 << 20, false, 10, "time", -1},
+	}
+	for i, c := range cases {
+		buf := &bytes.Buffer{}
+		w, err := NewWriterRaw(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rt := NewRecordingTransport(w, nil)
+		rt.MaxBodySize = c.maxSize
+		rt.DrainTimeout = 50 * time.Millisecond
+		res, err := (&http.Client{Transport: rt}).Get(srv.URL + c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.readAll {
+			body, _ := ioutil.ReadAll(res.Body)
+			if len(body) != c.read {
+				t.Errorf("case %d read size mismatch. expected: %d, got: %d", i, c.read, len(body))
+			}
+		} else if c.read > 0 {
+			res.Body.Read(make([]byte, c.read))
+		}
+		start := time.Now()
+		if err := res.Body.Close(); err != nil {
+			t.Errorf("case %d unexpected close error: %s", i, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("case %d close took too long: %s", i, elapsed)
+		}
+
+		records := readTestRecordsFrom(t, buf)
+		if len(records) != 2 {
+			t.Fatalf("case %d record count mismatch. expected: %d, got: %d", i, 2, len(records))
+		}
+		rec := records[1]
+		if got := rec.Headers.Get(FieldNameWARCTruncated); got != c.truncated {
+			t.Errorf("case %d truncated mismatch. expected: %q, got: %q", i, c.truncated, got)
+		}
+		body := rec.Content.Bytes()
+		body = body[bytes.Index(body, []byte("\r\n\r\n"))+4:]
+		if c.size >= 0 && len(body) != c.size {
+			t.Errorf("case %d recorded size mismatch. expected: %d, got: %d", i, c.size, len(body))
+		}
+		if got := rec.Headers.Get(FieldNameWARCPayloadDigest); got != Sha1Digest(body) {
+			t.Errorf("case %d payload digest mismatch. expected: %s, got: %s", i, Sha1Digest(body), got)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package warc
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httputil"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Severity ranks the importance of a validation issue
+type Severity string
+
+const (
+	// SeverityError is a violation of a "shall" requirement of the WARC spec
+	SeverityError Severity = "error"
+	// SeverityWarning is a violation of a "should" recommendation of the WARC
+	// spec, or something likely to trip up other tools
+	SeverityWarning Severity = "warning"
+)
+
+// ValidationIssue is a single problem found by Validate
+type ValidationIssue struct {
+	// Offset of the record in the file
+	Offset   int64    `json:"offset"`
+	RecordID string   `json:"record_id,omitempty"`
+	Severity Severity `json:"severity"`
+	// Field is the named field the issue concerns, if any
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// String formats the issue for display
+func (i ValidationIssue) String() string {
+	if i.Field != "" {
+		return fmt.Sprintf("%d: %s: %s: %s", i.Offset, i.Severity, i.Field, i.Message)
+	}
+	return fmt.Sprintf("%d: %s: %s", i.Offset, i.Severity, i.Message)
+}
+
+// ValidationReport summarizes the results of validating a WARC file
+type ValidationReport struct {
+	Filename string            `json:"filename,omitempty"`
+	Records  int               `json:"records"`
+	Errors   int               `json:"errors"`
+	Warnings int               `json:"warnings"`
+	Issues   []ValidationIssue `json:"issues"`
+}
+
+// Valid is true if the report contains no errors
+func (r *ValidationReport) Valid() bool {
+	return r.Errors == 0
+}
+
+func (r *ValidationReport) add(issues ...ValidationIssue) {
+	for _, i := range issues {
+		switch i.Severity {
+		case SeverityError:
+			r.Errors++
+		case SeverityWarning:
+			r.Warnings++
+		}
+		r.Issues = append(r.Issues, i)
+	}
+}
+
+// Validate checks each record read from r against the rules of ISO 28500.
+// Problems with the file are reported as issues, Validate only returns an
+// error if r cannot be read. Reading stops at the first record that cannot
+// be parsed, as the position of following records is unknown.
+func Validate(r io.Reader) (*ValidationReport, error) {
+	report := &ValidationReport{Issues: []ValidationIssue{}}
+	rdr, err := NewOffsetReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var prevEnd int64
+	for {
+		rec, start, end, err := rdr.Read()
+		if err == io.EOF {
+			return report, nil
+		}
+		if err != nil {
+			report.add(ValidationIssue{
+				Offset:   prevEnd,
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("unreadable record: %s", err),
+			})
+			return report, nil
+		}
+		report.Records++
+
+		issues := ValidateRecord(rec)
+		if !rdr.terminated {
+			issues = append(issues, ValidationIssue{
+				Severity: SeverityError,
+				Field:    FieldNameContentLength,
+				Message:  "record block isn't followed by 2xCRLF, Content-Length may not match the block length",
+			})
+		}
+		if rdr.compr == compressionGZIP && end < 0 {
+			issues = append(issues, ValidationIssue{
+				Severity: SeverityWarning,
+				Message:  "record isn't in its own gzip member, records should be compressed individually",
+			})
+		}
+		for i := range issues {
+			issues[i].Offset = start
+			issues[i].RecordID = rec.Headers.Get(FieldNameWARCRecordID)
+		}
+		report.add(issues...)
+		if end > 0 {
+			prevEnd = end
+		}
+	}
+}
+
+var (
+	recordIDPattern = regexp.MustCompile(`^<[a-zA-Z][a-zA-Z0-9+.-]*:[^\s<>]+>$`)
+	warcDatePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d{1,9})?Z$`)
+)
+
+// targetURITypes are record types where WARC-Target-URI is mandatory
+var targetURITypes = map[RecordType]bool{
+	RecordTypeResponse:     true,
+	RecordTypeResource:     true,
+	RecordTypeRequest:      true,
+	RecordTypeRevisit:      true,
+	RecordTypeConversion:   true,
+	RecordTypeContinuation: true,
+}
+
+// fieldAllowedTypes restricts named fields to the listed record types
+var fieldAllowedTypes = []struct {
+	field string
+	types []RecordType
+}{
+	{FieldNameWARCConcurrentTo, []RecordType{RecordTypeRequest, RecordTypeResponse, RecordTypeResource, RecordTypeMetadata, RecordTypeRevisit}},
+	{FieldNameWARCRefersTo, []RecordType{RecordTypeMetadata, RecordTypeRevisit, RecordTypeConversion}},
+	{FieldNameWARCFilename, []RecordType{RecordTypeWarcInfo}},
+	{FieldNameWARCProfile, []RecordType{RecordTypeRevisit}},
+	{FieldNameWARCSegmentOriginID, []RecordType{RecordTypeContinuation}},
+	{FieldNameWARCSegmentTotalLength, []RecordType{RecordTypeContinuation}},
+}
+
+// ValidateRecord checks a single record against the rules of ISO 28500,
+// returning any issues found. Issue offsets are not set.
+func ValidateRecord(rec *Record) (issues []ValidationIssue) {
+	add := func(sev Severity, field, format string, args ...interface{}) {
+		issues = append(issues, ValidationIssue{Severity: sev, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	h := rec.Headers
+
+	// fields mandatory for all records
+	for _, field := range []string{FieldNameWARCRecordID, FieldNameContentLength, FieldNameWARCDate, FieldNameWARCType} {
+		if h.Get(field) == "" {
+			add(SeverityError, field, "missing mandatory field")
+		}
+	}
+	if id := h.Get(FieldNameWARCRecordID); id != "" && !recordIDPattern.MatchString(id) {
+		add(SeverityError, FieldNameWARCRecordID, "malformed record id '%s', must be a URI enclosed in <>", id)
+	}
+	if date := h.Get(FieldNameWARCDate); date != "" {
+		if _, err := time.Parse(time.RFC3339Nano, date); err != nil || !warcDatePattern.MatchString(date) {
+			add(SeverityError, FieldNameWARCDate, "malformed date '%s', must be formatted YYYY-MM-DDThh:mm:ssZ", date)
+		}
+	}
+	if t := h.Get(FieldNameWARCType); t != "" && rec.Type == RecordTypeUnknown {
+		add(SeverityWarning, FieldNameWARCType, "unknown record type '%s'", t)
+	}
+	if cl := h.Get(FieldNameContentLength); cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			add(SeverityError, FieldNameContentLength, "invalid length '%s'", cl)
+		} else if rec.Content != nil && n != int64(rec.Content.Len()) {
+			add(SeverityError, FieldNameContentLength, "length %d doesn't match block length %d", n, rec.Content.Len())
+		} else if n > 0 && h.Get(FieldNameContentType) == "" {
+			add(SeverityWarning, FieldNameContentType, "records with a block should have a Content-Type")
+		}
+	}
+
+	// fields mandatory by record type
+	if targetURITypes[rec.Type] && h.Get(FieldNameWARCTargetURI) == "" {
+		add(SeverityError, FieldNameWARCTargetURI, "missing field, mandatory for %s records", rec.Type)
+	}
+	switch rec.Type {
+	case RecordTypeRevisit:
+		if h.Get(FieldNameWARCProfile) == "" {
+			add(SeverityError, FieldNameWARCProfile, "missing field, mandatory for revisit records")
+		}
+	case RecordTypeContinuation:
+		for _, field := range []string{FieldNameWARCSegmentOriginID, FieldNameWARCSegmentNumber} {
+			if h.Get(field) == "" {
+				add(SeverityError, field, "missing field, mandatory for continuation records")
+			}
+		}
+	}
+
+	// fields restricted to some record types
+	if rec.Type != RecordTypeUnknown {
+		for _, f := range fieldAllowedTypes {
+			if h.Get(f.field) != "" && !hasType(f.types, rec.Type) {
+				add(SeverityError, f.field, "field shall not be used in %s records", rec.Type)
+			}
+		}
+	}
+
+	// field values
+	for _, field := range []string{FieldNameWARCConcurrentTo, FieldNameWARCRefersTo, FieldNameWARCWarcinfoID, FieldNameWARCSegmentOriginID} {
+		if v := h.Get(field); v != "" && !recordIDPattern.MatchString(v) {
+			add(SeverityError, field, "malformed record id '%s', must be a URI enclosed in <>", v)
+		}
+	}
+	if uri := h.Get(FieldNameWARCTargetURI); strings.ContainsAny(uri, " \t\r\n") {
+		add(SeverityError, FieldNameWARCTargetURI, "uri shall not contain whitespace")
+	}
+	if ip := h.Get(FieldNameWARCIPAddress); ip != "" && net.ParseIP(ip) == nil {
+		add(SeverityError, FieldNameWARCIPAddress, "invalid ip address '%s'", ip)
+	}
+	if n := h.Get(FieldNameWARCSegmentNumber); n != "" {
+		num, err := strconv.Atoi(n)
+		if err != nil || num < 1 {
+			add(SeverityError, FieldNameWARCSegmentNumber, "invalid segment number '%s'", n)
+		} else if rec.Type == RecordTypeContinuation && num < 2 {
+			add(SeverityError, FieldNameWARCSegmentNumber, "continuation records must have a segment number greater than 1")
+		}
+	}
+	switch t := h.Get(FieldNameWARCTruncated); t {
+	case "", "length", "time", "disconnect", "unspecified":
+	default:
+		add(SeverityWarning, FieldNameWARCTruncated, "unknown truncation reason '%s'", t)
+	}
+
+	// digests
+	if rec.Content != nil {
+		block := rec.Content.Bytes()
+		if d := h.Get(FieldNameWARCBlockDigest); d != "" {
+			if ok, err := checkDigest(d, block); err != nil {
+				add(SeverityWarning, FieldNameWARCBlockDigest, "%s", err)
+			} else if !ok {
+				add(SeverityError, FieldNameWARCBlockDigest, "digest doesn't match record block")
+			}
+		}
+		if d := h.Get(FieldNameWARCPayloadDigest); d != "" && rec.Type != RecordTypeRevisit && h.Get(FieldNameWARCSegmentNumber) == "" {
+			if ok, err := checkPayloadDigest(d, rec); err != nil {
+				add(SeverityWarning, FieldNameWARCPayloadDigest, "%s", err)
+			} else if !ok {
+				add(SeverityError, FieldNameWARCPayloadDigest, "digest doesn't match record payload")
+			}
+		}
+	}
+	return issues
+}
+
+func hasType(types []RecordType, t RecordType) bool {
+	for _, tt := range types {
+		if tt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPayloadDigest compares a digest to a record's payload. For HTTP
+// messages both the raw and de-chunked entity body are accepted, as tools
+// disagree on which to digest
+func checkPayloadDigest(digest string, rec *Record) (bool, error) {
+	payload := rec.Content.Bytes()
+	if !strings.HasPrefix(rec.Headers.Get(FieldNameContentType), "application/http") {
+		return checkDigest(digest, payload)
+	}
+	i := bytes.Index(payload, doubleCrlf)
+	if i < 0 {
+		return checkDigest(digest, nil)
+	}
+	headers, payload := payload[:i], payload[i+len(doubleCrlf):]
+	if ok, err := checkDigest(digest, payload); ok || err != nil {
+		return ok, err
+	}
+	if bytes.Contains(bytes.ToLower(headers), []byte("transfer-encoding: chunked")) {
+		if dechunked, err := ioutil.ReadAll(httputil.NewChunkedReader(bytes.NewReader(payload))); err == nil {
+			return checkDigest(digest, dechunked)
+		}
+	}
+	return false, nil
+}
+
+// checkDigest compares a labelled digest value (eg: sha1:AB2CD3...) to the
+// digest of data. Values may be base32 or hex encoded
+func checkDigest(digest string, data []byte) (bool, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 {
+		return false, fmt.Errorf("malformed digest '%s', expected algorithm:value", digest)
+	}
+	var h hash.Hash
+	switch strings.ToLower(strings.Replace(parts[0], "-", "", -1)) {
+	case "sha1":
+		h = sha1.New()
+	case "md5":
+		h = md5.New()
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return false, fmt.Errorf("unsupported digest algorithm '%s'", parts[0])
+	}
+	h.Write(data)
+	sum := h.Sum(nil)
+
+	value := strings.TrimRight(strings.ToUpper(parts[1]), "=")
+	b32 := strings.TrimRight(base32.StdEncoding.EncodeToString(sum), "=")
+	return value == b32 || value == strings.ToUpper(hex.EncodeToString(sum)), nil
+}
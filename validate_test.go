@@ -0,0 +1,116 @@
+package warc
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		path             string
+		records, errors  int
+		warnings         int
+		firstIssueOffset int64
+	}{
+		{"testdata/warcio/example-iana.org-chunked.warc", 3, 0, 0, -1},
+		{"testdata/warcio/example-resource.warc.gz", 3, 0, 0, -1},
+		{"testdata/warcio/post-test.warc.gz", 6, 0, 0, -1},
+		// truncated record, followed by a record that can't be read
+		{"testdata/warcio/example-trunc.warc", 3, 4, 0, 1197},
+		// all records in a single gzip member
+		{"testdata/warcio/example-bad.warc.gz.bad", 6, 0, 6, 0},
+	}
+
+	for i, c := range cases {
+		f, err := os.Open(c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := Validate(f)
+		f.Close()
+		if err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		if report.Records != c.records {
+			t.Errorf("case %d records mismatch. expected: %d, got: %d", i, c.records, report.Records)
+		}
+		if report.Errors != c.errors {
+			t.Errorf("case %d errors mismatch. expected: %d, got: %d", i, c.errors, report.Errors)
+		}
+		if report.Warnings != c.warnings {
+			t.Errorf("case %d warnings mismatch. expected: %d, got: %d", i, c.warnings, report.Warnings)
+		}
+		if report.Valid() != (c.errors == 0) {
+			t.Errorf("case %d valid mismatch. expected: %t, got: %t", i, c.errors == 0, report.Valid())
+		}
+		if c.firstIssueOffset >= 0 && len(report.Issues) > 0 && report.Issues[0].Offset != c.firstIssueOffset {
+			t.Errorf("case %d offset mismatch. expected: %d, got: %d", i, c.firstIssueOffset, report.Issues[0].Offset)
+		}
+	}
+}
+
+func TestValidateRecord(t *testing.T) {
+	block := []byte("hello")
+	valid := func(t RecordType, h map[string]string) *Record {
+		rec := &Record{
+			Format: RecordFormatWarc,
+			Type:   t,
+			Headers: Header{
+				FieldNameWARCRecordID:    "<urn:uuid:ba8d5f34-4ae4-4d11-b2ef-0a2a2ed1bc04>",
+				FieldNameWARCDate:        "2017-03-06T04:03:48Z",
+				FieldNameWARCType:        t.String(),
+				FieldNameContentType:     "text/plain",
+				FieldNameContentLength:   "5",
+				FieldNameWARCTargetURI:   "http://example.com/",
+				FieldNameWARCBlockDigest: Sha1Digest(block),
+			},
+			Content: bytes.NewBuffer(block),
+		}
+		for k, v := range h {
+			if v == "" {
+				delete(rec.Headers, k)
+			} else {
+				rec.Headers[k] = v
+			}
+		}
+		return rec
+	}
+
+	cases := []struct {
+		rec    *Record
+		fields []string
+	}{
+		{valid(RecordTypeResource, nil), nil},
+		{valid(RecordTypeResource, map[string]string{FieldNameWARCDate: "2017-03-06T04:03:48.123456Z"}), nil},
+		{valid(RecordTypeResource, map[string]string{FieldNameWARCBlockDigest: "md5:5d41402abc4b2a76b9719d911017c592"}), nil},
+		{valid(RecordTypeResource, map[string]string{FieldNameWARCRecordID: ""}), []string{FieldNameWARCRecordID}},
+		{valid(RecordTypeResource, map[string]string{FieldNameWARCRecordID: "urn:uuid:1234"}), []string{FieldNameWARCRecordID}},
+		{valid(RecordTypeResource, map[string]string{FieldNameWARCDate: "2017-03-06T04:03:48+02:00"}), []string{FieldNameWARCDate}},
+		{valid(RecordTypeResource, map[string]string{FieldNameContentLength: "4"}), []string{FieldNameContentLength}},
+		{valid(RecordTypeResource, map[string]string{FieldNameContentType: ""}), []string{FieldNameContentType}},
+		{valid(RecordTypeResource, map[string]string{FieldNameWARCTargetURI: ""}), []string{FieldNameWARCTargetURI}},
+		{valid(RecordTypeResource, map[string]string{FieldNameWARCBlockDigest: "sha1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}), []string{FieldNameWARCBlockDigest}},
+		{valid(RecordTypeResource, map[string]string{FieldNameWARCBlockDigest: "crc32:1234"}), []string{FieldNameWARCBlockDigest}},
+		{valid(RecordTypeResource, map[string]string{FieldNameWARCFilename: "a.warc"}), []string{FieldNameWARCFilename}},
+		{valid(RecordTypeResource, map[string]string{FieldNameWARCIPAddress: "256.0.0.1"}), []string{FieldNameWARCIPAddress}},
+		{valid(RecordTypeResource, map[string]string{FieldNameWARCTruncated: "because"}), []string{FieldNameWARCTruncated}},
+		{valid(RecordTypeRevisit, nil), []string{FieldNameWARCProfile}},
+		{valid(RecordTypeWarcInfo, map[string]string{FieldNameWARCTargetURI: "", FieldNameWARCConcurrentTo: "<urn:uuid:1234>"}), []string{FieldNameWARCConcurrentTo}},
+		{valid(RecordTypeContinuation, nil), []string{FieldNameWARCSegmentOriginID, FieldNameWARCSegmentNumber}},
+	}
+
+	for i, c := range cases {
+		issues := ValidateRecord(c.rec)
+		if len(issues) != len(c.fields) {
+			t.Errorf("case %d issue count mismatch. expected: %d, got: %d: %v", i, len(c.fields), len(issues), issues)
+			continue
+		}
+		for j, field := range c.fields {
+			if issues[j].Field != field {
+				t.Errorf("case %d issue %d field mismatch. expected: %s, got: %s", i, j, field, issues[j].Field)
+			}
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package warc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// WAT is the JSON metadata of a WARC record in the Web Archive
+// Transformation format popularized by Common Crawl: an envelope of WARC
+// headers, HTTP headers and, for HTML pages, head metadata & links.
+// See https://commoncrawl.org/the-data/get-started/#WAT-Format
+type WAT struct {
+	Container WATContainer `json:"Container"`
+	Envelope  WATEnvelope  `json:"Envelope"`
+}
+
+// WATContainer locates the described record
+type WATContainer struct {
+	Filename   string `json:"Filename"`
+	Compressed bool   `json:"Compressed"`
+	Offset     string `json:"Offset"`
+}
+
+// WATEnvelope describes a WARC record
+type WATEnvelope struct {
+	Format              string             `json:"Format"`
+	WARCHeaderLength    string             `json:"WARC-Header-Length"`
+	BlockDigest         string             `json:"Block-Digest,omitempty"`
+	ActualContentLength string             `json:"Actual-Content-Length"`
+	WARCHeaderMetadata  map[string]string  `json:"WARC-Header-Metadata"`
+	PayloadMetadata     WATPayloadMetadata `json:"Payload-Metadata"`
+}
+
+// WATPayloadMetadata describes a record block
+type WATPayloadMetadata struct {
+	ActualContentType    string                   `json:"Actual-Content-Type"`
+	HTTPResponseMetadata *WATHTTPResponseMetadata `json:"HTTP-Response-Metadata,omitempty"`
+}
+
+// WATHTTPResponseMetadata describes an HTTP response. The entity is the
+// response body as stored, with any transfer & content encoding intact
+type WATHTTPResponseMetadata struct {
+	ResponseMessage WATResponseMessage `json:"Response-Message"`
+	// Headers maps header names to values, repeated headers are joined
+	// with ", "
+	Headers       map[string]string `json:"Headers"`
+	HeadersLength string            `json:"Headers-Length"`
+	EntityLength  string            `json:"Entity-Length"`
+	EntityDigest  string            `json:"Entity-Digest"`
+	HTMLMetadata  *WATHTMLMetadata  `json:"HTML-Metadata,omitempty"`
+}
+
+// WATResponseMessage is an HTTP status line
+type WATResponseMessage struct {
+	Version string `json:"Version"`
+	Status  string `json:"Status"`
+	Reason  string `json:"Reason"`
+}
+
+// WATHTMLMetadata lists the metadata & links of an HTML page
+type WATHTMLMetadata struct {
+	Head  WATHead   `json:"Head"`
+	Links []WATLink `json:"Links,omitempty"`
+}
+
+// WATHead is the metadata in the <head> of an HTML page. Metas hold the
+// attributes of each <meta> tag
+type WATHead struct {
+	Title   string              `json:"Title,omitempty"`
+	Base    string              `json:"Base,omitempty"`
+	Metas   []map[string]string `json:"Metas,omitempty"`
+	Link    []WATLink           `json:"Link,omitempty"`
+	Scripts []WATLink           `json:"Scripts,omitempty"`
+}
+
+// WATLink is a url in an HTML page. Path names the element & attribute
+// the url came from, eg: "A@/href" or "IMG@/src". URLs are as written in
+// the page, relative urls are not resolved
+type WATLink struct {
+	Path   string `json:"path"`
+	URL    string `json:"url"`
+	Text   string `json:"text,omitempty"`
+	Alt    string `json:"alt,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Rel    string `json:"rel,omitempty"`
+	Type   string `json:"type,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+// WriteWAT writes a WAT metadata record for each response record in the
+// WARC file read from r, returning the number of records written. filename
+// is the name of the WARC file, recorded in each record's container
+func WriteWAT(w *Writer, r io.Reader, filename string) (int, error) {
+	rdr, err := NewOffsetReader(r)
+	if err != nil {
+		return 0, err
+	}
+	written := 0
+	for {
+		rec, start, _, err := rdr.Read()
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+		if rec.Type != RecordTypeResponse {
+			continue
+		}
+		md, err := NewWATRecord(rec, WATContainer{
+			Filename:   filename,
+			Compressed: rdr.compr == compressionGZIP,
+			Offset:     strconv.FormatInt(start, 10),
+		})
+		if err != nil {
+			return written, errors.Wrapf(err, "record %s", rec.Headers.Get(FieldNameWARCRecordID))
+		}
+		if _, _, err := w.WriteRecord(md); err != nil {
+			return written, err
+		}
+		written++
+	}
+}
+
+// NewWATRecord creates a metadata record holding the WAT JSON of rec, with
+// WARC-Refers-To set to rec's id
+func NewWATRecord(rec *Record, container WATContainer) (*Record, error) {
+	wat, err := NewWAT(rec, container)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(wat)
+	if err != nil {
+		return nil, err
+	}
+
+	md := &Record{Format: RecordFormatWarc, Type: RecordTypeMetadata, Headers: Header{}, Content: bytes.NewBuffer(data)}
+	md.Headers.Set(FieldNameWARCRecordID, NewUUID())
+	md.Headers.Set(FieldNameWARCRefersTo, rec.Headers.Get(FieldNameWARCRecordID))
+	md.Headers.Set(FieldNameWARCTargetURI, rec.TargetURI())
+	md.Headers.Set(FieldNameWARCDate, rec.Headers.Get(FieldNameWARCDate))
+	if md.Headers.Get(FieldNameWARCDate) == "" {
+		md.Headers.Set(FieldNameWARCDate, time.Now().UTC().Format(TimeFormat))
+	}
+	md.Headers.Set(FieldNameContentType, "application/json")
+	return md, nil
+}
+
+// NewWAT describes a record in WAT form. HTTP response metadata is included
+// for records holding an HTTP response, and HTML metadata for HTML responses
+// with bodies that can be decoded
+func NewWAT(rec *Record, container WATContainer) (*WAT, error) {
+	head := &bytes.Buffer{}
+	if err := writeHeader(head, rec); err != nil {
+		return nil, err
+	}
+	wat := &WAT{
+		Container: container,
+		Envelope: WATEnvelope{
+			Format:              "WARC",
+			WARCHeaderLength:    strconv.Itoa(head.Len()),
+			BlockDigest:         rec.Headers.Get(FieldNameWARCBlockDigest),
+			ActualContentLength: strconv.Itoa(rec.Content.Len()),
+			WARCHeaderMetadata:  map[string]string{},
+			PayloadMetadata: WATPayloadMetadata{
+				ActualContentType: rec.Headers.Get(FieldNameContentType),
+			},
+		},
+	}
+	for k, v := range rec.Headers {
+		wat.Envelope.WARCHeaderMetadata[k] = v
+	}
+
+	if rec.Type != RecordTypeResponse || !strings.HasPrefix(rec.TargetURI(), "http") {
+		return wat, nil
+	}
+	res, err := rec.HTTPResponse()
+	if err != nil {
+		// not an HTTP response
+		return wat, nil
+	}
+	defer res.Body.Close()
+
+	headers, headersLength := rawHARHeaders(rec.Content.Bytes())
+	md := &WATHTTPResponseMetadata{
+		ResponseMessage: WATResponseMessage{
+			Version: res.Proto,
+			Status:  strconv.Itoa(res.StatusCode),
+			Reason:  strings.TrimSpace(strings.TrimPrefix(res.Status, strconv.Itoa(res.StatusCode))),
+		},
+		Headers:       map[string]string{},
+		HeadersLength: strconv.FormatInt(headersLength, 10),
+		EntityLength:  strconv.FormatInt(int64(rec.Content.Len())-headersLength, 10),
+		EntityDigest:  payloadDigest(rec),
+	}
+	for _, h := range headers {
+		if v, ok := md.Headers[h.Name]; ok {
+			h.Value = v + ", " + h.Value
+		}
+		md.Headers[h.Name] = h.Value
+	}
+	wat.Envelope.PayloadMetadata.HTTPResponseMetadata = md
+
+	if mediaType(res.Header.Get("Content-Type")) == "text/html" {
+		// bodies that can't be decoded, eg: br content, are described
+		// without HTML metadata
+		if body, err := decodedHTMLBody(res); err == nil {
+			if hmd, err := watHTMLMetadata(body); err == nil {
+				md.HTMLMetadata = hmd
+			}
+		}
+	}
+	return wat, nil
+}
+
+// decodedHTMLBody removes any content-encoding from the body of res, and
+// converts it to UTF-8 using the charset given in the Content-Type header
+// or <meta> tags
+func decodedHTMLBody(res *http.Response) (io.Reader, error) {
+	rc, err := DecodeContentEncoding(res)
+	if err != nil {
+		return nil, err
+	}
+	body, err := charset.NewReader(rc, res.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errors.Wrap(err, "warc: decoding charset")
+	}
+	return body, nil
+}
+
+// watLinkAttrs maps HTML elements to the attribute holding their url
+var watLinkAttrs = map[string]string{
+	"a":      "href",
+	"area":   "href",
+	"audio":  "src",
+	"embed":  "src",
+	"form":   "action",
+	"frame":  "src",
+	"iframe": "src",
+	"img":    "src",
+	"link":   "href",
+	"object": "data",
+	"script": "src",
+	"source": "src",
+	"track":  "src",
+	"video":  "src",
+}
+
+// watHTMLMetadata reads head metadata & links from an HTML page
+func watHTMLMetadata(r io.Reader) (*WATHTMLMetadata, error) {
+	md := &WATHTMLMetadata{}
+	z := html.NewTokenizer(r)
+	inHead, inTitle := true, false
+	anchor := -1 // index of the open <a> link, if any
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return md, nil
+			}
+			return md, z.Err()
+		case html.TextToken:
+			text := strings.Join(strings.Fields(string(z.Text())), " ")
+			switch {
+			case text == "":
+			case inTitle:
+				md.Head.Title = strings.TrimSpace(md.Head.Title + " " + text)
+			case anchor >= 0:
+				md.Links[anchor].Text = strings.TrimSpace(md.Links[anchor].Text + " " + text)
+			}
+		case html.EndTagToken:
+			switch name, _ := z.TagName(); string(name) {
+			case "head":
+				inHead = false
+			case "title":
+				inTitle = false
+			case "a":
+				anchor = -1
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			attrs := map[string]string{}
+			for _, a := range tok.Attr {
+				attrs[a.Key] = a.Val
+			}
+			switch tok.Data {
+			case "body":
+				inHead = false
+			case "title":
+				inTitle = inHead && tt == html.StartTagToken
+			case "base":
+				if inHead && attrs["href"] != "" {
+					md.Head.Base = attrs["href"]
+				}
+			case "meta":
+				if len(attrs) > 0 {
+					md.Head.Metas = append(md.Head.Metas, attrs)
+				}
+			}
+
+			attr, ok := watLinkAttrs[tok.Data]
+			if !ok || attrs[attr] == "" {
+				continue
+			}
+			link := WATLink{
+				Path:   strings.ToUpper(tok.Data) + "@/" + attr,
+				URL:    attrs[attr],
+				Alt:    attrs["alt"],
+				Title:  attrs["title"],
+				Rel:    attrs["rel"],
+				Type:   attrs["type"],
+				Target: attrs["target"],
+			}
+			switch {
+			case inHead && tok.Data == "link":
+				md.Head.Link = append(md.Head.Link, link)
+			case inHead && tok.Data == "script":
+				md.Head.Scripts = append(md.Head.Scripts, link)
+			default:
+				md.Links = append(md.Links, link)
+				if tok.Data == "a" && tt == html.StartTagToken {
+					anchor = len(md.Links) - 1
+				}
+			}
+		}
+	}
+}
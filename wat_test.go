@@ -0,0 +1,143 @@
+package warc
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestWriteWAT(t *testing.T) {
+	f, err := os.Open("testdata/warcio/example.warc.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := WriteWAT(w, f, "example.warc.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("record count mismatch. expected: %d, got: %d", 1, n)
+	}
+
+	records := readTestRecordsFrom(t, buf)
+	if len(records) != 1 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", 1, len(records))
+	}
+	rec := records[0]
+	if rec.Type != RecordTypeMetadata || rec.Headers.Get(FieldNameContentType) != "application/json" {
+		t.Errorf("expected json metadata record, got: %s %s", rec.Type, rec.Headers.Get(FieldNameContentType))
+	}
+	if rec.Headers.Get(FieldNameWARCRefersTo) != "<urn:uuid:a9c51e3e-0221-11e7-bf66-0242ac120005>" {
+		t.Errorf("refers to mismatch. got: %s", rec.Headers.Get(FieldNameWARCRefersTo))
+	}
+
+	wat := &WAT{}
+	if err := json.Unmarshal(rec.Content.Bytes(), wat); err != nil {
+		t.Fatal(err)
+	}
+	if wat.Container != (WATContainer{"example.warc.gz", true, "784"}) {
+		t.Errorf("container mismatch. got: %v", wat.Container)
+	}
+	if wat.Envelope.WARCHeaderMetadata[FieldNameWARCTargetURI] != "http://example.com/" {
+		t.Errorf("expected warc headers, got: %v", wat.Envelope.WARCHeaderMetadata)
+	}
+	md := wat.Envelope.PayloadMetadata.HTTPResponseMetadata
+	if md == nil {
+		t.Fatal("expected http response metadata")
+	}
+	if md.ResponseMessage != (WATResponseMessage{"HTTP/1.1", "200", "OK"}) {
+		t.Errorf("response message mismatch. got: %v", md.ResponseMessage)
+	}
+	if md.Headers["Content-Encoding"] != "gzip" || md.EntityLength != "606" {
+		t.Errorf("expected entity as stored, got: %v %s", md.Headers, md.EntityLength)
+	}
+	if md.HTMLMetadata == nil || md.HTMLMetadata.Head.Title != "Example Domain" {
+		t.Fatalf("expected html metadata, got: %v", md.HTMLMetadata)
+	}
+	expect := []WATLink{{Path: "A@/href", URL: "http://www.iana.org/domains/example", Text: "More information..."}}
+	if !reflect.DeepEqual(md.HTMLMetadata.Links, expect) {
+		t.Errorf("links mismatch. expected: %v, got: %v", expect, md.HTMLMetadata.Links)
+	}
+}
+
+func TestNewWAT(t *testing.T) {
+	page := "<html><head><meta charset=\"iso-8859-1\"><title>Caf\xe9</title>" +
+		"<base href=\"http://example.com/sub/\"><link rel=\"stylesheet\" href=\"a.css\"><script src=\"a.js\"></script></head>" +
+		"<body><a href=\"/one\" title=\"One\">first <b>link</b></a><img src=\"b.png\" alt=\"B\"><a name=\"anchor\">no href</a>" +
+		"<form action=\"/search\"></form><script src=\"c.js\"></script><iframe src=\"frame.html\"></iframe></body></html>"
+	records := readTestRecordsFrom(t, testResponseWARC(t, "2017-03-06T04:02:06Z", "http://example.com/", "200 OK", page))
+
+	wat, err := NewWAT(records[0], WATContainer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := wat.Envelope.PayloadMetadata.HTTPResponseMetadata.HTMLMetadata
+	head := WATHead{
+		Title:   "Café",
+		Base:    "http://example.com/sub/",
+		Metas:   []map[string]string{{"charset": "iso-8859-1"}},
+		Link:    []WATLink{{Path: "LINK@/href", URL: "a.css", Rel: "stylesheet"}},
+		Scripts: []WATLink{{Path: "SCRIPT@/src", URL: "a.js"}},
+	}
+	if !reflect.DeepEqual(md.Head, head) {
+		t.Errorf("head mismatch. expected: %v, got: %v", head, md.Head)
+	}
+
+	links := []WATLink{
+		{Path: "A@/href", URL: "/one", Text: "first link", Title: "One"},
+		{Path: "IMG@/src", URL: "b.png", Alt: "B"},
+		{Path: "FORM@/action", URL: "/search"},
+		{Path: "SCRIPT@/src", URL: "c.js"},
+		{Path: "IFRAME@/src", URL: "frame.html"},
+	}
+	if len(md.Links) != len(links) {
+		t.Fatalf("link count mismatch. expected: %d, got: %d: %v", len(links), len(md.Links), md.Links)
+	}
+	for i, l := range links {
+		if md.Links[i] != l {
+			t.Errorf("case %d link mismatch. expected: %v, got: %v", i, l, md.Links[i])
+		}
+	}
+}
+
+func TestWriteWATUndecodable(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := WriteWAT(w, testRecordsWARC(t,
+		testEncodedResponse("http://example.com/a", "br", "\x8b\x03\x80<a href=\"/x\">"),
+		testEncodedResponse("http://example.com/b", "identity", "<a href=\"/y\">"),
+	), "test.warc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", 2, n)
+	}
+
+	records := readTestRecordsFrom(t, buf)
+	for i, html := range []bool{false, true} {
+		wat := &WAT{}
+		if err := json.Unmarshal(records[i].Content.Bytes(), wat); err != nil {
+			t.Fatal(err)
+		}
+		md := wat.Envelope.PayloadMetadata.HTTPResponseMetadata
+		if md == nil || md.Headers["Content-Type"] != "text/html" {
+			t.Errorf("case %d expected http headers, got: %v", i, md)
+			continue
+		}
+		if (md.HTMLMetadata != nil) != html {
+			t.Errorf("case %d html metadata mismatch. expected: %t, got: %v", i, html, md.HTMLMetadata)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// WebSocketMessageContentType is the content type of resource records of
+// WebSocket messages recorded by RawCapture. It's given opcode ("text" or
+// "binary") & direction ("client" for messages sent, "server" for those
+// received) parameters:
+//
+//	application/x-websocket-message; opcode=text; direction=server
+const WebSocketMessageContentType = "application/x-websocket-message"
+
+// MaxWebSocketMessage limits the size in bytes of WebSocket messages
+// recorded by RawCapture. Larger messages are recorded up to the limit, with
+// a WARC-Truncated field of "length"
+const MaxWebSocketMessage = 32 << 20
+
+// wsTimeFormat is the WARC-Date format of WebSocket records, with the
+// fractional seconds allowed by WARC 1.1 to order messages. The records are
+// written as WARC/1.1 records
+const wsTimeFormat = "2006-01-02T15:04:05.000000Z"
+
+// WebSocket opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+var wsOpcodeNames = map[byte]string{
+	wsText:   "text",
+	wsBinary: "binary",
+	wsClose:  "close",
+	wsPing:   "ping",
+	wsPong:   "pong",
+}
+
+// wsFrame is a single WebSocket frame, with its payload unmasked. Payloads
+// are kept up to MaxWebSocketMessage bytes, truncated is true if cut
+type wsFrame struct {
+	fin        bool
+	compressed bool
+	truncated  bool
+	opcode     byte
+	payload    []byte
+}
+
+// readWebSocketFrame reads a frame from r
+func readWebSocketFrame(r io.Reader) (*wsFrame, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	f := &wsFrame{
+		fin:        hdr[0]&0x80 != 0,
+		compressed: hdr[0]&0x40 != 0,
+		opcode:     hdr[0] & 0x0f,
+	}
+	size := uint64(hdr[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	keep := size
+	if size > MaxWebSocketMessage {
+		keep, f.truncated = MaxWebSocketMessage, true
+	}
+	var mask [4]byte
+	masked := hdr[1]&0x80 != 0
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	f.payload = make([]byte, keep)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if _, err := io.CopyN(ioutil.Discard, r, int64(size-keep)); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if masked {
+		for i := range f.payload {
+			f.payload[i] ^= mask[i%4]
+		}
+	}
+	return f, nil
+}
+
+// recordWebSocket records the messages sent & received over a WebSocket
+// connection after the handshake recorded by the handshake response record
+func (rc *RawCapture) recordWebSocket(handshake *Record, sent, received *bufio.Reader) error {
+	target := handshake.TargetURI()
+	if strings.HasPrefix(target, "https:") {
+		target = "wss:" + strings.TrimPrefix(target, "https:")
+	} else if strings.HasPrefix(target, "http:") {
+		target = "ws:" + strings.TrimPrefix(target, "http:")
+	}
+	compressed := strings.Contains(handshakeExtensions(handshake), "permessage-deflate")
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- rc.recordWebSocketFrames(handshake, target, "client", compressed, sent)
+	}()
+	err := rc.recordWebSocketFrames(handshake, target, "server", compressed, received)
+	if clientErr := <-errs; err == nil {
+		err = clientErr
+	}
+	return err
+}
+
+// handshakeExtensions gives the extensions a handshake response accepted
+func handshakeExtensions(handshake *Record) string {
+	res, err := handshake.HTTPResponse()
+	if err != nil {
+		return ""
+	}
+	defer res.Body.Close()
+	return strings.ToLower(strings.Join(res.Header.Values("Sec-WebSocket-Extensions"), ","))
+}
+
+// recordWebSocketFrames records the frames read from r, sent in direction,
+// until the connection closes. Messages are written as resource records,
+// control frames as metadata records
+func (rc *RawCapture) recordWebSocketFrames(handshake *Record, target, direction string, compressed bool, r io.Reader) error {
+	var (
+		opcode    byte
+		deflate   bool
+		truncated bool
+		start     time.Time
+		msg       bytes.Buffer
+		window    []byte
+	)
+	for {
+		f, err := readWebSocketFrame(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+
+		if f.opcode >= wsClose {
+			if err := rc.writeRecords(rc.newWebSocketControlRecord(handshake, target, direction, now, f)); err != nil {
+				return err
+			}
+			if f.opcode == wsClose {
+				return nil
+			}
+			continue
+		}
+		if f.opcode != wsContinuation {
+			opcode, deflate, truncated, start = f.opcode, f.compressed && compressed, false, now
+			msg.Reset()
+		}
+		payload := f.payload
+		if room := MaxWebSocketMessage - msg.Len(); len(payload) > room {
+			payload = payload[:room]
+			truncated = true
+		}
+		msg.Write(payload)
+		truncated = truncated || f.truncated
+		if !f.fin {
+			continue
+		}
+
+		payload = append([]byte(nil), msg.Bytes()...)
+		if deflate {
+			inflated, cut, err := inflateWebSocketMessage(payload, window)
+			if err != nil && !truncated {
+				return err
+			}
+			// a truncated message is recorded as far as it inflates
+			payload, truncated = inflated, truncated || cut
+			// compressors may refer back to earlier messages
+			window = append(window, payload...)
+			if len(window) > 1<<15 {
+				window = window[len(window)-1<<15:]
+			}
+		}
+		rec := rc.newWebSocketMessageRecord(handshake, target, direction, start, opcode, payload)
+		if truncated {
+			rec.Headers.Set(FieldNameWARCTruncated, "length")
+		}
+		if err := rc.writeRecords(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// inflateWebSocketMessage decompresses a permessage-deflate message, using
+// the end of the previous messages as the dictionary. Messages are inflated
+// up to MaxWebSocketMessage bytes, truncated is true if there was more. On
+// error the data inflated before it is returned
+func inflateWebSocketMessage(payload, window []byte) (data []byte, truncated bool, err error) {
+	fr := flate.NewReaderDict(io.MultiReader(bytes.NewReader(payload), strings.NewReader("\x00\x00\xff\xff")), window)
+	defer fr.Close()
+	data, err = ioutil.ReadAll(io.LimitReader(fr, MaxWebSocketMessage+1))
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if len(data) > MaxWebSocketMessage {
+		data, truncated = data[:MaxWebSocketMessage], true
+	}
+	return data, truncated, errors.Wrap(err, "inflating websocket message")
+}
+
+// newWebSocketRecord creates a record of a frame linked to the handshake
+func (rc *RawCapture) newWebSocketRecord(t RecordType, handshake *Record, target string, date time.Time, contentType string, block []byte) *Record {
+	rec := &Record{Format: RecordFormatWarc11, Type: t, Headers: Header{}, Content: bytes.NewBuffer(block)}
+	rec.Headers.Set(FieldNameWARCRecordID, NewUUID())
+	rec.Headers.Set(FieldNameWARCConcurrentTo, handshake.Headers.Get(FieldNameWARCRecordID))
+	rec.Headers.Set(FieldNameWARCTargetURI, target)
+	rec.Headers.Set(FieldNameWARCDate, date.UTC().Format(wsTimeFormat))
+	if ip := handshake.Headers.Get(FieldNameWARCIPAddress); ip != "" {
+		rec.Headers.Set(FieldNameWARCIPAddress, ip)
+	}
+	if rc.WarcinfoID != "" {
+		rec.Headers.Set(FieldNameWARCWarcinfoID, rc.WarcinfoID)
+	}
+	rec.Headers.Set(FieldNameContentType, contentType)
+	rec.Headers.Set(FieldNameWARCBlockDigest, Sha1Digest(block))
+	return rec
+}
+
+// newWebSocketMessageRecord creates a resource record of a message
+func (rc *RawCapture) newWebSocketMessageRecord(handshake *Record, target, direction string, date time.Time, opcode byte, payload []byte) *Record {
+	contentType := fmt.Sprintf("%s; opcode=%s; direction=%s", WebSocketMessageContentType, wsOpcodeNames[opcode], direction)
+	rec := rc.newWebSocketRecord(RecordTypeResource, handshake, target, date, contentType, payload)
+	rec.Headers.Set(FieldNameWARCPayloadDigest, Sha1Digest(payload))
+	return rec
+}
+
+// newWebSocketControlRecord creates a metadata record of a control frame,
+// with an application/warc-fields block:
+//
+//	opcode: close
+//	direction: server
+//	closeCode: 1000
+//	closeReason: done
+func (rc *RawCapture) newWebSocketControlRecord(handshake *Record, target, direction string, date time.Time, f *wsFrame) *Record {
+	block := &bytes.Buffer{}
+	name, ok := wsOpcodeNames[f.opcode]
+	if !ok {
+		name = fmt.Sprintf("0x%x", f.opcode)
+	}
+	fmt.Fprintf(block, "opcode: %s\r\n", name)
+	fmt.Fprintf(block, "direction: %s\r\n", direction)
+	payload := f.payload
+	if f.opcode == wsClose && len(payload) >= 2 {
+		fmt.Fprintf(block, "closeCode: %d\r\n", binary.BigEndian.Uint16(payload))
+		if payload = payload[2:]; len(payload) > 0 && utf8.Valid(payload) {
+			reason := strings.Map(func(r rune) rune {
+				if r == '\r' || r == '\n' {
+					return ' '
+				}
+				return r
+			}, string(payload))
+			fmt.Fprintf(block, "closeReason: %s\r\n", reason)
+			payload = nil
+		}
+	}
+	if len(payload) > 0 {
+		fmt.Fprintf(block, "payload: %s\r\n", base64.StdEncoding.EncodeToString(payload))
+	}
+	return rc.newWebSocketRecord(RecordTypeMetadata, handshake, target, date, "application/warc-fields", block.Bytes())
+}
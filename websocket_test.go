@@ -0,0 +1,213 @@
+package warc
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRawCaptureWebSocket(t *testing.T) {
+	for i, compress := range []bool{false, true} {
+		upgrader := websocket.Upgrader{EnableCompression: compress}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for {
+				typ, msg, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				conn.WriteMessage(typ, msg)
+			}
+		}))
+
+		buf := &bytes.Buffer{}
+		w, err := NewWriterRaw(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc := NewRawCapture(w)
+		dialer := websocket.Dialer{NetDialContext: rc.DialContext(nil), EnableCompression: compress}
+		url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/socket?a=1"
+		conn, _, err := dialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent := []struct {
+			typ int
+			msg string
+		}{
+			{websocket.TextMessage, strings.Repeat("hello, ", 100)},
+			{websocket.BinaryMessage, "\x01\x02\x03"},
+			{websocket.TextMessage, strings.Repeat("hello, ", 100) + "again"},
+		}
+		for _, m := range sent {
+			if err := conn.WriteMessage(m.typ, []byte(m.msg)); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := conn.ReadMessage(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		conn.WriteControl(websocket.PingMessage, []byte("ping!"), time.Now().Add(time.Second))
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				break
+			}
+		}
+		conn.Close()
+		rc.Wait()
+		srv.Close()
+
+		records := readTestRecordsFrom(t, buf)
+		if len(records) < 2 || records[1].Type != RecordTypeResponse || !strings.HasPrefix(records[1].Content.String(), "HTTP/1.1 101 Switching Protocols\r\n") {
+			t.Fatalf("case %d expected handshake request & response records", i)
+		}
+		if negotiated := strings.Contains(records[1].Content.String(), "permessage-deflate"); negotiated != compress {
+			t.Errorf("case %d compression mismatch. expected: %t, got: %t", i, compress, negotiated)
+		}
+		handshakeID := records[1].Headers.Get(FieldNameWARCRecordID)
+
+		messages := map[string][]string{}
+		var controls []string
+		for _, rec := range records[2:] {
+			if got := rec.Headers.Get(FieldNameWARCConcurrentTo); got != handshakeID {
+				t.Errorf("case %d concurrent to mismatch. expected: %s, got: %s", i, handshakeID, got)
+			}
+			if rec.TargetURI() != url {
+				t.Errorf("case %d target mismatch. expected: %s, got: %s", i, url, rec.TargetURI())
+			}
+			if rec.Date().IsZero() || !strings.Contains(rec.Headers.Get(FieldNameWARCDate), ".") {
+				t.Errorf("case %d expected fractional date, got: %s", i, rec.Headers.Get(FieldNameWARCDate))
+			}
+			if rec.Format != RecordFormatWarc11 {
+				t.Errorf("case %d format mismatch. expected: %s, got: %s", i, RecordFormatWarc11, rec.Format)
+			}
+			switch rec.Type {
+			case RecordTypeResource:
+				ct := rec.Headers.Get(FieldNameContentType)
+				messages[ct] = append(messages[ct], rec.Content.String())
+			case RecordTypeMetadata:
+				controls = append(controls, rec.Content.String())
+			default:
+				t.Errorf("case %d unexpected record type: %s", i, rec.Type)
+			}
+		}
+
+		for _, direction := range []string{"client", "server"} {
+			text := messages[WebSocketMessageContentType+"; opcode=text; direction="+direction]
+			binary := messages[WebSocketMessageContentType+"; opcode=binary; direction="+direction]
+			if len(text) != 2 || text[0] != sent[0].msg || text[1] != sent[2].msg {
+				t.Errorf("case %d %s text messages mismatch. got: %q", i, direction, text)
+			}
+			if len(binary) != 1 || binary[0] != sent[1].msg {
+				t.Errorf("case %d %s binary messages mismatch. got: %q", i, direction, binary)
+			}
+		}
+		expectControls := []string{
+			"opcode: ping\r\ndirection: client\r\npayload: cGluZyE=\r\n",
+			"opcode: pong\r\ndirection: server\r\npayload: cGluZyE=\r\n",
+			"opcode: close\r\ndirection: client\r\ncloseCode: 1000\r\ncloseReason: bye\r\n",
+			"opcode: close\r\ndirection: server\r\ncloseCode: 1000\r\n",
+		}
+		for _, expect := range expectControls {
+			found := false
+			for _, c := range controls {
+				found = found || c == expect
+			}
+			if !found {
+				t.Errorf("case %d missing control frame record: %q, got: %q", i, expect, controls)
+			}
+		}
+	}
+}
+
+func TestRecordWebSocketTruncated(t *testing.T) {
+	frames := &bytes.Buffer{}
+	frames.Write(testWebSocketFrame(true, wsText, bytes.Repeat([]byte("a"), MaxWebSocketMessage+5)))
+	frames.Write(testWebSocketFrame(false, wsText, bytes.Repeat([]byte("b"), MaxWebSocketMessage-1)))
+	frames.Write(testWebSocketFrame(true, wsContinuation, bytes.Repeat([]byte("c"), 10)))
+	frames.Write(testWebSocketFrame(true, wsText, []byte("ok")))
+	frames.Write(testWebSocketFrame(true, wsClose, []byte{0x03, 0xe8}))
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := NewRawCapture(w)
+	handshake := &Record{Headers: Header{FieldNameWARCRecordID: NewUUID()}}
+	if err := rc.recordWebSocketFrames(handshake, "ws://example.com/", "server", false, frames); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readTestRecordsFrom(t, buf)
+	cases := []struct {
+		prefix, suffix string
+		size           int
+		truncated      string
+	}{
+		{"a", "a", MaxWebSocketMessage, "length"},
+		{"b", "bc", MaxWebSocketMessage, "length"},
+		{"o", "ok", 2, ""},
+	}
+	if len(records) != len(cases)+1 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", len(cases)+1, len(records))
+	}
+	for i, c := range cases {
+		rec := records[i]
+		content := rec.Content.String()
+		if len(content) != c.size || !strings.HasPrefix(content, c.prefix) || !strings.HasSuffix(content, c.suffix) {
+			t.Errorf("case %d content mismatch. expected %d bytes ending %q, got: %d ending %q", i, c.size, c.suffix, len(content), content[len(content)-2:])
+		}
+		if got := rec.Headers.Get(FieldNameWARCTruncated); got != c.truncated {
+			t.Errorf("case %d truncated mismatch. expected: %q, got: %q", i, c.truncated, got)
+		}
+	}
+
+	// inflated messages are cut at the limit too
+	compressed := &bytes.Buffer{}
+	fw, err := flate.NewWriter(compressed, flate.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(make([]byte, MaxWebSocketMessage+1))
+	fw.Flush()
+	data, truncated, err := inflateWebSocketMessage(bytes.TrimSuffix(compressed.Bytes(), []byte("\x00\x00\xff\xff")), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != MaxWebSocketMessage || !truncated {
+		t.Errorf("inflated message mismatch. expected %d truncated bytes, got: %d %t", MaxWebSocketMessage, len(data), truncated)
+	}
+}
+
+// testWebSocketFrame encodes an unmasked frame
+func testWebSocketFrame(fin bool, opcode byte, payload []byte) []byte {
+	frame := []byte{opcode, 0}
+	if fin {
+		frame[0] |= 0x80
+	}
+	switch size := len(payload); {
+	case size < 126:
+		frame[1] = byte(size)
+	case size <= 0xffff:
+		frame[1] = 126
+		frame = binary.BigEndian.AppendUint16(frame, uint16(size))
+	default:
+		frame[1] = 127
+		frame = binary.BigEndian.AppendUint64(frame, uint64(size))
+	}
+	return append(frame, payload...)
+}
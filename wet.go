@@ -0,0 +1,205 @@
+package warc
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/language"
+)
+
+// WriteWET writes a WET conversion record holding the visible text of each
+// HTML response & resource record in the WARC file read from r, returning
+// the number of records written. Records that can't be decoded, eg: br
+// content, are skipped & logged with the log package's standard logger
+func WriteWET(w *Writer, r io.Reader) (int, error) {
+	rdr, err := NewOffsetReader(r)
+	if err != nil {
+		return 0, err
+	}
+	written := 0
+	for {
+		rec, _, _, err := rdr.Read()
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+		conv, err := NewWETRecord(rec)
+		if err != nil {
+			log.Printf("warc: skipping record %s: %s", rec.ID(), err)
+			continue
+		}
+		if conv == nil {
+			continue
+		}
+		if _, _, err := w.WriteRecord(conv); err != nil {
+			return written, err
+		}
+		written++
+	}
+}
+
+// NewWETRecord creates a conversion record holding the visible text of an
+// HTML response or resource record, one line per block of text. Scripts and
+// styles are skipped, and text is converted to UTF-8 from the charset given
+// by HTTP headers or <meta> tags. WARC-Refers-To is set to rec's id, and
+// WARC-Identified-Content-Language to the language the page declares with
+// an <html lang> attribute, Content-Language <meta> tag or header, if any.
+// The language isn't detected from the text. Returns nil if rec isn't HTML
+func NewWETRecord(rec *Record) (*Record, error) {
+	mime, _ := recordMimeStatus(rec)
+	if mime != "text/html" || (rec.Type != RecordTypeResponse && rec.Type != RecordTypeResource) {
+		return nil, nil
+	}
+	body, res, err := htmlPayload(rec)
+	if err != nil {
+		return nil, err
+	}
+	if res != nil {
+		defer res.Body.Close()
+	}
+	text, lang, err := htmlVisibleText(body)
+	if err != nil {
+		return nil, err
+	}
+	if res != nil && lang == "" {
+		lang = contentLanguage(res.Header.Get("Content-Language"))
+	}
+
+	conv := &Record{Format: RecordFormatWarc, Type: RecordTypeConversion, Headers: Header{}, Content: bytes.NewBufferString(text)}
+	conv.Headers.Set(FieldNameWARCRecordID, NewUUID())
+	conv.Headers.Set(FieldNameWARCRefersTo, rec.Headers.Get(FieldNameWARCRecordID))
+	conv.Headers.Set(FieldNameWARCTargetURI, rec.TargetURI())
+	conv.Headers.Set(FieldNameWARCDate, rec.Headers.Get(FieldNameWARCDate))
+	if conv.Headers.Get(FieldNameWARCDate) == "" {
+		conv.Headers.Set(FieldNameWARCDate, time.Now().UTC().Format(TimeFormat))
+	}
+	conv.Headers.Set(FieldNameContentType, "text/plain")
+	conv.Headers.Set(FieldNameWARCBlockDigest, Sha1Digest(conv.Content.Bytes()))
+	if lang != "" {
+		conv.Headers.Set(FieldNameWARCIdentifiedContentLanguage, lang)
+	}
+	return conv, nil
+}
+
+// htmlPayload gives the payload of a response or resource record holding
+// HTML, decoded to UTF-8. For responses the parsed HTTP response is also
+// returned, which should be closed once the payload is read
+func htmlPayload(rec *Record) (io.Reader, *http.Response, error) {
+	if rec.Type != RecordTypeResponse {
+		body, err := charset.NewReader(bytes.NewReader(rec.Content.Bytes()), rec.Headers.Get(FieldNameContentType))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "warc: decoding charset")
+		}
+		return body, nil, nil
+	}
+	res, err := rec.HTTPResponse()
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := decodedHTMLBody(res)
+	if err != nil {
+		res.Body.Close()
+		return nil, nil, err
+	}
+	return body, res, nil
+}
+
+// blockElements start a new line of text
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true,
+	"br": true, "dd": true, "div": true, "dl": true, "dt": true,
+	"fieldset": true, "figcaption": true, "figure": true, "footer": true,
+	"form": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true,
+	"h6": true, "header": true, "hr": true, "li": true, "main": true,
+	"nav": true, "ol": true, "option": true, "p": true, "pre": true,
+	"section": true, "table": true, "td": true, "th": true, "title": true,
+	"tr": true, "ul": true,
+}
+
+// htmlVisibleText extracts the text of an HTML page, one line per block,
+// skipping the contents of <script> and <style> elements. lang is the
+// ISO 639-3 code of the language declared by the page, if any
+func htmlVisibleText(r io.Reader) (text, lang string, err error) {
+	buf := &strings.Builder{}
+	line := []string{}
+	endLine := func() {
+		if len(line) > 0 {
+			buf.WriteString(strings.Join(line, " "))
+			buf.WriteByte('\n')
+			line = line[:0]
+		}
+	}
+
+	z := html.NewTokenizer(r)
+	skip := 0
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			endLine()
+			if z.Err() == io.EOF {
+				return buf.String(), lang, nil
+			}
+			return buf.String(), lang, z.Err()
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			tok := z.Token()
+			if blockElements[tok.Data] {
+				endLine()
+			}
+			switch tok.Data {
+			case "script", "style":
+				if tt == html.StartTagToken {
+					skip++
+				} else if tt == html.EndTagToken && skip > 0 {
+					skip--
+				}
+			case "html":
+				if tt == html.StartTagToken && lang == "" {
+					lang = contentLanguage(attrValue(tok, "lang"))
+				}
+			case "meta":
+				if lang == "" && strings.EqualFold(attrValue(tok, "http-equiv"), "content-language") {
+					lang = contentLanguage(attrValue(tok, "content"))
+				}
+			}
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			line = append(line, strings.Fields(string(z.Text()))...)
+		}
+	}
+}
+
+func attrValue(tok html.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// contentLanguage converts the first recognized tag in a comma-separated
+// list of language tags (eg: "en-US, fr") to an ISO 639-3 code ("eng")
+func contentLanguage(tags string) string {
+	for _, t := range strings.Split(tags, ",") {
+		tag, err := language.Parse(strings.TrimSpace(t))
+		if err != nil {
+			continue
+		}
+		if base, conf := tag.Base(); conf != language.No {
+			return base.ISO3()
+		}
+	}
+	return ""
+}
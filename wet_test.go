@@ -0,0 +1,134 @@
+package warc
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWriteWET(t *testing.T) {
+	f, err := os.Open("testdata/warcio/example.warc.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := WriteWET(w, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("record count mismatch. expected: %d, got: %d", 1, n)
+	}
+
+	records := readTestRecordsFrom(t, buf)
+	if len(records) != 1 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", 1, len(records))
+	}
+	rec := records[0]
+	if rec.Type != RecordTypeConversion || rec.Headers.Get(FieldNameContentType) != "text/plain" {
+		t.Errorf("expected text conversion record, got: %s %s", rec.Type, rec.Headers.Get(FieldNameContentType))
+	}
+	if rec.Headers.Get(FieldNameWARCRefersTo) != "<urn:uuid:a9c51e3e-0221-11e7-bf66-0242ac120005>" {
+		t.Errorf("refers to mismatch. got: %s", rec.Headers.Get(FieldNameWARCRefersTo))
+	}
+	expect := "Example Domain\nExample Domain\nThis domain is established to be used for illustrative examples in documents. You may use this domain in examples without prior coordination or asking for permission.\nMore information...\n"
+	if rec.Content.String() != expect {
+		t.Errorf("text mismatch. expected: %q, got: %q", expect, rec.Content.String())
+	}
+	report, err := Validate(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Valid() {
+		t.Errorf("expected valid WARC, got issues: %v", report.Issues)
+	}
+}
+
+func TestNewWETRecord(t *testing.T) {
+	cases := []struct {
+		contentType, body string
+		text, lang        string
+	}{
+		{"text/html", "<html lang=\"fr-CA\"><head><meta charset=\"iso-8859-1\"><title>Caf\xe9</title><style>p { color: red }</style></head><body><p>Un <b>petit</b>\n caf\xe9</p><script>var a = '<p>';</script><ul><li>a</li><li>b</li></ul></body></html>", "Café\nUn petit café\na\nb\n", "fra"},
+		{"text/html; charset=windows-1252", "<p>\x93quoted\x94</p>", "“quoted”\n", ""},
+		{"text/html", "<meta http-equiv=\"Content-Language\" content=\"de, en\"><p>Hallo</p>", "Hallo\n", "deu"},
+		{"text/html", "<p>no language</p>", "no language\n", ""},
+	}
+
+	for i, c := range cases {
+		rec := &Record{
+			Format: RecordFormatWarc,
+			Type:   RecordTypeResource,
+			Headers: Header{
+				FieldNameWARCRecordID:  NewUUID(),
+				FieldNameWARCDate:      "2017-03-06T04:02:06Z",
+				FieldNameWARCTargetURI: "http://example.com/",
+				FieldNameContentType:   c.contentType,
+			},
+			Content: bytes.NewBufferString(c.body),
+		}
+		conv, err := NewWETRecord(rec)
+		if err != nil {
+			t.Errorf("case %d unexpected error: %s", i, err)
+			continue
+		}
+		if conv.Content.String() != c.text {
+			t.Errorf("case %d text mismatch. expected: %q, got: %q", i, c.text, conv.Content.String())
+		}
+		if got := conv.Headers.Get(FieldNameWARCIdentifiedContentLanguage); got != c.lang {
+			t.Errorf("case %d language mismatch. expected: %q, got: %q", i, c.lang, got)
+		}
+		if conv.Headers.Get(FieldNameWARCRefersTo) != rec.Headers.Get(FieldNameWARCRecordID) {
+			t.Errorf("case %d expected conversion to refer to source record", i)
+		}
+	}
+
+	records := readTestRecordsFrom(t, testResponseWARC(t, "2017-03-06T04:02:06Z", "http://example.com/", "200 OK", "<p>hi</p>"))
+	records[0].Content = bytes.NewBufferString("HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Language: es\r\n\r\n<p>hola</p>")
+	conv, err := NewWETRecord(records[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conv.Content.String() != "hola\n" || conv.Headers.Get(FieldNameWARCIdentifiedContentLanguage) != "spa" {
+		t.Errorf("expected spanish text, got: %q %q", conv.Content.String(), conv.Headers.Get(FieldNameWARCIdentifiedContentLanguage))
+	}
+
+	rec := &Record{Format: RecordFormatWarc, Type: RecordTypeResource, Headers: Header{FieldNameContentType: "image/png"}, Content: &bytes.Buffer{}}
+	if conv, err := NewWETRecord(rec); conv != nil || err != nil {
+		t.Errorf("expected no conversion of non-html record, got: %v %v", conv, err)
+	}
+}
+
+func TestWriteWETUndecodable(t *testing.T) {
+	logged := &bytes.Buffer{}
+	log.SetOutput(logged)
+	defer log.SetOutput(os.Stderr)
+
+	buf := &bytes.Buffer{}
+	w, err := NewWriterRaw(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := testEncodedResponse("http://example.com/a", "br", "\x8b\x03\x80<p>a</p>")
+	n, err := WriteWET(w, testRecordsWARC(t, br, testEncodedResponse("http://example.com/b", "identity", "<p>b</p>")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("record count mismatch. expected: %d, got: %d", 1, n)
+	}
+	records := readTestRecordsFrom(t, buf)
+	if got := records[0].TargetURI(); got != "http://example.com/b" {
+		t.Errorf("target uri mismatch. expected: %s, got: %s", "http://example.com/b", got)
+	}
+	if !strings.Contains(logged.String(), br.ID()) {
+		t.Errorf("expected skipped record %s to be logged, got: %s", br.ID(), logged.String())
+	}
+}